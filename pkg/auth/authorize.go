@@ -26,6 +26,12 @@ func (i *Identity) Authenticated(id string) *Identity {
 	return i
 }
 
+// IsAuthenticated returns whether the identity was authenticated for id, such
+// as by the hook of Authorize, regardless of the user it identifies.
+func (i *Identity) IsAuthenticated(id string) bool {
+	return i.authenticatedKeys[id]
+}
+
 const (
 	keyEmail    = "email"
 	keyName     = "name"
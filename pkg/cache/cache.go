@@ -2,11 +2,14 @@ package cache
 
 import (
 	"context"
+	stderrors "errors"
+	"io"
 	"time"
 
 	"github.com/gantries/knife/pkg/errors"
 	"github.com/gantries/knife/pkg/lists"
 	"github.com/gantries/knife/pkg/national"
+	"github.com/redis/go-redis/v9"
 )
 
 type Cache interface {
@@ -21,16 +24,91 @@ type Cache interface {
 	HDel(ctx context.Context, key string, fields ...string) (int64, error)
 	HGet(ctx context.Context, key, field string) (string, error)
 	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	// HLen returns how many fields the hash of key holds.
+	HLen(ctx context.Context, key string) (int64, error)
 	HSet(ctx context.Context, key string, values ...interface{}) (int64, error)
 	LPop(ctx context.Context, key string) (string, error)
 	RPush(ctx context.Context, key string, fields ...string) (int64, error)
+	// LMove pops an element from the srcpos end, "LEFT" or "RIGHT", of source
+	// and pushes it to the destpos end of destination atomically. It returns
+	// Nil when source is empty, in cluster mode both keys must share a slot.
+	LMove(ctx context.Context, source, destination, srcpos, destpos string) (string, error)
+	// LRem removes the first count occurrences of value from key, the last
+	// ones when count is negative and all of them when it is zero.
+	LRem(ctx context.Context, key string, count int64, value interface{}) (int64, error)
+	// LRange returns the elements of key from start to stop, negative indexes
+	// count from the tail.
+	LRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+	// SetNX sets key only when it does not exist yet, it reports whether the
+	// value was stored.
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error)
+	Incr(ctx context.Context, key string) (int64, error)
+	IncrBy(ctx context.Context, key string, value int64) (int64, error)
+	// Expire reports false when key does not exist, a non-positive expiration
+	// deletes the key.
+	Expire(ctx context.Context, key string, expiration time.Duration) (bool, error)
+	// TTL returns the time to live of key in seconds precision, -1 when it
+	// never expires and -2 when it does not exist.
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	Persist(ctx context.Context, key string) (bool, error)
+	SAdd(ctx context.Context, key string, members ...interface{}) (int64, error)
+	SRem(ctx context.Context, key string, members ...interface{}) (int64, error)
+	SIsMember(ctx context.Context, key string, member interface{}) (bool, error)
+	SMembers(ctx context.Context, key string) ([]string, error)
+	SCard(ctx context.Context, key string) (int64, error)
+	ZAdd(ctx context.Context, key string, members ...Z) (int64, error)
+	ZIncrBy(ctx context.Context, key string, increment float64, member string) (float64, error)
+	ZRem(ctx context.Context, key string, members ...interface{}) (int64, error)
+	// ZScore and the rank commands return Nil for missing members.
+	ZScore(ctx context.Context, key, member string) (float64, error)
+	ZRank(ctx context.Context, key, member string) (int64, error)
+	ZRevRank(ctx context.Context, key, member string) (int64, error)
+	ZCard(ctx context.Context, key string) (int64, error)
+	// ZRange returns the members ranked from start to stop by ascending
+	// score, negative indexes count from the highest score.
+	ZRange(ctx context.Context, key string, start, stop int64) ([]Z, error)
+	// ZRevRange is ZRange by descending score.
+	ZRevRange(ctx context.Context, key string, start, stop int64) ([]Z, error)
+	// ZRangeByScore returns the members with scores between opt.Min and
+	// opt.Max, "(" makes a bound exclusive and "-inf"/"+inf" are unbounded.
+	ZRangeByScore(ctx context.Context, key string, opt *ZRangeBy) ([]Z, error)
+	// MGet returns the string values of keys, missing keys are left out.
+	MGet(ctx context.Context, keys ...string) (map[string]string, error)
+	// MSet stores values, every key expires after expiration when positive.
+	MSet(ctx context.Context, values map[string]interface{}, expiration time.Duration) error
+	// MHGetAll returns the hashes of keys, missing keys are left out.
+	MHGetAll(ctx context.Context, keys ...string) (map[string]map[string]string, error)
+	// Pipeline sends the commands queued by fn in one round trip, nothing is
+	// sent when fn fails. It returns the first failure of the commands.
+	Pipeline(ctx context.Context, fn func(p Pipeliner) error) error
 }
 
 type Type string
 
+// Mode tells how the redis nodes listed in Properties.Addresses are deployed.
+type Mode string
+
 type Credential struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
+	// PasswordFile is read when set, it takes precedence over Password.
+	PasswordFile string `yaml:"password_file"`
+}
+
+// Failover describes a sentinel managed deployment, Properties.Addresses are
+// the sentinel nodes then.
+type Failover struct {
+	MasterName string     `json:"master_name" yaml:"master_name"`
+	Credential Credential `json:"credential" yaml:"credential"` // of the sentinel nodes
+}
+
+type TLS struct {
+	Enabled            bool   `json:"enabled" yaml:"enabled" default:"false"`
+	CAFile             string `json:"ca_file" yaml:"ca_file"`
+	CertFile           string `json:"cert_file" yaml:"cert_file"`
+	KeyFile            string `json:"key_file" yaml:"key_file"`
+	ServerName         string `json:"server_name" yaml:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify" default:"false"`
 }
 
 type Pool struct {
@@ -45,20 +123,85 @@ type Pool struct {
 
 type Properties struct {
 	Type       Type               `yaml:"type" default:"redis"`
+	Mode       Mode               `yaml:"mode"` // inferred from the number of addresses when empty
 	Addresses  lists.List[string] `yaml:"addresses"`
 	Database   int                `yaml:"database" default:"0"`
 	Credential Credential         `yaml:"credential"`
+	Failover   Failover           `yaml:"failover"`
+	TLS        TLS                `yaml:"tls"`
 	Pool       Pool               `yaml:"pool"`
+	Near       Near               `yaml:"near"`
+	Namespace  Namespace          `yaml:"namespace"`
+	// Telemetry traces and measures every operation, see InstrumentedCache.
+	Telemetry bool `yaml:"telemetry" default:"false"`
 }
 
 const (
-	Redis Type = "redis"
+	Redis  Type = "redis"
+	Memory Type = "memory"
+)
+
+const (
+	Standalone Mode = "standalone"
+	Cluster    Mode = "cluster"
+	Sentinel   Mode = "sentinel"
+)
+
+// Nil is returned when the requested key or field does not exist.
+const Nil = redis.Nil
+
+type PoolStats = redis.PoolStats
+
+type (
+	// Z is a member of a sorted set with its score.
+	Z        = redis.Z
+	ZRangeBy = redis.ZRangeBy
 )
 
 func New(ctxt context.Context, cfg *Properties) (Cache, *national.Message) {
+	var c Cache
 	switch cfg.Type {
 	case Redis:
-		return NewRedis(ctxt, cfg)
+		r, m := NewRedis(ctxt, cfg)
+		if !m.Fine() {
+			return nil, m
+		}
+		c = r
+		if cfg.Telemetry {
+			observePool(r)
+		}
+		if cfg.Near.Enabled {
+			if c, m = NewNear(ctxt, r, &cfg.Near); !m.Fine() {
+				return nil, m
+			}
+		}
+	case Memory:
+		c, _ = NewMemory(ctxt, cfg)
+	default:
+		return nil, errors.UnrecognizedError.Build("type", "cache", "value", cfg.Type)
+	}
+	if cfg.Namespace.enabled() {
+		c = NewNamespaced(c, &cfg.Namespace)
+	}
+	if cfg.Telemetry {
+		c = NewInstrumented(c)
+	}
+	return c, errors.Yes()
+}
+
+// Close closes c and the caches it wraps, such as the subscription of a near
+// cache and the client of a redis cache.
+func Close(c Cache) error {
+	var errs []error
+	for c != nil {
+		if closer, ok := c.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+		w, ok := c.(interface{ Unwrap() Cache })
+		if !ok {
+			break
+		}
+		c = w.Unwrap()
 	}
-	return nil, errors.UnrecognizedError.Build("type", "cache", "value", cfg.Type)
+	return stderrors.Join(errs...)
 }
@@ -5,6 +5,12 @@ import (
 	"time"
 )
 
+var _ Cache = CacheMock{}
+
+// CacheMock answers every call with a fixed value.
+//
+// Deprecated: use cachetest.Fake, whose responses can be scripted and whose
+// calls are recorded.
 type CacheMock struct {
 }
 
@@ -41,6 +47,9 @@ func (c CacheMock) HGet(ctx context.Context, key, field string) (string, error)
 func (c CacheMock) HGetAll(ctx context.Context, key string) (map[string]string, error) {
 	return map[string]string{"mock": "true"}, nil
 }
+func (c CacheMock) HLen(ctx context.Context, key string) (int64, error) {
+	return 1, nil
+}
 func (c CacheMock) HSet(ctx context.Context, key string, values ...interface{}) (int64, error) {
 	return 1, nil
 }
@@ -50,3 +59,98 @@ func (c CacheMock) LPop(ctx context.Context, key string) (string, error) {
 func (c CacheMock) RPush(ctx context.Context, key string, fields ...string) (int64, error) {
 	return 1, nil
 }
+func (c CacheMock) LMove(ctx context.Context, source, destination, srcpos, destpos string) (string, error) {
+	return "mock", nil
+}
+func (c CacheMock) LRem(ctx context.Context, key string, count int64, value interface{}) (int64, error) {
+	return 1, nil
+}
+func (c CacheMock) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return []string{"mock"}, nil
+}
+func (c CacheMock) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	return true, nil
+}
+func (c CacheMock) Incr(ctx context.Context, key string) (int64, error) {
+	return 1, nil
+}
+func (c CacheMock) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	return value, nil
+}
+func (c CacheMock) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	return true, nil
+}
+func (c CacheMock) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return -1, nil
+}
+func (c CacheMock) Persist(ctx context.Context, key string) (bool, error) {
+	return true, nil
+}
+func (c CacheMock) SAdd(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return int64(len(members)), nil
+}
+func (c CacheMock) SRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return int64(len(members)), nil
+}
+func (c CacheMock) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	return true, nil
+}
+func (c CacheMock) SMembers(ctx context.Context, key string) ([]string, error) {
+	return []string{"mock"}, nil
+}
+func (c CacheMock) SCard(ctx context.Context, key string) (int64, error) {
+	return 1, nil
+}
+func (c CacheMock) ZAdd(ctx context.Context, key string, members ...Z) (int64, error) {
+	return int64(len(members)), nil
+}
+func (c CacheMock) ZIncrBy(ctx context.Context, key string, increment float64, member string) (float64, error) {
+	return increment, nil
+}
+func (c CacheMock) ZRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return int64(len(members)), nil
+}
+func (c CacheMock) ZScore(ctx context.Context, key, member string) (float64, error) {
+	return 1, nil
+}
+func (c CacheMock) ZRank(ctx context.Context, key, member string) (int64, error) {
+	return 0, nil
+}
+func (c CacheMock) ZRevRank(ctx context.Context, key, member string) (int64, error) {
+	return 0, nil
+}
+func (c CacheMock) ZCard(ctx context.Context, key string) (int64, error) {
+	return 1, nil
+}
+func (c CacheMock) ZRange(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	return []Z{{Score: 1, Member: "mock"}}, nil
+}
+func (c CacheMock) ZRevRange(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	return []Z{{Score: 1, Member: "mock"}}, nil
+}
+func (c CacheMock) ZRangeByScore(ctx context.Context, key string, opt *ZRangeBy) ([]Z, error) {
+	return []Z{{Score: 1, Member: "mock"}}, nil
+}
+func (c CacheMock) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	r := make(map[string]string, len(keys))
+	for _, k := range keys {
+		r[k] = "mock"
+	}
+	return r, nil
+}
+func (c CacheMock) MSet(ctx context.Context, values map[string]interface{}, expiration time.Duration) error {
+	return nil
+}
+func (c CacheMock) MHGetAll(ctx context.Context, keys ...string) (map[string]map[string]string, error) {
+	r := make(map[string]map[string]string, len(keys))
+	for _, k := range keys {
+		r[k] = map[string]string{"mock": "true"}
+	}
+	return r, nil
+}
+
+// Pipeline runs fn against an empty in-memory cache.
+func (c CacheMock) Pipeline(ctx context.Context, fn func(p Pipeliner) error) error {
+	m, _ := NewMemory(ctx, nil)
+	return m.Pipeline(ctx, fn)
+}
@@ -13,4 +13,6 @@ func Test_Properties(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, p.Pool.MaxConnectionIdleTime == time.Hour*1)
 	assert.True(t, p.Pool.MaxConnectionLifeTime == time.Hour*10)
+	assert.False(t, p.Near.Enabled)
+	assert.True(t, p.Near.TTL == time.Minute)
 }
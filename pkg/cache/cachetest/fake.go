@@ -0,0 +1,231 @@
+// Package cachetest provides a programmable cache.Cache for tests.
+//
+// A Fake keeps its data in a cache.MemoryCache. Rules registered with On
+// replace the result of matching calls, inject errors or delay them, and every
+// call is recorded for assertions:
+//
+//	f := cachetest.New()
+//	f.On("Get", "user:1").Return("alice")
+//	f.On("HSet", cachetest.Any).Fail(errors.New("unavailable")).Times(1)
+//	f.On(cachetest.Any, cachetest.Any).Delay(10 * time.Millisecond)
+//	...
+//	assert.Equal(t, []string{"user:1"}, f.Keys("Get"))
+package cachetest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gantries/knife/pkg/cache"
+)
+
+// Any matches every method or key in On.
+const Any = ""
+
+// Call is a recorded invocation, Args holds the arguments besides the context
+// and the keys.
+type Call struct {
+	Method string
+	Keys   []string
+	Args   []interface{}
+}
+
+// Rule changes the outcome of the calls matching its method and key.
+type Rule struct {
+	mutex    *sync.Mutex // of the fake, which reads the rule concurrently
+	method   string
+	key      string
+	value    interface{}
+	returns  bool
+	err      error
+	delay    time.Duration
+	times    int
+	consumed int
+}
+
+// Return makes matching calls return v instead of consulting the data,
+// numbers are converted to the type returned by the method.
+func (r *Rule) Return(v interface{}) *Rule {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.value, r.returns = v, true
+	return r
+}
+
+// Fail makes matching calls return err, use cache.Nil to simulate a miss.
+func (r *Rule) Fail(err error) *Rule {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.err = err
+	return r
+}
+
+// Delay holds matching calls back for d, or until their context is done.
+func (r *Rule) Delay(d time.Duration) *Rule {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.delay = d
+	return r
+}
+
+// Times limits the rule to the next n matching calls, it applies to all of
+// them by default.
+func (r *Rule) Times(n int) *Rule {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.times = n
+	return r
+}
+
+func (r *Rule) responds() bool {
+	return r.returns || r.err != nil
+}
+
+func (r *Rule) matches(method string, keys []string) bool {
+	if r.times > 0 && r.consumed >= r.times {
+		return false
+	}
+	if r.method != Any && r.method != method {
+		return false
+	}
+	if r.key == Any {
+		return true
+	}
+	for _, k := range keys {
+		if k == r.key {
+			return true
+		}
+	}
+	return false
+}
+
+// Fake is a cache.Cache whose responses are scripted by rules, calls without
+// a responding rule are served from memory.
+type Fake struct {
+	cache *cache.MemoryCache
+	mutex sync.Mutex
+	rules []*Rule
+	calls []Call
+}
+
+func New() *Fake {
+	c, _ := cache.NewMemory(context.Background(), &cache.Properties{Type: cache.Memory})
+	return &Fake{cache: c}
+}
+
+// On adds a rule for method and key, rules added later take precedence.
+func (f *Fake) On(method, key string) *Rule {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	r := &Rule{mutex: &f.mutex, method: method, key: key}
+	f.rules = append(f.rules, r)
+	return r
+}
+
+// Calls returns the recorded calls of method, or all of them for Any.
+func (f *Fake) Calls(method string) []Call {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var r []Call
+	for _, c := range f.calls {
+		if method == Any || c.Method == method {
+			r = append(r, c)
+		}
+	}
+	return r
+}
+
+// Keys returns the keys touched by the calls of method, or all of them for Any.
+func (f *Fake) Keys(method string) []string {
+	var r []string
+	for _, c := range f.Calls(method) {
+		r = append(r, c.Keys...)
+	}
+	return r
+}
+
+// Reset drops rules and recorded calls, the data is kept.
+func (f *Fake) Reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.rules, f.calls = nil, nil
+}
+
+// intercept records the call and applies the matching rules. It returns the
+// responding rule, or nil when the call should be served from memory.
+func (f *Fake) intercept(ctx context.Context, method string, keys []string, args ...interface{}) (*Rule, error) {
+	f.mutex.Lock()
+	f.calls = append(f.calls, Call{Method: method, Keys: keys, Args: args})
+	var delay time.Duration
+	var respond *Rule
+	for i := len(f.rules) - 1; i >= 0 && (respond == nil || delay == 0); i-- {
+		r := f.rules[i]
+		if !r.matches(method, keys) {
+			continue
+		}
+		used := false
+		if r.delay > 0 && delay == 0 {
+			delay, used = r.delay, true
+		}
+		if r.responds() && respond == nil {
+			// A copy, the rule may be changed meanwhile
+			c := *r
+			respond, used = &c, true
+		}
+		if used {
+			r.consumed++
+		}
+	}
+	f.mutex.Unlock()
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return respond, nil
+}
+
+// failure returns the error of an intercepted call.
+func failure(r *Rule, err error) error {
+	if err != nil || r == nil {
+		return err
+	}
+	return r.err
+}
+
+func isNumber(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
+
+// result converts the value of r into the type returned by the method.
+func result[T any](r *Rule) T {
+	var zero T
+	if r == nil || r.value == nil {
+		return zero
+	}
+	if v, ok := r.value.(T); ok {
+		return v
+	}
+	v, t := reflect.ValueOf(r.value), reflect.TypeOf(zero)
+	if isNumber(v.Kind()) && isNumber(t.Kind()) {
+		return v.Convert(t).Interface().(T)
+	}
+	panic(fmt.Sprintf("cachetest: %s returns %T, not %T", r.method, zero, r.value))
+}
+
+func keysOf(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
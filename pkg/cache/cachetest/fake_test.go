@@ -0,0 +1,133 @@
+package cachetest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake_Memory(t *testing.T) {
+	ctxt := context.Background()
+	f := New()
+
+	_, e := f.Set(ctxt, "k1", "v1", time.Minute)
+	assert.Nil(t, e)
+	v, e := f.Get(ctxt, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "v1", v)
+	_, e = f.Get(ctxt, "k2")
+	assert.ErrorIs(t, e, cache.Nil)
+
+	assert.Equal(t, []string{"k1", "k1", "k2"}, f.Keys(Any))
+	calls := f.Calls("Set")
+	assert.Len(t, calls, 1)
+	assert.Equal(t, []interface{}{"v1", time.Minute}, calls[0].Args)
+}
+
+func TestFake_Rules(t *testing.T) {
+	ctxt := context.Background()
+	f := New()
+	failure := fmt.Errorf("unavailable")
+
+	f.On("Get", Any).Return("any")
+	f.On("Get", "k1").Return("v1")
+	f.On("Get", "k2").Fail(cache.Nil)
+	f.On("Incr", Any).Return(42)
+	f.On(Any, "k3").Fail(failure).Times(1)
+
+	v, e := f.Get(ctxt, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "v1", v, "later rules take precedence")
+	_, e = f.Get(ctxt, "k2")
+	assert.ErrorIs(t, e, cache.Nil)
+	v, _ = f.Get(ctxt, "k4")
+	assert.Equal(t, "any", v)
+	n, e := f.Incr(ctxt, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(42), n, "numbers are converted")
+
+	_, e = f.HSet(ctxt, "k3", "f1", "v1")
+	assert.Equal(t, failure, e)
+	_, e = f.HSet(ctxt, "k3", "f1", "v1")
+	assert.Nil(t, e, "only once")
+	h, e := f.HGetAll(ctxt, "k3")
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]string{"f1": "v1"}, h)
+
+	f.On("MSet", "k5").Fail(failure)
+	assert.Equal(t, failure, f.MSet(ctxt, map[string]interface{}{"k5": 1, "k6": 2}, 0))
+	assert.Equal(t, []string{"k5", "k6"}, f.Keys("MSet"))
+
+	f.Reset()
+	assert.Empty(t, f.Calls(Any))
+	v, _ = f.Get(ctxt, "k1")
+	assert.Equal(t, "", v)
+	assert.Panics(t, func() {
+		f.On("Get", "k1").Return(1)
+		_, _ = f.Get(ctxt, "k1")
+	})
+}
+
+func TestFake_Delay(t *testing.T) {
+	ctxt := context.Background()
+	f := New()
+	f.On(Any, Any).Delay(20 * time.Millisecond)
+	f.On("Get", "k1").Return("v1")
+
+	start := time.Now()
+	v, e := f.Get(ctxt, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "v1", v)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(ctxt, time.Millisecond)
+	defer cancel()
+	_, e = f.Get(ctx, "k1")
+	assert.ErrorIs(t, e, context.DeadlineExceeded)
+	assert.ErrorIs(t, f.Ping(ctx), context.DeadlineExceeded)
+}
+
+func TestFake_Pipeline(t *testing.T) {
+	ctxt := context.Background()
+	f := New()
+	failure := fmt.Errorf("unavailable")
+	f.On("Get", "k1").Return("v1")
+	f.On("HSet", "h1").Fail(failure)
+
+	var get, stored *cache.StringCmd
+	var set *cache.IntCmd
+	e := f.Pipeline(ctxt, func(p cache.Pipeliner) error {
+		p.Set(ctxt, "k2", "v2", 0)
+		get, stored = p.Get(ctxt, "k1"), p.Get(ctxt, "k2")
+		set = p.HSet(ctxt, "h1", "f1", "v1")
+		return nil
+	})
+	assert.Equal(t, failure, e)
+	assert.Equal(t, "v1", get.Val())
+	assert.Equal(t, "v2", stored.Val())
+	assert.Equal(t, failure, set.Err())
+	assert.Equal(t, []string{"k1", "k2"}, f.Keys("Get"))
+	n, _ := f.Exists(ctxt, "h1")
+	assert.Equal(t, int64(0), n)
+}
+
+func TestFake_Concurrent(t *testing.T) {
+	ctxt := context.Background()
+	f := New()
+	r := f.On("Get", "k1")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			_, _ = f.Get(ctxt, "k1")
+		}
+	}()
+	for i := range 100 {
+		r.Return(fmt.Sprint(i)).Times(1000)
+	}
+	<-done
+}
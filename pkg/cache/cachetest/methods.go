@@ -0,0 +1,442 @@
+package cachetest
+
+import (
+	"context"
+	stderrors "errors"
+	"time"
+
+	"github.com/gantries/knife/pkg/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ cache.Cache = &Fake{}
+
+func (f *Fake) Ping(ctx context.Context) error {
+	if r, err := f.intercept(ctx, "Ping", nil); err != nil || r != nil {
+		return failure(r, err)
+	}
+	return f.cache.Ping(ctx)
+}
+
+func (f *Fake) Push(ctx context.Context, key string, values ...interface{}) error {
+	if r, err := f.intercept(ctx, "Push", []string{key}, values); err != nil || r != nil {
+		return failure(r, err)
+	}
+	return f.cache.Push(ctx, key, values...)
+}
+
+func (f *Fake) Pop(ctx context.Context, key string) (string, error) {
+	if r, err := f.intercept(ctx, "Pop", []string{key}); err != nil || r != nil {
+		return result[string](r), failure(r, err)
+	}
+	return f.cache.Pop(ctx, key)
+}
+
+func (f *Fake) Count(ctx context.Context, key string) (int64, error) {
+	if r, err := f.intercept(ctx, "Count", []string{key}); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.Count(ctx, key)
+}
+
+func (f *Fake) Del(ctx context.Context, keys ...string) (int64, error) {
+	if r, err := f.intercept(ctx, "Del", keys); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.Del(ctx, keys...)
+}
+
+func (f *Fake) Exists(ctx context.Context, keys ...string) (int64, error) {
+	if r, err := f.intercept(ctx, "Exists", keys); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.Exists(ctx, keys...)
+}
+
+func (f *Fake) Get(ctx context.Context, key string) (string, error) {
+	if r, err := f.intercept(ctx, "Get", []string{key}); err != nil || r != nil {
+		return result[string](r), failure(r, err)
+	}
+	return f.cache.Get(ctx, key)
+}
+
+func (f *Fake) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) (string, error) {
+	if r, err := f.intercept(ctx, "Set", []string{key}, value, expiration); err != nil || r != nil {
+		return result[string](r), failure(r, err)
+	}
+	return f.cache.Set(ctx, key, value, expiration)
+}
+
+func (f *Fake) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	if r, err := f.intercept(ctx, "HDel", []string{key}, fields); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.HDel(ctx, key, fields...)
+}
+
+func (f *Fake) HGet(ctx context.Context, key string, field string) (string, error) {
+	if r, err := f.intercept(ctx, "HGet", []string{key}, field); err != nil || r != nil {
+		return result[string](r), failure(r, err)
+	}
+	return f.cache.HGet(ctx, key, field)
+}
+
+func (f *Fake) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	if r, err := f.intercept(ctx, "HGetAll", []string{key}); err != nil || r != nil {
+		return result[map[string]string](r), failure(r, err)
+	}
+	return f.cache.HGetAll(ctx, key)
+}
+
+func (f *Fake) HLen(ctx context.Context, key string) (int64, error) {
+	if r, err := f.intercept(ctx, "HLen", []string{key}); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.HLen(ctx, key)
+}
+
+func (f *Fake) HSet(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	if r, err := f.intercept(ctx, "HSet", []string{key}, values); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.HSet(ctx, key, values...)
+}
+
+func (f *Fake) LPop(ctx context.Context, key string) (string, error) {
+	if r, err := f.intercept(ctx, "LPop", []string{key}); err != nil || r != nil {
+		return result[string](r), failure(r, err)
+	}
+	return f.cache.LPop(ctx, key)
+}
+
+func (f *Fake) RPush(ctx context.Context, key string, fields ...string) (int64, error) {
+	if r, err := f.intercept(ctx, "RPush", []string{key}, fields); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.RPush(ctx, key, fields...)
+}
+
+func (f *Fake) LMove(ctx context.Context, source, destination, srcpos, destpos string) (string, error) {
+	if r, err := f.intercept(ctx, "LMove", []string{source, destination}, srcpos, destpos); err != nil || r != nil {
+		return result[string](r), failure(r, err)
+	}
+	return f.cache.LMove(ctx, source, destination, srcpos, destpos)
+}
+
+func (f *Fake) LRem(ctx context.Context, key string, count int64, value interface{}) (int64, error) {
+	if r, err := f.intercept(ctx, "LRem", []string{key}, count, value); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.LRem(ctx, key, count, value)
+}
+
+func (f *Fake) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	if r, err := f.intercept(ctx, "LRange", []string{key}, start, stop); err != nil || r != nil {
+		return result[[]string](r), failure(r, err)
+	}
+	return f.cache.LRange(ctx, key, start, stop)
+}
+
+func (f *Fake) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	if r, err := f.intercept(ctx, "SetNX", []string{key}, value, expiration); err != nil || r != nil {
+		return result[bool](r), failure(r, err)
+	}
+	return f.cache.SetNX(ctx, key, value, expiration)
+}
+
+func (f *Fake) Incr(ctx context.Context, key string) (int64, error) {
+	if r, err := f.intercept(ctx, "Incr", []string{key}); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.Incr(ctx, key)
+}
+
+func (f *Fake) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	if r, err := f.intercept(ctx, "IncrBy", []string{key}, value); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.IncrBy(ctx, key, value)
+}
+
+func (f *Fake) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	if r, err := f.intercept(ctx, "Expire", []string{key}, expiration); err != nil || r != nil {
+		return result[bool](r), failure(r, err)
+	}
+	return f.cache.Expire(ctx, key, expiration)
+}
+
+func (f *Fake) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if r, err := f.intercept(ctx, "TTL", []string{key}); err != nil || r != nil {
+		return result[time.Duration](r), failure(r, err)
+	}
+	return f.cache.TTL(ctx, key)
+}
+
+func (f *Fake) Persist(ctx context.Context, key string) (bool, error) {
+	if r, err := f.intercept(ctx, "Persist", []string{key}); err != nil || r != nil {
+		return result[bool](r), failure(r, err)
+	}
+	return f.cache.Persist(ctx, key)
+}
+
+func (f *Fake) SAdd(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	if r, err := f.intercept(ctx, "SAdd", []string{key}, members); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.SAdd(ctx, key, members...)
+}
+
+func (f *Fake) SRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	if r, err := f.intercept(ctx, "SRem", []string{key}, members); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.SRem(ctx, key, members...)
+}
+
+func (f *Fake) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	if r, err := f.intercept(ctx, "SIsMember", []string{key}, member); err != nil || r != nil {
+		return result[bool](r), failure(r, err)
+	}
+	return f.cache.SIsMember(ctx, key, member)
+}
+
+func (f *Fake) SMembers(ctx context.Context, key string) ([]string, error) {
+	if r, err := f.intercept(ctx, "SMembers", []string{key}); err != nil || r != nil {
+		return result[[]string](r), failure(r, err)
+	}
+	return f.cache.SMembers(ctx, key)
+}
+
+func (f *Fake) SCard(ctx context.Context, key string) (int64, error) {
+	if r, err := f.intercept(ctx, "SCard", []string{key}); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.SCard(ctx, key)
+}
+
+func (f *Fake) ZAdd(ctx context.Context, key string, members ...cache.Z) (int64, error) {
+	if r, err := f.intercept(ctx, "ZAdd", []string{key}, members); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.ZAdd(ctx, key, members...)
+}
+
+func (f *Fake) ZIncrBy(ctx context.Context, key string, increment float64, member string) (float64, error) {
+	if r, err := f.intercept(ctx, "ZIncrBy", []string{key}, increment, member); err != nil || r != nil {
+		return result[float64](r), failure(r, err)
+	}
+	return f.cache.ZIncrBy(ctx, key, increment, member)
+}
+
+func (f *Fake) ZRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	if r, err := f.intercept(ctx, "ZRem", []string{key}, members); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.ZRem(ctx, key, members...)
+}
+
+func (f *Fake) ZScore(ctx context.Context, key string, member string) (float64, error) {
+	if r, err := f.intercept(ctx, "ZScore", []string{key}, member); err != nil || r != nil {
+		return result[float64](r), failure(r, err)
+	}
+	return f.cache.ZScore(ctx, key, member)
+}
+
+func (f *Fake) ZRank(ctx context.Context, key string, member string) (int64, error) {
+	if r, err := f.intercept(ctx, "ZRank", []string{key}, member); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.ZRank(ctx, key, member)
+}
+
+func (f *Fake) ZRevRank(ctx context.Context, key string, member string) (int64, error) {
+	if r, err := f.intercept(ctx, "ZRevRank", []string{key}, member); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.ZRevRank(ctx, key, member)
+}
+
+func (f *Fake) ZCard(ctx context.Context, key string) (int64, error) {
+	if r, err := f.intercept(ctx, "ZCard", []string{key}); err != nil || r != nil {
+		return result[int64](r), failure(r, err)
+	}
+	return f.cache.ZCard(ctx, key)
+}
+
+func (f *Fake) ZRange(ctx context.Context, key string, start int64, stop int64) ([]cache.Z, error) {
+	if r, err := f.intercept(ctx, "ZRange", []string{key}, start, stop); err != nil || r != nil {
+		return result[[]cache.Z](r), failure(r, err)
+	}
+	return f.cache.ZRange(ctx, key, start, stop)
+}
+
+func (f *Fake) ZRevRange(ctx context.Context, key string, start int64, stop int64) ([]cache.Z, error) {
+	if r, err := f.intercept(ctx, "ZRevRange", []string{key}, start, stop); err != nil || r != nil {
+		return result[[]cache.Z](r), failure(r, err)
+	}
+	return f.cache.ZRevRange(ctx, key, start, stop)
+}
+
+func (f *Fake) ZRangeByScore(ctx context.Context, key string, opt *cache.ZRangeBy) ([]cache.Z, error) {
+	if r, err := f.intercept(ctx, "ZRangeByScore", []string{key}, opt); err != nil || r != nil {
+		return result[[]cache.Z](r), failure(r, err)
+	}
+	return f.cache.ZRangeByScore(ctx, key, opt)
+}
+
+func (f *Fake) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	if r, err := f.intercept(ctx, "MGet", keys); err != nil || r != nil {
+		return result[map[string]string](r), failure(r, err)
+	}
+	return f.cache.MGet(ctx, keys...)
+}
+
+func (f *Fake) MSet(ctx context.Context, values map[string]interface{}, expiration time.Duration) error {
+	if r, err := f.intercept(ctx, "MSet", keysOf(values), values, expiration); err != nil || r != nil {
+		return failure(r, err)
+	}
+	return f.cache.MSet(ctx, values, expiration)
+}
+
+func (f *Fake) MHGetAll(ctx context.Context, keys ...string) (map[string]map[string]string, error) {
+	if r, err := f.intercept(ctx, "MHGetAll", keys); err != nil || r != nil {
+		return result[map[string]map[string]string](r), failure(r, err)
+	}
+	return f.cache.MHGetAll(ctx, keys...)
+}
+
+func (f *Fake) Pipeline(ctx context.Context, fn func(p cache.Pipeliner) error) error {
+	if r, err := f.intercept(ctx, "Pipeline", nil); err != nil || r != nil {
+		return failure(r, err)
+	}
+	var fp *pipeliner
+	err := f.cache.Pipeline(ctx, func(p cache.Pipeliner) error {
+		fp = &pipeliner{f: f, p: p}
+		return fn(fp)
+	})
+	if err == nil && fp != nil {
+		err = fp.err
+	}
+	return err
+}
+
+// pipeliner records the queued commands like calls of the same methods. The
+// commands answered by a rule are not queued, their failure is returned by
+// Pipeline unless a queued command failed.
+type pipeliner struct {
+	f   *Fake
+	p   cache.Pipeliner
+	err error
+}
+
+// answer completes cmd by r when a rule responds, it returns whether it did.
+func answer[T any](p *pipeliner, cmd interface{ SetVal(T) }, setErr func(error), r *Rule, err error) bool {
+	if err == nil && r == nil {
+		return false
+	}
+	if err = failure(r, err); err != nil {
+		setErr(err)
+		if p.err == nil && !stderrors.Is(err, cache.Nil) {
+			p.err = err
+		}
+		return true
+	}
+	cmd.SetVal(result[T](r))
+	return true
+}
+
+func (p *pipeliner) Get(ctx context.Context, key string) *cache.StringCmd {
+	r, err := p.f.intercept(ctx, "Get", []string{key})
+	if cmd := redis.NewStringCmd(ctx, "get", key); answer[string](p, cmd, cmd.SetErr, r, err) {
+		return cmd
+	}
+	return p.p.Get(ctx, key)
+}
+
+func (p *pipeliner) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *cache.StatusCmd {
+	r, err := p.f.intercept(ctx, "Set", []string{key}, value, expiration)
+	if cmd := redis.NewStatusCmd(ctx, "set", key, value); answer[string](p, cmd, cmd.SetErr, r, err) {
+		return cmd
+	}
+	return p.p.Set(ctx, key, value, expiration)
+}
+
+func (p *pipeliner) Del(ctx context.Context, keys ...string) *cache.IntCmd {
+	r, err := p.f.intercept(ctx, "Del", keys)
+	if cmd := redis.NewIntCmd(ctx, "del"); answer[int64](p, cmd, cmd.SetErr, r, err) {
+		return cmd
+	}
+	return p.p.Del(ctx, keys...)
+}
+
+func (p *pipeliner) Exists(ctx context.Context, keys ...string) *cache.IntCmd {
+	r, err := p.f.intercept(ctx, "Exists", keys)
+	if cmd := redis.NewIntCmd(ctx, "exists"); answer[int64](p, cmd, cmd.SetErr, r, err) {
+		return cmd
+	}
+	return p.p.Exists(ctx, keys...)
+}
+
+func (p *pipeliner) HGet(ctx context.Context, key, field string) *cache.StringCmd {
+	r, err := p.f.intercept(ctx, "HGet", []string{key}, field)
+	if cmd := redis.NewStringCmd(ctx, "hget", key, field); answer[string](p, cmd, cmd.SetErr, r, err) {
+		return cmd
+	}
+	return p.p.HGet(ctx, key, field)
+}
+
+func (p *pipeliner) HGetAll(ctx context.Context, key string) *cache.MapStringStringCmd {
+	r, err := p.f.intercept(ctx, "HGetAll", []string{key})
+	if cmd := redis.NewMapStringStringCmd(ctx, "hgetall", key); answer[map[string]string](p, cmd, cmd.SetErr, r, err) {
+		return cmd
+	}
+	return p.p.HGetAll(ctx, key)
+}
+
+func (p *pipeliner) HSet(ctx context.Context, key string, values ...interface{}) *cache.IntCmd {
+	r, err := p.f.intercept(ctx, "HSet", []string{key}, values)
+	if cmd := redis.NewIntCmd(ctx, "hset", key); answer[int64](p, cmd, cmd.SetErr, r, err) {
+		return cmd
+	}
+	return p.p.HSet(ctx, key, values...)
+}
+
+func (p *pipeliner) HDel(ctx context.Context, key string, fields ...string) *cache.IntCmd {
+	r, err := p.f.intercept(ctx, "HDel", []string{key}, fields)
+	if cmd := redis.NewIntCmd(ctx, "hdel", key); answer[int64](p, cmd, cmd.SetErr, r, err) {
+		return cmd
+	}
+	return p.p.HDel(ctx, key, fields...)
+}
+
+func (p *pipeliner) RPush(ctx context.Context, key string, values ...interface{}) *cache.IntCmd {
+	r, err := p.f.intercept(ctx, "RPush", []string{key}, values)
+	if cmd := redis.NewIntCmd(ctx, "rpush", key); answer[int64](p, cmd, cmd.SetErr, r, err) {
+		return cmd
+	}
+	return p.p.RPush(ctx, key, values...)
+}
+
+func (p *pipeliner) LPop(ctx context.Context, key string) *cache.StringCmd {
+	r, err := p.f.intercept(ctx, "LPop", []string{key})
+	if cmd := redis.NewStringCmd(ctx, "lpop", key); answer[string](p, cmd, cmd.SetErr, r, err) {
+		return cmd
+	}
+	return p.p.LPop(ctx, key)
+}
+
+func (p *pipeliner) LLen(ctx context.Context, key string) *cache.IntCmd {
+	r, err := p.f.intercept(ctx, "LLen", []string{key})
+	if cmd := redis.NewIntCmd(ctx, "llen", key); answer[int64](p, cmd, cmd.SetErr, r, err) {
+		return cmd
+	}
+	return p.p.LLen(ctx, key)
+}
+
+func (p *pipeliner) LMove(ctx context.Context, source, destination, srcpos, destpos string) *cache.StringCmd {
+	r, err := p.f.intercept(ctx, "LMove", []string{source, destination}, srcpos, destpos)
+	if cmd := redis.NewStringCmd(ctx, "lmove", source, destination); answer[string](p, cmd, cmd.SetErr, r, err) {
+		return cmd
+	}
+	return p.p.LMove(ctx, source, destination, srcpos, destpos)
+}
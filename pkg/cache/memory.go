@@ -0,0 +1,968 @@
+package cache
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/redis/go-redis/v9"
+)
+
+// sweepInterval bounds how often expired keys which are never read again are
+// removed from memory.
+const sweepInterval = time.Minute
+
+var (
+	errWrongType  = fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInteger = fmt.Errorf("ERR value is not an integer or out of range")
+	errSyntax     = fmt.Errorf("ERR syntax error")
+)
+
+type entry struct {
+	value    interface{} // one of string, []string, map[string]string, map[string]struct{} or map[string]float64
+	expireAt time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// MemoryCache keeps all keys inside the current process. It follows the
+// semantics of the matching redis commands, so it can stand in for RedisCache
+// in tests and single node deployments.
+type MemoryCache struct {
+	mutex   sync.Mutex
+	entries map[string]*entry
+	swept   time.Time
+	now     func() time.Time
+}
+
+func NewMemory(ctxt context.Context, cfg *Properties) (*MemoryCache, *national.Message) {
+	return &MemoryCache{
+		entries: map[string]*entry{},
+		swept:   time.Now(),
+		now:     time.Now,
+	}, errors.Yes()
+}
+
+// lookup returns the live entry of key, expired entries are dropped on the way.
+func (m *MemoryCache) lookup(key string) *entry {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if e.expired(m.now()) {
+		delete(m.entries, key)
+		return nil
+	}
+	return e
+}
+
+func (m *MemoryCache) sweep() {
+	now := m.now()
+	if now.Sub(m.swept) < sweepInterval {
+		return
+	}
+	for k, e := range m.entries {
+		if e.expired(now) {
+			delete(m.entries, k)
+		}
+	}
+	m.swept = now
+}
+
+func (m *MemoryCache) list(key string) ([]string, error) {
+	e := m.lookup(key)
+	if e == nil {
+		return nil, nil
+	}
+	if l, ok := e.value.([]string); ok {
+		return l, nil
+	}
+	return nil, errWrongType
+}
+
+func (m *MemoryCache) hash(key string) (map[string]string, error) {
+	e := m.lookup(key)
+	if e == nil {
+		return nil, nil
+	}
+	if h, ok := e.value.(map[string]string); ok {
+		return h, nil
+	}
+	return nil, errWrongType
+}
+
+// store replaces the value of key while keeping its expiration.
+func (m *MemoryCache) store(key string, value interface{}) {
+	if e := m.lookup(key); e != nil {
+		e.value = value
+		return
+	}
+	m.sweep()
+	m.entries[key] = &entry{value: value}
+}
+
+func (m *MemoryCache) Ping(ctxt context.Context) error {
+	return nil
+}
+
+func (m *MemoryCache) Push(ctx context.Context, key string, values ...interface{}) error {
+	_, err := m.push(key, values)
+	return err
+}
+
+func (m *MemoryCache) Pop(ctx context.Context, key string) (string, error) {
+	return m.LPop(ctx, key)
+}
+
+func (m *MemoryCache) Count(ctx context.Context, key string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	l, err := m.list(key)
+	return int64(len(l)), err
+}
+
+func (m *MemoryCache) Del(ctxt context.Context, keys ...string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var n int64
+	for _, k := range keys {
+		if m.lookup(k) != nil {
+			delete(m.entries, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryCache) Exists(ctx context.Context, keys ...string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var n int64
+	for _, k := range keys {
+		if m.lookup(k) != nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryCache) Get(ctxt context.Context, key string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e := m.lookup(key)
+	if e == nil {
+		return "", Nil
+	}
+	if s, ok := e.value.(string); ok {
+		return s, nil
+	}
+	return "", errWrongType
+}
+
+func (m *MemoryCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) (string, error) {
+	s, err := stringify(value)
+	if err != nil {
+		return "", err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var expireAt time.Time
+	if expiration == redis.KeepTTL {
+		if e := m.lookup(key); e != nil {
+			expireAt = e.expireAt
+		}
+	} else if expiration > 0 {
+		expireAt = m.now().Add(expiration)
+	}
+	m.sweep()
+	m.entries[key] = &entry{value: s, expireAt: expireAt}
+	return "OK", nil
+}
+
+func (m *MemoryCache) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h, err := m.hash(key)
+	if err != nil || h == nil {
+		return 0, err
+	}
+	var n int64
+	for _, f := range fields {
+		if _, ok := h[f]; ok {
+			delete(h, f)
+			n++
+		}
+	}
+	if len(h) == 0 {
+		delete(m.entries, key)
+	}
+	return n, nil
+}
+
+func (m *MemoryCache) HGet(ctx context.Context, key, field string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h, err := m.hash(key)
+	if err != nil {
+		return "", err
+	}
+	if v, ok := h[field]; ok {
+		return v, nil
+	}
+	return "", Nil
+}
+
+func (m *MemoryCache) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h, err := m.hash(key)
+	if err != nil {
+		return nil, err
+	}
+	return copyHash(h), nil
+}
+
+func (m *MemoryCache) HLen(ctx context.Context, key string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h, err := m.hash(key)
+	return int64(len(h)), err
+}
+
+func (m *MemoryCache) HSet(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	pairs, err := flatten(values)
+	if err != nil {
+		return 0, err
+	}
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return 0, fmt.Errorf("ERR wrong number of arguments for 'hset' command")
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h, err := m.hash(key)
+	if err != nil {
+		return 0, err
+	}
+	if h == nil {
+		h = map[string]string{}
+		m.store(key, h)
+	}
+	var n int64
+	for i := 0; i < len(pairs); i += 2 {
+		if _, ok := h[pairs[i]]; !ok {
+			n++
+		}
+		h[pairs[i]] = pairs[i+1]
+	}
+	return n, nil
+}
+
+func (m *MemoryCache) LPop(ctx context.Context, key string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	l, err := m.list(key)
+	if err != nil {
+		return "", err
+	}
+	if len(l) == 0 {
+		return "", Nil
+	}
+	if len(l) == 1 {
+		delete(m.entries, key)
+	} else {
+		m.store(key, l[1:])
+	}
+	return l[0], nil
+}
+
+func (m *MemoryCache) RPush(ctx context.Context, key string, fields ...string) (int64, error) {
+	values := make([]interface{}, len(fields))
+	for i, f := range fields {
+		values[i] = f
+	}
+	return m.push(key, values)
+}
+
+func (m *MemoryCache) LMove(ctx context.Context, source, destination, srcpos, destpos string) (string, error) {
+	srcpos, destpos = strings.ToUpper(srcpos), strings.ToUpper(destpos)
+	for _, pos := range []string{srcpos, destpos} {
+		if pos != "LEFT" && pos != "RIGHT" {
+			return "", errSyntax
+		}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	src, err := m.list(source)
+	if err != nil {
+		return "", err
+	}
+	if _, err := m.list(destination); err != nil {
+		return "", err
+	}
+	if len(src) == 0 {
+		return "", Nil
+	}
+	var v string
+	if srcpos == "LEFT" {
+		v, src = src[0], src[1:]
+	} else {
+		v, src = src[len(src)-1], src[:len(src)-1]
+	}
+	m.storeList(source, src)
+	// Read again as source and destination may be the same list
+	dst, _ := m.list(destination)
+	if destpos == "LEFT" {
+		dst = append([]string{v}, dst...)
+	} else {
+		dst = append(dst[:len(dst):len(dst)], v)
+	}
+	m.storeList(destination, dst)
+	return v, nil
+}
+
+func (m *MemoryCache) LRem(ctx context.Context, key string, count int64, value interface{}) (int64, error) {
+	s, err := stringify(value)
+	if err != nil {
+		return 0, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	l, err := m.list(key)
+	if err != nil {
+		return 0, err
+	}
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	drop := make([]bool, len(l))
+	var n int64
+	for i := range l {
+		j := i
+		if count < 0 {
+			j = len(l) - 1 - i
+		}
+		if l[j] == s && (limit == 0 || n < limit) {
+			drop[j] = true
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	r := make([]string, 0, len(l)-int(n))
+	for i, v := range l {
+		if !drop[i] {
+			r = append(r, v)
+		}
+	}
+	m.storeList(key, r)
+	return n, nil
+}
+
+func (m *MemoryCache) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	l, err := m.list(key)
+	if err != nil {
+		return nil, err
+	}
+	n := int64(len(l))
+	if start < 0 {
+		start = max(0, n+start)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return []string{}, nil
+	}
+	return slices.Clone(l[start : stop+1]), nil
+}
+
+func (m *MemoryCache) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	s, err := stringify(value)
+	if err != nil {
+		return false, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.lookup(key) != nil {
+		return false, nil
+	}
+	var expireAt time.Time
+	if expiration > 0 {
+		expireAt = m.now().Add(expiration)
+	}
+	m.sweep()
+	m.entries[key] = &entry{value: s, expireAt: expireAt}
+	return true, nil
+}
+
+func (m *MemoryCache) Incr(ctx context.Context, key string) (int64, error) {
+	return m.IncrBy(ctx, key, 1)
+}
+
+func (m *MemoryCache) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var n int64
+	if e := m.lookup(key); e != nil {
+		s, ok := e.value.(string)
+		if !ok {
+			return 0, errWrongType
+		}
+		var err error
+		if n, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return 0, errNotInteger
+		}
+	}
+	if (value > 0 && n > math.MaxInt64-value) || (value < 0 && n < math.MinInt64-value) {
+		return 0, fmt.Errorf("ERR increment or decrement would overflow")
+	}
+	n += value
+	m.store(key, strconv.FormatInt(n, 10))
+	return n, nil
+}
+
+func (m *MemoryCache) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e := m.lookup(key)
+	if e == nil {
+		return false, nil
+	}
+	if expiration <= 0 {
+		delete(m.entries, key)
+	} else {
+		e.expireAt = m.now().Add(expiration)
+	}
+	return true, nil
+}
+
+func (m *MemoryCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e := m.lookup(key)
+	if e == nil {
+		return -2, nil
+	}
+	if e.expireAt.IsZero() {
+		return -1, nil
+	}
+	return (e.expireAt.Sub(m.now()) + time.Second/2).Truncate(time.Second), nil
+}
+
+func (m *MemoryCache) Persist(ctx context.Context, key string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e := m.lookup(key)
+	if e == nil || e.expireAt.IsZero() {
+		return false, nil
+	}
+	e.expireAt = time.Time{}
+	return true, nil
+}
+
+func (m *MemoryCache) members(key string) (map[string]struct{}, error) {
+	e := m.lookup(key)
+	if e == nil {
+		return nil, nil
+	}
+	if s, ok := e.value.(map[string]struct{}); ok {
+		return s, nil
+	}
+	return nil, errWrongType
+}
+
+func (m *MemoryCache) SAdd(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	a, err := flatten(members)
+	if err != nil {
+		return 0, err
+	}
+	if len(a) == 0 {
+		return 0, fmt.Errorf("ERR wrong number of arguments for 'sadd' command")
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, err := m.members(key)
+	if err != nil {
+		return 0, err
+	}
+	if s == nil {
+		s = map[string]struct{}{}
+		m.store(key, s)
+	}
+	var n int64
+	for _, v := range a {
+		if _, ok := s[v]; !ok {
+			s[v] = struct{}{}
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryCache) SRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	a, err := flatten(members)
+	if err != nil {
+		return 0, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, err := m.members(key)
+	if err != nil || s == nil {
+		return 0, err
+	}
+	var n int64
+	for _, v := range a {
+		if _, ok := s[v]; ok {
+			delete(s, v)
+			n++
+		}
+	}
+	if len(s) == 0 {
+		delete(m.entries, key)
+	}
+	return n, nil
+}
+
+func (m *MemoryCache) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	v, err := stringify(member)
+	if err != nil {
+		return false, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, err := m.members(key)
+	if err != nil {
+		return false, err
+	}
+	_, ok := s[v]
+	return ok, nil
+}
+
+func (m *MemoryCache) SMembers(ctx context.Context, key string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, err := m.members(key)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]string, 0, len(s))
+	for v := range s {
+		r = append(r, v)
+	}
+	return r, nil
+}
+
+func (m *MemoryCache) SCard(ctx context.Context, key string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, err := m.members(key)
+	return int64(len(s)), err
+}
+
+func (m *MemoryCache) scores(key string) (map[string]float64, error) {
+	e := m.lookup(key)
+	if e == nil {
+		return nil, nil
+	}
+	if z, ok := e.value.(map[string]float64); ok {
+		return z, nil
+	}
+	return nil, errWrongType
+}
+
+// ranked orders the members of a sorted set by score, then member.
+func ranked(z map[string]float64) []Z {
+	r := make([]Z, 0, len(z))
+	for member, score := range z {
+		r = append(r, Z{Score: score, Member: member})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Score != r[j].Score {
+			return r[i].Score < r[j].Score
+		}
+		return r[i].Member.(string) < r[j].Member.(string)
+	})
+	return r
+}
+
+func (m *MemoryCache) ZAdd(ctx context.Context, key string, members ...Z) (int64, error) {
+	if len(members) == 0 {
+		return 0, fmt.Errorf("ERR wrong number of arguments for 'zadd' command")
+	}
+	names := make([]string, len(members))
+	for i, z := range members {
+		s, err := stringify(z.Member)
+		if err != nil {
+			return 0, err
+		}
+		names[i] = s
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	z, err := m.scores(key)
+	if err != nil {
+		return 0, err
+	}
+	if z == nil {
+		z = map[string]float64{}
+		m.store(key, z)
+	}
+	var n int64
+	for i, member := range members {
+		if _, ok := z[names[i]]; !ok {
+			n++
+		}
+		z[names[i]] = member.Score
+	}
+	return n, nil
+}
+
+func (m *MemoryCache) ZIncrBy(ctx context.Context, key string, increment float64, member string) (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	z, err := m.scores(key)
+	if err != nil {
+		return 0, err
+	}
+	if z == nil {
+		z = map[string]float64{}
+		m.store(key, z)
+	}
+	z[member] += increment
+	return z[member], nil
+}
+
+func (m *MemoryCache) ZRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	a, err := flatten(members)
+	if err != nil {
+		return 0, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	z, err := m.scores(key)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	var n int64
+	for _, v := range a {
+		if _, ok := z[v]; ok {
+			delete(z, v)
+			n++
+		}
+	}
+	if len(z) == 0 {
+		delete(m.entries, key)
+	}
+	return n, nil
+}
+
+func (m *MemoryCache) ZScore(ctx context.Context, key, member string) (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	z, err := m.scores(key)
+	if err != nil {
+		return 0, err
+	}
+	if score, ok := z[member]; ok {
+		return score, nil
+	}
+	return 0, Nil
+}
+
+func (m *MemoryCache) rank(key, member string, reverse bool) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	z, err := m.scores(key)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := z[member]; !ok {
+		return 0, Nil
+	}
+	for i, v := range ranked(z) {
+		if v.Member == member {
+			if reverse {
+				return int64(len(z) - 1 - i), nil
+			}
+			return int64(i), nil
+		}
+	}
+	return 0, Nil
+}
+
+func (m *MemoryCache) ZRank(ctx context.Context, key, member string) (int64, error) {
+	return m.rank(key, member, false)
+}
+
+func (m *MemoryCache) ZRevRank(ctx context.Context, key, member string) (int64, error) {
+	return m.rank(key, member, true)
+}
+
+func (m *MemoryCache) ZCard(ctx context.Context, key string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	z, err := m.scores(key)
+	return int64(len(z)), err
+}
+
+func (m *MemoryCache) zrange(key string, start, stop int64, reverse bool) ([]Z, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	z, err := m.scores(key)
+	if err != nil {
+		return nil, err
+	}
+	r := ranked(z)
+	if reverse {
+		slices.Reverse(r)
+	}
+	n := int64(len(r))
+	if start < 0 {
+		start = max(0, n+start)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return []Z{}, nil
+	}
+	return r[start : stop+1], nil
+}
+
+func (m *MemoryCache) ZRange(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	return m.zrange(key, start, stop, false)
+}
+
+func (m *MemoryCache) ZRevRange(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	return m.zrange(key, start, stop, true)
+}
+
+// bound parses a score bound of ZRangeByScore, it reports whether s is
+// exclusive.
+func bound(s string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+	switch s {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "+inf", "inf":
+		return math.Inf(1), exclusive, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("ERR min or max is not a float")
+	}
+	return f, exclusive, nil
+}
+
+func (m *MemoryCache) ZRangeByScore(ctx context.Context, key string, opt *ZRangeBy) ([]Z, error) {
+	lo, loExclusive, err := bound(opt.Min)
+	if err != nil {
+		return nil, err
+	}
+	hi, hiExclusive, err := bound(opt.Max)
+	if err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	z, err := m.scores(key)
+	if err != nil {
+		return nil, err
+	}
+	r := []Z{}
+	for _, v := range ranked(z) {
+		if v.Score < lo || (loExclusive && v.Score == lo) || v.Score > hi || (hiExclusive && v.Score == hi) {
+			continue
+		}
+		r = append(r, v)
+	}
+	if opt.Offset != 0 || opt.Count != 0 {
+		r = r[min(int64(len(r)), max(0, opt.Offset)):]
+		if opt.Count >= 0 && opt.Count < int64(len(r)) {
+			r = r[:opt.Count]
+		}
+	}
+	return r, nil
+}
+
+func (m *MemoryCache) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	r := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if e := m.lookup(k); e != nil {
+			if s, ok := e.value.(string); ok {
+				r[k] = s
+			}
+		}
+	}
+	return r, nil
+}
+
+func (m *MemoryCache) MSet(ctx context.Context, values map[string]interface{}, expiration time.Duration) error {
+	for k, v := range values {
+		if _, err := m.Set(ctx, k, v, expiration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryCache) MHGetAll(ctx context.Context, keys ...string) (map[string]map[string]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	r := make(map[string]map[string]string, len(keys))
+	for _, k := range keys {
+		h, err := m.hash(k)
+		if err != nil {
+			return nil, err
+		}
+		if h != nil {
+			r[k] = copyHash(h)
+		}
+	}
+	return r, nil
+}
+
+func (m *MemoryCache) Pipeline(ctx context.Context, fn func(p Pipeliner) error) error {
+	p := &memoryPipeline{cache: m}
+	if err := fn(p); err != nil {
+		return err
+	}
+	return p.exec()
+}
+
+// storeList stores l as key, empty lists are removed like redis does.
+func (m *MemoryCache) storeList(key string, l []string) {
+	if len(l) == 0 {
+		delete(m.entries, key)
+		return
+	}
+	m.store(key, l)
+}
+
+func (m *MemoryCache) push(key string, values []interface{}) (int64, error) {
+	a, err := flatten(values)
+	if err != nil {
+		return 0, err
+	}
+	if len(a) == 0 {
+		return 0, fmt.Errorf("ERR wrong number of arguments for 'rpush' command")
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	l, err := m.list(key)
+	if err != nil {
+		return 0, err
+	}
+	l = append(l, a...)
+	m.store(key, l)
+	return int64(len(l)), nil
+}
+
+// flatten expands arguments the way go-redis does before sending a command,
+// slices and maps are spread into individual values.
+func flatten(values []interface{}) ([]string, error) {
+	r := make([]string, 0, len(values))
+	for _, v := range values {
+		switch a := v.(type) {
+		case []string:
+			r = append(r, a...)
+		case []interface{}:
+			s, err := flatten(a)
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, s...)
+		case map[string]string:
+			for k, v := range a {
+				r = append(r, k, v)
+			}
+		case map[string]interface{}:
+			for k, v := range a {
+				s, err := stringify(v)
+				if err != nil {
+					return nil, err
+				}
+				r = append(r, k, s)
+			}
+		default:
+			s, err := stringify(v)
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, s)
+		}
+	}
+	return r, nil
+}
+
+// stringify converts v into the text redis would store for it.
+func stringify(v interface{}) (string, error) {
+	switch a := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return a, nil
+	case []byte:
+		return string(a), nil
+	case int:
+		return strconv.FormatInt(int64(a), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(a), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(a), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(a), 10), nil
+	case int64:
+		return strconv.FormatInt(a, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(a), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(a), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(a), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(a), 10), nil
+	case uint64:
+		return strconv.FormatUint(a, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(a), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(a, 'f', -1, 64), nil
+	case bool:
+		if a {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return a.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return strconv.FormatInt(a.Nanoseconds(), 10), nil
+	case encoding.BinaryMarshaler:
+		b, err := a.MarshalBinary()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	return "", fmt.Errorf("redis: can't marshal %T (implement encoding.BinaryMarshaler)", v)
+}
@@ -0,0 +1,343 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newMemory(t *testing.T) *MemoryCache {
+	c, m := New(context.Background(), &Properties{Type: Memory})
+	assert.True(t, m.Fine())
+	return c.(*MemoryCache)
+}
+
+func TestMemoryCache_String(t *testing.T) {
+	ctxt := context.Background()
+	c := newMemory(t)
+
+	_, e := c.Get(ctxt, "k1")
+	assert.ErrorIs(t, e, Nil)
+	s, e := c.Set(ctxt, "k1", "v1", 0)
+	assert.Nil(t, e)
+	assert.Equal(t, "OK", s)
+	_, e = c.Set(ctxt, "k2", 42, 0)
+	assert.Nil(t, e)
+	v, e := c.Get(ctxt, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "v1", v)
+	v, e = c.Get(ctxt, "k2")
+	assert.Nil(t, e)
+	assert.Equal(t, "42", v)
+	n, e := c.Exists(ctxt, "k1", "k2", "k3", "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(3), n)
+	n, e = c.Del(ctxt, "k1", "k3")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(1), n)
+	_, e = c.Set(ctxt, "k3", struct{}{}, 0)
+	assert.NotNil(t, e)
+}
+
+func TestMemoryCache_Expiration(t *testing.T) {
+	ctxt := context.Background()
+	c := newMemory(t)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	_, e := c.Set(ctxt, "k1", "v1", time.Second)
+	assert.Nil(t, e)
+	_, e = c.HSet(ctxt, "k1", "f", "v")
+	assert.ErrorIs(t, e, errWrongType)
+	v, e := c.Get(ctxt, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "v1", v)
+
+	now = now.Add(time.Second)
+	_, e = c.Get(ctxt, "k1")
+	assert.ErrorIs(t, e, Nil)
+	n, e := c.Exists(ctxt, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(0), n)
+
+	_, e = c.Set(ctxt, "k2", "v2", time.Second)
+	assert.Nil(t, e)
+	_, e = c.Set(ctxt, "k2", "v3", -1)
+	assert.Nil(t, e)
+	now = now.Add(time.Second)
+	_, e = c.Get(ctxt, "k2")
+	assert.ErrorIs(t, e, Nil)
+
+	_, e = c.Set(ctxt, "k3", "v3", time.Second)
+	assert.Nil(t, e)
+	now = now.Add(sweepInterval)
+	_, e = c.Set(ctxt, "k4", "v4", 0)
+	assert.Nil(t, e)
+	assert.Len(t, c.entries, 1)
+}
+
+func TestMemoryCache_List(t *testing.T) {
+	ctxt := context.Background()
+	c := newMemory(t)
+
+	key := "knife:list"
+	n, e := c.RPush(ctxt, key, "v1", "v2", "v3")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(3), n)
+	assert.Nil(t, c.Push(ctxt, key, []byte("v4"), 5))
+	n, e = c.Count(ctxt, key)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(5), n)
+	for _, expected := range []string{"v1", "v2", "v3", "v4", "5"} {
+		v, e := c.Pop(ctxt, key)
+		assert.Nil(t, e)
+		assert.Equal(t, expected, v)
+	}
+	_, e = c.LPop(ctxt, key)
+	assert.ErrorIs(t, e, Nil)
+	n, e = c.Exists(ctxt, key)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(0), n)
+
+	_, e = c.Set(ctxt, "k1", "v1", 0)
+	assert.Nil(t, e)
+	_, e = c.RPush(ctxt, "k1", "v1")
+	assert.ErrorIs(t, e, errWrongType)
+}
+
+func TestMemoryCache_Hash(t *testing.T) {
+	ctxt := context.Background()
+	c := newMemory(t)
+
+	key := "knife:hash"
+	n, e := c.HSet(ctxt, key, "k1", "v1", "k2", "v2")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(2), n)
+	n, e = c.HSet(ctxt, key, map[string]interface{}{"k2": "v3", "k3": 3})
+	assert.Nil(t, e)
+	assert.Equal(t, int64(1), n)
+	h, e := c.HGetAll(ctxt, key)
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "v3", "k3": "3"}, h)
+	n, e = c.HLen(ctxt, key)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(3), n)
+	v, e := c.HGet(ctxt, key, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "v1", v)
+	_, e = c.HGet(ctxt, key, "k4")
+	assert.ErrorIs(t, e, Nil)
+	_, e = c.HSet(ctxt, key, "k4")
+	assert.NotNil(t, e)
+	n, e = c.HDel(ctxt, key, "k1", "k2", "k4")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(2), n)
+	n, e = c.HDel(ctxt, key, "k3")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(1), n)
+	h, e = c.HGetAll(ctxt, key)
+	assert.Nil(t, e)
+	assert.Empty(t, h)
+	n, e = c.HLen(ctxt, key)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(0), n)
+	n, e = c.Exists(ctxt, key)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(0), n)
+}
+
+// testCounters exercises conditional writes, counters and expirations of c.
+func testCounters(t *testing.T, c Cache, prefix string) {
+	ctxt := context.Background()
+	k1, k2 := prefix+"k1", prefix+"k2"
+	defer func() { _, _ = c.Del(ctxt, k1, k2) }()
+
+	ok, e := c.SetNX(ctxt, k1, "v1", 0)
+	assert.Nil(t, e)
+	assert.True(t, ok)
+	ok, e = c.SetNX(ctxt, k1, "v2", 0)
+	assert.Nil(t, e)
+	assert.False(t, ok)
+	_, e = c.Incr(ctxt, k1)
+	assert.NotNil(t, e, "not an integer")
+
+	n, e := c.Incr(ctxt, k2)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(1), n)
+	n, e = c.IncrBy(ctxt, k2, -5)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(-4), n)
+
+	d, e := c.TTL(ctxt, k2)
+	assert.Nil(t, e)
+	assert.Equal(t, time.Duration(-1), d)
+	d, e = c.TTL(ctxt, prefix+"missing")
+	assert.Nil(t, e)
+	assert.Equal(t, time.Duration(-2), d)
+	ok, e = c.Expire(ctxt, k2, time.Minute)
+	assert.Nil(t, e)
+	assert.True(t, ok)
+	d, e = c.TTL(ctxt, k2)
+	assert.Nil(t, e)
+	assert.Equal(t, time.Minute, d)
+	n, e = c.Incr(ctxt, k2)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(-3), n)
+	d, _ = c.TTL(ctxt, k2)
+	assert.Equal(t, time.Minute, d, "counters keep their expiration")
+	ok, e = c.Persist(ctxt, k2)
+	assert.Nil(t, e)
+	assert.True(t, ok)
+	d, _ = c.TTL(ctxt, k2)
+	assert.Equal(t, time.Duration(-1), d)
+	ok, e = c.Expire(ctxt, k2, 0)
+	assert.Nil(t, e)
+	assert.True(t, ok)
+	ok, e = c.Expire(ctxt, k2, time.Minute)
+	assert.Nil(t, e)
+	assert.False(t, ok, "deleted by a non-positive expiration")
+}
+
+// testSets exercises the set and sorted set commands of c.
+func testSets(t *testing.T, c Cache, prefix string) {
+	ctxt := context.Background()
+	s, z := prefix+"s", prefix+"z"
+	defer func() { _, _ = c.Del(ctxt, s, z) }()
+
+	n, e := c.SAdd(ctxt, s, "a", "b", "a", 1)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(3), n)
+	ok, e := c.SIsMember(ctxt, s, 1)
+	assert.Nil(t, e)
+	assert.True(t, ok)
+	members, e := c.SMembers(ctxt, s)
+	assert.Nil(t, e)
+	assert.ElementsMatch(t, []string{"a", "b", "1"}, members)
+	n, e = c.SRem(ctxt, s, "a", "c")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(1), n)
+	n, e = c.SCard(ctxt, s)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(2), n)
+	_, e = c.ZAdd(ctxt, s, Z{Score: 1, Member: "a"})
+	assert.NotNil(t, e, "wrong type")
+
+	n, e = c.ZAdd(ctxt, z, Z{Score: 3, Member: "c"}, Z{Score: 1, Member: "a"}, Z{Score: 2, Member: "b"})
+	assert.Nil(t, e)
+	assert.Equal(t, int64(3), n)
+	f, e := c.ZIncrBy(ctxt, z, 2.5, "a")
+	assert.Nil(t, e)
+	assert.Equal(t, 3.5, f)
+	f, e = c.ZScore(ctxt, z, "b")
+	assert.Nil(t, e)
+	assert.Equal(t, float64(2), f)
+	_, e = c.ZScore(ctxt, z, "x")
+	assert.ErrorIs(t, e, Nil)
+	n, e = c.ZRank(ctxt, z, "a")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(2), n)
+	n, e = c.ZRevRank(ctxt, z, "a")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(0), n)
+	_, e = c.ZRank(ctxt, z, "x")
+	assert.ErrorIs(t, e, Nil)
+
+	r, e := c.ZRange(ctxt, z, 0, -1)
+	assert.Nil(t, e)
+	assert.Equal(t, []Z{{Score: 2, Member: "b"}, {Score: 3, Member: "c"}, {Score: 3.5, Member: "a"}}, r)
+	r, e = c.ZRevRange(ctxt, z, 0, 1)
+	assert.Nil(t, e)
+	assert.Equal(t, []Z{{Score: 3.5, Member: "a"}, {Score: 3, Member: "c"}}, r)
+	r, e = c.ZRange(ctxt, z, 5, 10)
+	assert.Nil(t, e)
+	assert.Empty(t, r)
+	r, e = c.ZRangeByScore(ctxt, z, &ZRangeBy{Min: "(2", Max: "+inf"})
+	assert.Nil(t, e)
+	assert.Equal(t, []Z{{Score: 3, Member: "c"}, {Score: 3.5, Member: "a"}}, r)
+	r, e = c.ZRangeByScore(ctxt, z, &ZRangeBy{Min: "-inf", Max: "3", Offset: 1, Count: 5})
+	assert.Nil(t, e)
+	assert.Equal(t, []Z{{Score: 3, Member: "c"}}, r)
+
+	n, e = c.ZRem(ctxt, z, "a", "b", "x")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(2), n)
+	n, e = c.ZCard(ctxt, z)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(1), n)
+}
+
+// testMoves exercises moving and removing list elements of c.
+func testMoves(t *testing.T, c Cache, prefix string) {
+	ctxt := context.Background()
+	src, dst := prefix+"src", prefix+"dst"
+	defer func() { _, _ = c.Del(ctxt, src, dst) }()
+
+	_, e := c.RPush(ctxt, src, "a", "b", "c", "b")
+	assert.Nil(t, e)
+	v, e := c.LMove(ctxt, src, dst, "LEFT", "RIGHT")
+	assert.Nil(t, e)
+	assert.Equal(t, "a", v)
+	v, e = c.LMove(ctxt, src, dst, "RIGHT", "LEFT")
+	assert.Nil(t, e)
+	assert.Equal(t, "b", v)
+	v, e = c.LMove(ctxt, src, src, "LEFT", "RIGHT")
+	assert.Nil(t, e)
+	assert.Equal(t, "b", v, "rotates a list onto itself")
+	_, e = c.LMove(ctxt, prefix+"missing", dst, "LEFT", "RIGHT")
+	assert.ErrorIs(t, e, Nil)
+
+	var moved *StringCmd
+	assert.Nil(t, c.Pipeline(ctxt, func(p Pipeliner) error {
+		moved = p.LMove(ctxt, src, dst, "LEFT", "LEFT")
+		return nil
+	}))
+	assert.Equal(t, "c", moved.Val())
+	n, e := c.Exists(ctxt, src)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(1), n)
+
+	_, e = c.RPush(ctxt, dst, "a", "b", "a")
+	assert.Nil(t, e)
+	l, e := c.LRange(ctxt, dst, 0, -1)
+	assert.Nil(t, e)
+	assert.Equal(t, []string{"c", "b", "a", "a", "b", "a"}, l)
+	l, e = c.LRange(ctxt, dst, -2, 10)
+	assert.Nil(t, e)
+	assert.Equal(t, []string{"b", "a"}, l)
+	l, e = c.LRange(ctxt, prefix+"missing", 0, -1)
+	assert.Nil(t, e)
+	assert.Empty(t, l)
+
+	// dst is c, b, a, a, b, a
+	n, e = c.LRem(ctxt, dst, -2, "a")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(2), n)
+	n, e = c.LRem(ctxt, dst, 0, "b")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(2), n)
+	n, e = c.LRem(ctxt, dst, 1, "missing")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(0), n)
+	for _, expected := range []string{"c", "a"} {
+		v, e := c.LPop(ctxt, dst)
+		assert.Nil(t, e)
+		assert.Equal(t, expected, v)
+	}
+}
+
+func TestMemoryCache_Moves(t *testing.T) {
+	c := newMemory(t)
+	testMoves(t, c, "")
+	_, e := c.LMove(context.Background(), "src", "dst", "UP", "LEFT")
+	assert.ErrorIs(t, e, errSyntax)
+}
+
+func TestMemoryCache_Counters(t *testing.T) {
+	testCounters(t, newMemory(t), "")
+}
+
+func TestMemoryCache_Sets(t *testing.T) {
+	testSets(t, newMemory(t), "")
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/national"
+)
+
+// Messenger delivers fan-out notifications through pub/sub channels and
+// durable events through streams read by consumer groups.
+type Messenger interface {
+	Publish(ctx context.Context, channel string, message interface{}) (int64, error)
+	// Subscribe listens on channels until ctx is done or the subscription is
+	// closed.
+	Subscribe(ctx context.Context, channels ...string) (*Subscription, error)
+	// XAdd appends values to stream, the stream is trimmed to roughly maxLen
+	// entries when maxLen is positive.
+	XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error)
+	// XGroupCreate creates group on stream starting at start ("$" for new
+	// entries only, "0" for all of them). Existing groups are left untouched.
+	XGroupCreate(ctx context.Context, stream, group, start string) error
+	// XReadGroup reads up to count entries never delivered to group, waiting
+	// up to block for them. A non-positive block returns immediately.
+	XReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]Entry, error)
+	XAck(ctx context.Context, stream, group string, ids ...string) (int64, error)
+	// XClaim transfers up to count entries which were delivered to other
+	// consumers of group but not acknowledged for minIdle.
+	XClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]Entry, error)
+}
+
+// Message is received from a pub/sub channel.
+type Message struct {
+	Channel string
+	Payload string
+}
+
+// Entry is read from a stream.
+type Entry struct {
+	ID     string
+	Values map[string]interface{}
+}
+
+// Subscription delivers the messages of subscribed channels.
+type Subscription struct {
+	messages chan Message
+	done     chan struct{}
+	once     sync.Once
+	closer   func() error
+	err      error
+}
+
+func newSubscription(ctx context.Context, closer func() error) *Subscription {
+	s := &Subscription{messages: make(chan Message), done: make(chan struct{}), closer: closer}
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = s.Close()
+		case <-s.done:
+		}
+	}()
+	return s
+}
+
+// deliver hands m to the receiver, it returns false once the subscription is
+// closed.
+func (s *Subscription) deliver(m Message) bool {
+	select {
+	case s.messages <- m:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// Channel returns the received messages, it is closed with the subscription.
+func (s *Subscription) Channel() <-chan Message {
+	return s.messages
+}
+
+// Listen calls fn for every message until the subscription is closed.
+func (s *Subscription) Listen(fn func(m Message)) {
+	for m := range s.messages {
+		fn(m)
+	}
+}
+
+func (s *Subscription) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		s.err = s.closer()
+	})
+	return s.err
+}
+
+func NewMessenger(ctxt context.Context, cfg *Properties) (Messenger, *national.Message) {
+	switch cfg.Type {
+	case Redis:
+		r, m := NewRedis(ctxt, cfg)
+		if !m.Fine() {
+			return nil, m
+		}
+		return r, m
+	}
+	return nil, errors.UnsupportedValueError.Build("type", "messenger", "value", cfg.Type)
+}
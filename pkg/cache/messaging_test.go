@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newMessenger(t *testing.T) Messenger {
+	m, msg := NewMessenger(context.Background(), &properties)
+	if !msg.Fine() {
+		t.Skip("Redis not available")
+	}
+	return m
+}
+
+func TestNewMessenger_Unsupported(t *testing.T) {
+	_, m := NewMessenger(context.Background(), &Properties{Type: Memory})
+	assert.False(t, m.Fine())
+}
+
+func TestSubscription_Close(t *testing.T) {
+	closed := 0
+	ctxt, cancel := context.WithCancel(context.Background())
+	s := newSubscription(ctxt, func() error {
+		closed++
+		return nil
+	})
+	cancel()
+	assert.Eventually(t, func() bool {
+		return !s.deliver(Message{Payload: "dropped"})
+	}, time.Second, 10*time.Millisecond)
+	assert.Nil(t, s.Close())
+	assert.Equal(t, 1, closed)
+}
+
+func TestRedisCache_PubSub(t *testing.T) {
+	ctxt := context.Background()
+	m := newMessenger(t)
+
+	listening, cancel := context.WithCancel(ctxt)
+	s, err := m.Subscribe(listening, "knife:channel")
+	assert.Nil(t, err)
+	n, err := m.Publish(ctxt, "knife:channel", "hello")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+	select {
+	case msg := <-s.Channel():
+		assert.Equal(t, Message{Channel: "knife:channel", Payload: "hello"}, msg)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Listen(func(m Message) {})
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("subscription not closed by context")
+	}
+}
+
+func TestRedisCache_Stream(t *testing.T) {
+	ctxt := context.Background()
+	m := newMessenger(t)
+	stream := "knife:stream:" + time.Now().Format(time.RFC3339Nano)
+
+	assert.Nil(t, m.XGroupCreate(ctxt, stream, "workers", "0"))
+	assert.Nil(t, m.XGroupCreate(ctxt, stream, "workers", "0"))
+	id, err := m.XAdd(ctxt, stream, 100, map[string]interface{}{"job": "1"})
+	assert.Nil(t, err)
+	_, err = m.XAdd(ctxt, stream, 100, map[string]interface{}{"job": "2"})
+	assert.Nil(t, err)
+
+	entries, err := m.XReadGroup(ctxt, stream, "workers", "w1", 1, 0)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, id, entries[0].ID)
+	assert.Equal(t, "1", entries[0].Values["job"])
+
+	entries, err = m.XReadGroup(ctxt, stream, "workers", "w2", 10, 10*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	n, err := m.XAck(ctxt, stream, "workers", entries[0].ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+
+	entries, err = m.XReadGroup(ctxt, stream, "workers", "w2", 10, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+
+	time.Sleep(20 * time.Millisecond)
+	entries, err = m.XClaim(ctxt, stream, "workers", "w2", 10*time.Millisecond, 10)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, id, entries[0].ID)
+}
@@ -0,0 +1,537 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Namespace isolates the keys of services, environments and tenants sharing
+// one redis. Keys become "<environment>:<service>:<key>", empty segments are
+// left out.
+type Namespace struct {
+	Environment string `json:"environment" yaml:"environment"`
+	Service     string `json:"service" yaml:"service"`
+	// Tenant adds the tenant bound to the context by WithTenant as
+	// "t/<tenant>/<key>", keys used without a tenant are shared by all tenants
+	// as "s/<key>". Tenants must not contain a slash.
+	Tenant bool `json:"tenant" yaml:"tenant" default:"false"`
+}
+
+func (n *Namespace) enabled() bool {
+	return len(n.Environment) > 0 || len(n.Service) > 0 || n.Tenant
+}
+
+// ErrTenant is returned for tenants containing a slash, which separates the
+// tenant from the key.
+var ErrTenant = fmt.Errorf("cache: tenant must not contain '/'")
+
+type contextKeyType string
+
+const keyTenant = contextKeyType("knife/cache/tenant")
+
+// WithTenant binds tenant to ctxt, see Namespace.Tenant.
+func WithTenant(ctxt context.Context, tenant string) context.Context {
+	return context.WithValue(ctxt, keyTenant, tenant)
+}
+
+// Tenant returns the tenant bound to ctxt, or an empty string.
+func Tenant(ctxt context.Context) string {
+	if t, ok := ctxt.Value(keyTenant).(string); ok {
+		return t
+	}
+	return ""
+}
+
+// NamespacedCache prefixes every key before handing it to the wrapped cache.
+type NamespacedCache struct {
+	cache  Cache
+	prefix string
+	tenant bool
+}
+
+func NewNamespaced(c Cache, ns *Namespace) *NamespacedCache {
+	var segments []string
+	for _, s := range []string{ns.Environment, ns.Service} {
+		if len(s) > 0 {
+			segments = append(segments, s+":")
+		}
+	}
+	return &NamespacedCache{cache: c, prefix: strings.Join(segments, ""), tenant: ns.Tenant}
+}
+
+// Unwrap returns the wrapped cache.
+func (n *NamespacedCache) Unwrap() Cache {
+	return n.cache
+}
+
+// key returns the key of key in the namespace. With tenants enabled, the keys
+// of a tenant and the shared ones are kept apart as "t/<tenant>/<key>" and
+// "s/<key>", so that no tenant reads a shared key.
+func (n *NamespacedCache) key(ctx context.Context, key string) (string, error) {
+	if !n.tenant {
+		return n.prefix + key, nil
+	}
+	t := Tenant(ctx)
+	if len(t) == 0 {
+		return n.prefix + "s/" + key, nil
+	}
+	if strings.Contains(t, "/") {
+		return "", ErrTenant
+	}
+	return n.prefix + "t/" + t + "/" + key, nil
+}
+
+func (n *NamespacedCache) keys(ctx context.Context, keys []string) ([]string, error) {
+	r := make([]string, len(keys))
+	for i, k := range keys {
+		var err error
+		if r[i], err = n.key(ctx, k); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (n *NamespacedCache) Ping(ctxt context.Context) error {
+	return n.cache.Ping(ctxt)
+}
+
+func (n *NamespacedCache) Push(ctx context.Context, key string, values ...interface{}) error {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return err
+	}
+	return n.cache.Push(ctx, k, values...)
+}
+
+func (n *NamespacedCache) Pop(ctx context.Context, key string) (string, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return n.cache.Pop(ctx, k)
+}
+
+func (n *NamespacedCache) Count(ctx context.Context, key string) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.Count(ctx, k)
+}
+
+func (n *NamespacedCache) Del(ctxt context.Context, keys ...string) (int64, error) {
+	ks, err := n.keys(ctxt, keys)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.Del(ctxt, ks...)
+}
+
+func (n *NamespacedCache) Exists(ctx context.Context, keys ...string) (int64, error) {
+	ks, err := n.keys(ctx, keys)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.Exists(ctx, ks...)
+}
+
+func (n *NamespacedCache) Get(ctxt context.Context, key string) (string, error) {
+	k, err := n.key(ctxt, key)
+	if err != nil {
+		return "", err
+	}
+	return n.cache.Get(ctxt, k)
+}
+
+func (n *NamespacedCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) (string, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return n.cache.Set(ctx, k, value, expiration)
+}
+
+func (n *NamespacedCache) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.HDel(ctx, k, fields...)
+}
+
+func (n *NamespacedCache) HGet(ctx context.Context, key, field string) (string, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return n.cache.HGet(ctx, k, field)
+}
+
+func (n *NamespacedCache) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return n.cache.HGetAll(ctx, k)
+}
+
+func (n *NamespacedCache) HLen(ctx context.Context, key string) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.HLen(ctx, k)
+}
+
+func (n *NamespacedCache) HSet(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.HSet(ctx, k, values...)
+}
+
+func (n *NamespacedCache) LPop(ctx context.Context, key string) (string, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return n.cache.LPop(ctx, k)
+}
+
+func (n *NamespacedCache) RPush(ctx context.Context, key string, fields ...string) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.RPush(ctx, k, fields...)
+}
+
+func (n *NamespacedCache) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return n.cache.SetNX(ctx, k, value, expiration)
+}
+
+func (n *NamespacedCache) Incr(ctx context.Context, key string) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.Incr(ctx, k)
+}
+
+func (n *NamespacedCache) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.IncrBy(ctx, k, value)
+}
+
+func (n *NamespacedCache) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return n.cache.Expire(ctx, k, expiration)
+}
+
+func (n *NamespacedCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.TTL(ctx, k)
+}
+
+func (n *NamespacedCache) LMove(ctx context.Context, source, destination, srcpos, destpos string) (string, error) {
+	src, err := n.key(ctx, source)
+	if err != nil {
+		return "", err
+	}
+	dst, err := n.key(ctx, destination)
+	if err != nil {
+		return "", err
+	}
+	return n.cache.LMove(ctx, src, dst, srcpos, destpos)
+}
+
+func (n *NamespacedCache) LRem(ctx context.Context, key string, count int64, value interface{}) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.LRem(ctx, k, count, value)
+}
+
+func (n *NamespacedCache) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return n.cache.LRange(ctx, k, start, stop)
+}
+
+func (n *NamespacedCache) Persist(ctx context.Context, key string) (bool, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return n.cache.Persist(ctx, k)
+}
+
+func (n *NamespacedCache) SAdd(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.SAdd(ctx, k, members...)
+}
+
+func (n *NamespacedCache) SRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.SRem(ctx, k, members...)
+}
+
+func (n *NamespacedCache) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return n.cache.SIsMember(ctx, k, member)
+}
+
+func (n *NamespacedCache) SMembers(ctx context.Context, key string) ([]string, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return n.cache.SMembers(ctx, k)
+}
+
+func (n *NamespacedCache) SCard(ctx context.Context, key string) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.SCard(ctx, k)
+}
+
+func (n *NamespacedCache) ZAdd(ctx context.Context, key string, members ...Z) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.ZAdd(ctx, k, members...)
+}
+
+func (n *NamespacedCache) ZIncrBy(ctx context.Context, key string, increment float64, member string) (float64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.ZIncrBy(ctx, k, increment, member)
+}
+
+func (n *NamespacedCache) ZRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.ZRem(ctx, k, members...)
+}
+
+func (n *NamespacedCache) ZScore(ctx context.Context, key, member string) (float64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.ZScore(ctx, k, member)
+}
+
+func (n *NamespacedCache) ZRank(ctx context.Context, key, member string) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.ZRank(ctx, k, member)
+}
+
+func (n *NamespacedCache) ZRevRank(ctx context.Context, key, member string) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.ZRevRank(ctx, k, member)
+}
+
+func (n *NamespacedCache) ZCard(ctx context.Context, key string) (int64, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.cache.ZCard(ctx, k)
+}
+
+func (n *NamespacedCache) ZRange(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return n.cache.ZRange(ctx, k, start, stop)
+}
+
+func (n *NamespacedCache) ZRevRange(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return n.cache.ZRevRange(ctx, k, start, stop)
+}
+
+func (n *NamespacedCache) ZRangeByScore(ctx context.Context, key string, opt *ZRangeBy) ([]Z, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return n.cache.ZRangeByScore(ctx, k, opt)
+}
+
+func (n *NamespacedCache) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	ks, err := n.keys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	found, err := n.cache.MGet(ctx, ks...)
+	if err != nil {
+		return nil, err
+	}
+	r := make(map[string]string, len(found))
+	for i, k := range keys {
+		if v, ok := found[ks[i]]; ok {
+			r[k] = v
+		}
+	}
+	return r, nil
+}
+
+func (n *NamespacedCache) MSet(ctx context.Context, values map[string]interface{}, expiration time.Duration) error {
+	prefixed := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		key, err := n.key(ctx, k)
+		if err != nil {
+			return err
+		}
+		prefixed[key] = v
+	}
+	return n.cache.MSet(ctx, prefixed, expiration)
+}
+
+func (n *NamespacedCache) MHGetAll(ctx context.Context, keys ...string) (map[string]map[string]string, error) {
+	ks, err := n.keys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	found, err := n.cache.MHGetAll(ctx, ks...)
+	if err != nil {
+		return nil, err
+	}
+	r := make(map[string]map[string]string, len(found))
+	for i, k := range keys {
+		if h, ok := found[ks[i]]; ok {
+			r[k] = h
+		}
+	}
+	return r, nil
+}
+
+func (n *NamespacedCache) Pipeline(ctx context.Context, fn func(p Pipeliner) error) error {
+	return n.cache.Pipeline(ctx, func(p Pipeliner) error {
+		np := &namespacedPipeliner{p: p, n: n}
+		if err := fn(np); err != nil {
+			return err
+		}
+		return np.err
+	})
+}
+
+// namespacedPipeliner prefixes the keys of queued commands. The pipeline is
+// not executed once a key could not be prefixed.
+type namespacedPipeliner struct {
+	p   Pipeliner
+	n   *NamespacedCache
+	err error
+}
+
+func (p *namespacedPipeliner) key(ctx context.Context, key string) string {
+	k, err := p.n.key(ctx, key)
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	return k
+}
+
+func (p *namespacedPipeliner) keys(ctx context.Context, keys []string) []string {
+	r := make([]string, len(keys))
+	for i, k := range keys {
+		r[i] = p.key(ctx, k)
+	}
+	return r
+}
+
+func (p *namespacedPipeliner) Get(ctx context.Context, key string) *StringCmd {
+	return p.p.Get(ctx, p.key(ctx, key))
+}
+
+func (p *namespacedPipeliner) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *StatusCmd {
+	return p.p.Set(ctx, p.key(ctx, key), value, expiration)
+}
+
+func (p *namespacedPipeliner) Del(ctx context.Context, keys ...string) *IntCmd {
+	return p.p.Del(ctx, p.keys(ctx, keys)...)
+}
+
+func (p *namespacedPipeliner) Exists(ctx context.Context, keys ...string) *IntCmd {
+	return p.p.Exists(ctx, p.keys(ctx, keys)...)
+}
+
+func (p *namespacedPipeliner) HGet(ctx context.Context, key, field string) *StringCmd {
+	return p.p.HGet(ctx, p.key(ctx, key), field)
+}
+
+func (p *namespacedPipeliner) HGetAll(ctx context.Context, key string) *MapStringStringCmd {
+	return p.p.HGetAll(ctx, p.key(ctx, key))
+}
+
+func (p *namespacedPipeliner) HSet(ctx context.Context, key string, values ...interface{}) *IntCmd {
+	return p.p.HSet(ctx, p.key(ctx, key), values...)
+}
+
+func (p *namespacedPipeliner) HDel(ctx context.Context, key string, fields ...string) *IntCmd {
+	return p.p.HDel(ctx, p.key(ctx, key), fields...)
+}
+
+func (p *namespacedPipeliner) RPush(ctx context.Context, key string, values ...interface{}) *IntCmd {
+	return p.p.RPush(ctx, p.key(ctx, key), values...)
+}
+
+func (p *namespacedPipeliner) LPop(ctx context.Context, key string) *StringCmd {
+	return p.p.LPop(ctx, p.key(ctx, key))
+}
+
+func (p *namespacedPipeliner) LLen(ctx context.Context, key string) *IntCmd {
+	return p.p.LLen(ctx, p.key(ctx, key))
+}
+
+func (p *namespacedPipeliner) LMove(ctx context.Context, source, destination, srcpos, destpos string) *StringCmd {
+	return p.p.LMove(ctx, p.key(ctx, source), p.key(ctx, destination), srcpos, destpos)
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespacedCache(t *testing.T) {
+	ctxt := context.Background()
+	shared := newMemory(t)
+	orders := NewNamespaced(shared, &Namespace{Environment: "prod", Service: "orders", Tenant: true})
+	billing := NewNamespaced(shared, &Namespace{Environment: "prod", Service: "billing"})
+
+	_, e := orders.Set(ctxt, "k1", "orders", 0)
+	assert.Nil(t, e)
+	_, e = billing.Set(ctxt, "k1", "billing", 0)
+	assert.Nil(t, e)
+	v, e := orders.Get(ctxt, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "orders", v)
+	v, e = shared.Get(ctxt, "prod:billing:k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "billing", v)
+
+	acme, globex := WithTenant(ctxt, "acme"), WithTenant(ctxt, "globex")
+	assert.Equal(t, "acme", Tenant(acme))
+	assert.Equal(t, "", Tenant(ctxt))
+	_, e = orders.HSet(acme, "h1", "f1", "acme")
+	assert.Nil(t, e)
+	_, e = orders.HGet(globex, "h1", "f1")
+	assert.ErrorIs(t, e, Nil)
+	h, e := shared.HGetAll(ctxt, "prod:orders:t/acme/h1")
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]string{"f1": "acme"}, h)
+
+	_, e = orders.RPush(acme, "l1", "v1")
+	assert.Nil(t, e)
+	assert.Nil(t, orders.Push(globex, "l1", "v2"))
+	n, e := orders.Count(acme, "l1")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(1), n)
+	v, e = orders.Pop(globex, "l1")
+	assert.Nil(t, e)
+	assert.Equal(t, "v2", v)
+
+	n, e = orders.Exists(acme, "h1", "l1", "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(2), n)
+	n, e = orders.Del(acme, "h1", "l1")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(2), n)
+	n, e = shared.Exists(ctxt, "prod:orders:s/k1", "prod:billing:k1")
+	assert.Nil(t, e)
+	assert.Equal(t, int64(2), n)
+
+	assert.Nil(t, orders.MSet(acme, map[string]interface{}{"k2": "v2", "k3": "v3"}, 0))
+	found, e := orders.MGet(acme, "k2", "k3", "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]string{"k2": "v2", "k3": "v3"}, found)
+	var get *StringCmd
+	e = orders.Pipeline(acme, func(p Pipeliner) error {
+		p.HSet(acme, "h2", "f1", "v1")
+		get = p.Get(acme, "k2")
+		return nil
+	})
+	assert.Nil(t, e)
+	assert.Equal(t, "v2", get.Val())
+	hashes, e := orders.MHGetAll(acme, "h2")
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]map[string]string{"h2": {"f1": "v1"}}, hashes)
+	_, e = shared.HGet(ctxt, "prod:orders:t/acme/h2", "f1")
+	assert.Nil(t, e)
+
+	// Shared keys are apart from the keys of tenants
+	_, e = orders.Set(ctxt, "acme/k2", "shared", 0)
+	assert.Nil(t, e)
+	v, e = orders.Get(acme, "k2")
+	assert.Nil(t, e)
+	assert.Equal(t, "v2", v)
+
+	invalid := WithTenant(ctxt, "acme/k2")
+	_, e = orders.Get(invalid, "k1")
+	assert.ErrorIs(t, e, ErrTenant)
+	_, e = orders.MGet(invalid, "k1")
+	assert.ErrorIs(t, e, ErrTenant)
+	assert.ErrorIs(t, orders.MSet(invalid, map[string]interface{}{"k1": "v1"}, 0), ErrTenant)
+	e = orders.Pipeline(ctxt, func(p Pipeliner) error {
+		p.Set(ctxt, "k4", "v4", 0)
+		p.Set(invalid, "k4", "v4", 0)
+		return nil
+	})
+	assert.ErrorIs(t, e, ErrTenant)
+	n, _ = orders.Exists(ctxt, "k4")
+	assert.Equal(t, int64(0), n, "not executed")
+}
+
+func TestNew_Namespace(t *testing.T) {
+	ctxt := context.Background()
+	c, m := New(ctxt, &Properties{Type: Memory, Namespace: Namespace{Service: "orders"}})
+	assert.True(t, m.Fine())
+	n, ok := c.(*NamespacedCache)
+	assert.True(t, ok)
+	_, e := c.Set(ctxt, "k1", "v1", 0)
+	assert.Nil(t, e)
+	v, e := n.cache.Get(ctxt, "orders:k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "v1", v)
+}
+
+func TestNamespacedCache_Sets(t *testing.T) {
+	shared := newMemory(t)
+	c := NewNamespaced(shared, &Namespace{Service: "orders"})
+	testCounters(t, c, "")
+	testSets(t, c, "")
+	testMoves(t, c, "")
+	assert.Empty(t, shared.entries)
+}
@@ -0,0 +1,370 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/gantries/knife/pkg/serde"
+	"github.com/gantries/knife/pkg/tel"
+)
+
+// Near configures the optional process local tier kept in front of redis.
+type Near struct {
+	Enabled bool          `json:"enabled" yaml:"enabled" default:"false"`
+	Size    int           `json:"size" yaml:"size" default:"10000"`
+	TTL     time.Duration `json:"ttl" yaml:"ttl" default:"1m"`
+	Channel string        `json:"channel" yaml:"channel" default:"knife/cache/near"`
+}
+
+type nearEntry struct {
+	key      string
+	value    interface{} // string for Get, map[string]string for HGetAll
+	expireAt time.Time
+}
+
+// NearCache serves Get, HGet and HGetAll from a bounded local LRU and reads
+// through to the remote cache on a miss. Writes going through NearCache are
+// broadcast so that every replica drops its local copy of the key.
+type NearCache struct {
+	Cache
+	mutex      sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List
+	size       int
+	ttl        time.Duration
+	generation uint64
+	publish    func(ctx context.Context, keys ...string) error
+	closer     func() error
+	hits       tel.SimpleCounter
+	misses     tel.SimpleCounter
+	now        func() time.Time
+}
+
+func newNearCache(remote Cache, cfg *Near, publish func(ctx context.Context, keys ...string) error) *NearCache {
+	return &NearCache{
+		Cache:   remote,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+		size:    cfg.Size,
+		ttl:     cfg.TTL,
+		publish: publish,
+		hits:    tel.Counter("knife.cache.near.hit"),
+		misses:  tel.Counter("knife.cache.near.miss"),
+		now:     time.Now,
+	}
+}
+
+// NewNear puts a local tier in front of r, invalidations are exchanged through
+// the redis channel configured in cfg.
+func NewNear(ctxt context.Context, r *RedisCache, cfg *Near) (*NearCache, *national.Message) {
+	channel := cfg.Channel
+	n := newNearCache(r, cfg, publisher(func(ctx context.Context, payload []byte) error {
+		_, err := r.Publish(ctx, channel, payload)
+		return err
+	}))
+	sub, err := r.Subscribe(context.WithoutCancel(ctxt), channel)
+	if err != nil {
+		return nil, errors.No(err)
+	}
+	n.closer = sub.Close
+	go sub.Listen(n.receive)
+	return n, errors.Yes()
+}
+
+// publisher sends the keys written by a replica as a JSON array, see receive.
+func publisher(send func(ctx context.Context, payload []byte) error) func(ctx context.Context, keys ...string) error {
+	return func(ctx context.Context, keys ...string) error {
+		buf, err := serde.Serialize(keys)
+		if err != nil {
+			return err
+		}
+		return send(ctx, buf)
+	}
+}
+
+// receive drops the keys written by another replica.
+func (n *NearCache) receive(m Message) {
+	keys, err := serde.DeserializeArray[string]([]byte(m.Payload))
+	if err != nil {
+		logger.Error("Unable to parse near cache invalidation", "error", err, "payload", m.Payload)
+		return
+	}
+	n.invalidate(keys...)
+}
+
+// Unwrap returns the remote cache.
+func (n *NearCache) Unwrap() Cache {
+	return n.Cache
+}
+
+// Close stops listening for invalidations of other replicas.
+func (n *NearCache) Close() error {
+	if n.closer != nil {
+		return n.closer()
+	}
+	return nil
+}
+
+func (n *NearCache) load(key string) (interface{}, uint64) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if el, ok := n.entries[key]; ok {
+		e := el.Value.(*nearEntry)
+		if n.now().Before(e.expireAt) {
+			n.order.MoveToFront(el)
+			return e.value, n.generation
+		}
+		n.order.Remove(el)
+		delete(n.entries, key)
+	}
+	return nil, n.generation
+}
+
+// save keeps value unless an invalidation happened after the remote read
+// started, which would make value stale.
+func (n *NearCache) save(key string, value interface{}, generation uint64) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if generation != n.generation || n.size <= 0 {
+		return
+	}
+	e := &nearEntry{key: key, value: value, expireAt: n.now().Add(n.ttl)}
+	if el, ok := n.entries[key]; ok {
+		el.Value = e
+		n.order.MoveToFront(el)
+		return
+	}
+	n.entries[key] = n.order.PushFront(e)
+	for n.order.Len() > n.size {
+		last := n.order.Back()
+		n.order.Remove(last)
+		delete(n.entries, last.Value.(*nearEntry).key)
+	}
+}
+
+func (n *NearCache) invalidate(keys ...string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.generation++
+	for _, k := range keys {
+		if el, ok := n.entries[k]; ok {
+			n.order.Remove(el)
+			delete(n.entries, k)
+		}
+	}
+}
+
+// written drops keys locally and tells other replicas to do the same.
+func (n *NearCache) written(ctx context.Context, keys ...string) {
+	n.invalidate(keys...)
+	if n.publish == nil || len(keys) == 0 {
+		return
+	}
+	if err := n.publish(ctx, keys...); err != nil {
+		logger.Error("Unable to publish near cache invalidation", "error", err, "keys", keys)
+	}
+}
+
+func (n *NearCache) Get(ctxt context.Context, key string) (string, error) {
+	v, generation := n.load(key)
+	if s, ok := v.(string); ok {
+		n.hits.Add(ctxt, 1)
+		return s, nil
+	}
+	n.misses.Add(ctxt, 1)
+	s, err := n.Cache.Get(ctxt, key)
+	if err == nil {
+		n.save(key, s, generation)
+	}
+	return s, err
+}
+
+func (n *NearCache) HGet(ctx context.Context, key, field string) (string, error) {
+	v, _ := n.load(key)
+	if h, ok := v.(map[string]string); ok {
+		n.hits.Add(ctx, 1)
+		if s, ok := h[field]; ok {
+			return s, nil
+		}
+		return "", Nil
+	}
+	n.misses.Add(ctx, 1)
+	return n.Cache.HGet(ctx, key, field)
+}
+
+func (n *NearCache) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	v, generation := n.load(key)
+	if h, ok := v.(map[string]string); ok {
+		n.hits.Add(ctx, 1)
+		return copyHash(h), nil
+	}
+	n.misses.Add(ctx, 1)
+	h, err := n.Cache.HGetAll(ctx, key)
+	if err == nil {
+		n.save(key, copyHash(h), generation)
+	}
+	return h, err
+}
+
+func (n *NearCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) (string, error) {
+	defer n.written(ctx, key)
+	return n.Cache.Set(ctx, key, value, expiration)
+}
+
+func (n *NearCache) Del(ctxt context.Context, keys ...string) (int64, error) {
+	defer n.written(ctxt, keys...)
+	return n.Cache.Del(ctxt, keys...)
+}
+
+func (n *NearCache) HSet(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	defer n.written(ctx, key)
+	return n.Cache.HSet(ctx, key, values...)
+}
+
+func (n *NearCache) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	defer n.written(ctx, key)
+	return n.Cache.HDel(ctx, key, fields...)
+}
+
+func (n *NearCache) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	defer n.written(ctx, key)
+	return n.Cache.SetNX(ctx, key, value, expiration)
+}
+
+func (n *NearCache) Incr(ctx context.Context, key string) (int64, error) {
+	defer n.written(ctx, key)
+	return n.Cache.Incr(ctx, key)
+}
+
+func (n *NearCache) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	defer n.written(ctx, key)
+	return n.Cache.IncrBy(ctx, key, value)
+}
+
+// Expire drops local copies as well, a non-positive expiration deletes key.
+func (n *NearCache) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	defer n.written(ctx, key)
+	return n.Cache.Expire(ctx, key, expiration)
+}
+
+func (n *NearCache) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	found := make(map[string]string, len(keys))
+	var missing []string
+	var generation uint64
+	for _, k := range keys {
+		v, g := n.load(k)
+		if s, ok := v.(string); ok {
+			found[k] = s
+			continue
+		}
+		if len(missing) == 0 {
+			generation = g
+		}
+		missing = append(missing, k)
+	}
+	n.hits.Add(ctx, int64(len(keys)-len(missing)))
+	if len(missing) == 0 {
+		return found, nil
+	}
+	n.misses.Add(ctx, int64(len(missing)))
+	remote, err := n.Cache.MGet(ctx, missing...)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range remote {
+		n.save(k, v, generation)
+		found[k] = v
+	}
+	return found, nil
+}
+
+func (n *NearCache) MHGetAll(ctx context.Context, keys ...string) (map[string]map[string]string, error) {
+	found := make(map[string]map[string]string, len(keys))
+	var missing []string
+	var generation uint64
+	for _, k := range keys {
+		v, g := n.load(k)
+		if h, ok := v.(map[string]string); ok {
+			if len(h) > 0 {
+				found[k] = copyHash(h)
+			}
+			continue
+		}
+		if len(missing) == 0 {
+			generation = g
+		}
+		missing = append(missing, k)
+	}
+	n.hits.Add(ctx, int64(len(keys)-len(missing)))
+	if len(missing) == 0 {
+		return found, nil
+	}
+	n.misses.Add(ctx, int64(len(missing)))
+	remote, err := n.Cache.MHGetAll(ctx, missing...)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range missing {
+		h := remote[k]
+		n.save(k, copyHash(h), generation)
+		if len(h) > 0 {
+			found[k] = h
+		}
+	}
+	return found, nil
+}
+
+func (n *NearCache) MSet(ctx context.Context, values map[string]interface{}, expiration time.Duration) error {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	defer n.written(ctx, keys...)
+	return n.Cache.MSet(ctx, values, expiration)
+}
+
+func (n *NearCache) Pipeline(ctx context.Context, fn func(p Pipeliner) error) error {
+	var keys []string
+	defer func() { n.written(ctx, keys...) }()
+	return n.Cache.Pipeline(ctx, func(p Pipeliner) error {
+		return fn(&nearPipeliner{Pipeliner: p, keys: &keys})
+	})
+}
+
+// nearPipeliner collects the keys written by queued commands.
+type nearPipeliner struct {
+	Pipeliner
+	keys *[]string
+}
+
+func (p *nearPipeliner) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *StatusCmd {
+	*p.keys = append(*p.keys, key)
+	return p.Pipeliner.Set(ctx, key, value, expiration)
+}
+
+func (p *nearPipeliner) Del(ctx context.Context, keys ...string) *IntCmd {
+	*p.keys = append(*p.keys, keys...)
+	return p.Pipeliner.Del(ctx, keys...)
+}
+
+func (p *nearPipeliner) HSet(ctx context.Context, key string, values ...interface{}) *IntCmd {
+	*p.keys = append(*p.keys, key)
+	return p.Pipeliner.HSet(ctx, key, values...)
+}
+
+func (p *nearPipeliner) HDel(ctx context.Context, key string, fields ...string) *IntCmd {
+	*p.keys = append(*p.keys, key)
+	return p.Pipeliner.HDel(ctx, key, fields...)
+}
+
+func copyHash(h map[string]string) map[string]string {
+	r := make(map[string]string, len(h))
+	for k, v := range h {
+		r[k] = v
+	}
+	return r
+}
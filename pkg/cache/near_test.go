@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newReplicas creates near caches sharing one remote, invalidations are
+// delivered to every replica the way the redis channel would.
+func newReplicas(t *testing.T, n int, cfg *Near) []*NearCache {
+	remote := newMemory(t)
+	replicas := make([]*NearCache, n)
+	broadcast := publisher(func(ctx context.Context, payload []byte) error {
+		for _, r := range replicas {
+			r.receive(Message{Channel: "knife:near", Payload: string(payload)})
+		}
+		return nil
+	})
+	for i := range replicas {
+		replicas[i] = newNearCache(remote, cfg, broadcast)
+	}
+	return replicas
+}
+
+func TestNearCache_Get(t *testing.T) {
+	ctxt := context.Background()
+	r := newReplicas(t, 2, &Near{Size: 10, TTL: time.Minute})
+
+	_, e := r[0].Set(ctxt, "k1", "v1", 0)
+	assert.Nil(t, e)
+	v, e := r[1].Get(ctxt, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "v1", v)
+
+	_, e = r[0].Cache.Set(ctxt, "k1", "v2", 0)
+	assert.Nil(t, e)
+	v, e = r[1].Get(ctxt, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "v1", v, "served from the local tier")
+
+	_, e = r[0].Set(ctxt, "k1", "v3", 0)
+	assert.Nil(t, e)
+	v, e = r[1].Get(ctxt, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "v3", v, "invalidated by the other replica")
+
+	_, e = r[0].Del(ctxt, "k1")
+	assert.Nil(t, e)
+	_, e = r[1].Get(ctxt, "k1")
+	assert.ErrorIs(t, e, Nil)
+}
+
+func TestNearCache_Hash(t *testing.T) {
+	ctxt := context.Background()
+	r := newReplicas(t, 2, &Near{Size: 10, TTL: time.Minute})
+
+	_, e := r[0].HSet(ctxt, "h1", "f1", "v1", "f2", "v2")
+	assert.Nil(t, e)
+	h, e := r[1].HGetAll(ctxt, "h1")
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]string{"f1": "v1", "f2": "v2"}, h)
+	h["f1"] = "changed"
+	v, e := r[1].HGet(ctxt, "h1", "f1")
+	assert.Nil(t, e)
+	assert.Equal(t, "v1", v)
+	_, e = r[1].HGet(ctxt, "h1", "f3")
+	assert.ErrorIs(t, e, Nil)
+
+	_, e = r[0].HDel(ctxt, "h1", "f1")
+	assert.Nil(t, e)
+	h, e = r[1].HGetAll(ctxt, "h1")
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]string{"f2": "v2"}, h)
+}
+
+func TestNearCache_Bounded(t *testing.T) {
+	ctxt := context.Background()
+	n := newReplicas(t, 1, &Near{Size: 2, TTL: time.Minute})[0]
+	now := time.Now()
+	n.now = func() time.Time { return now }
+
+	for _, k := range []string{"k1", "k2", "k3"} {
+		_, e := n.Set(ctxt, k, k, 0)
+		assert.Nil(t, e)
+		_, e = n.Get(ctxt, k)
+		assert.Nil(t, e)
+	}
+	assert.Equal(t, 2, n.order.Len())
+	assert.NotContains(t, n.entries, "k1")
+
+	now = now.Add(time.Minute)
+	v, _ := n.load("k3")
+	assert.Nil(t, v)
+	assert.Equal(t, 1, n.order.Len())
+}
+
+func TestNearCache_StaleFill(t *testing.T) {
+	ctxt := context.Background()
+	n := newReplicas(t, 1, &Near{Size: 10, TTL: time.Minute})[0]
+
+	_, generation := n.load("k1")
+	n.invalidate("k1")
+	n.save("k1", "stale", generation)
+	v, _ := n.load("k1")
+	assert.Nil(t, v)
+
+	_, e := n.Set(ctxt, "k1", "v1", 0)
+	assert.Nil(t, e)
+	_, e = n.Get(ctxt, "k1")
+	assert.Nil(t, e)
+	v, _ = n.load("k1")
+	assert.Equal(t, "v1", v)
+}
+
+func TestNearCache_Receive(t *testing.T) {
+	ctxt := context.Background()
+	n := newReplicas(t, 1, &Near{Size: 10, TTL: time.Minute})[0]
+	for _, k := range []string{"k1", "k2", "k3"} {
+		_, _ = n.Cache.Set(ctxt, k, "v1", 0)
+		_, _ = n.Get(ctxt, k)
+	}
+	n.receive(Message{Payload: `["k1","k2"]`})
+	n.receive(Message{Payload: `k3`})
+	assert.Equal(t, 1, n.order.Len(), "the keys of the payload are dropped")
+	v, _ := n.load("k3")
+	assert.Equal(t, "v1", v)
+}
+
+func TestNewNear(t *testing.T) {
+	ctxt := context.Background()
+	r, m := NewRedis(ctxt, &properties)
+	if !m.Fine() {
+		t.Skip("Redis not available")
+	}
+	cfg := Near{Enabled: true, Size: 10, TTL: time.Minute, Channel: "knife:near:ut"}
+	n1, m := NewNear(ctxt, r, &cfg)
+	assert.True(t, m.Fine())
+	defer func() { _ = n1.Close() }()
+	n2, m := NewNear(ctxt, r, &cfg)
+	assert.True(t, m.Fine())
+	defer func() { _ = n2.Close() }()
+
+	_, e := n1.Set(ctxt, "knife:near", "v1", 0)
+	assert.Nil(t, e)
+	v, e := n2.Get(ctxt, "knife:near")
+	assert.Nil(t, e)
+	assert.Equal(t, "v1", v)
+	_, e = n1.Set(ctxt, "knife:near", "v2", 0)
+	assert.Nil(t, e)
+	assert.Eventually(t, func() bool {
+		v, _ := n2.load("knife:near")
+		return v == nil
+	}, time.Second, 10*time.Millisecond)
+	v, e = n2.Get(ctxt, "knife:near")
+	assert.Nil(t, e)
+	assert.Equal(t, "v2", v)
+}
+
+func TestNearCache_Batch(t *testing.T) {
+	ctxt := context.Background()
+	r := newReplicas(t, 2, &Near{Size: 10, TTL: time.Minute})
+
+	assert.Nil(t, r[0].MSet(ctxt, map[string]interface{}{"k1": "v1", "k2": "v2"}, 0))
+	found, e := r[1].MGet(ctxt, "k1", "k2", "k3")
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "v2"}, found)
+	v, _ := r[1].load("k2")
+	assert.Equal(t, "v2", v)
+
+	e = r[0].Pipeline(ctxt, func(p Pipeliner) error {
+		p.Set(ctxt, "k2", "changed", 0)
+		p.HSet(ctxt, "h1", "f1", "v1")
+		return nil
+	})
+	assert.Nil(t, e)
+	v, _ = r[1].load("k2")
+	assert.Nil(t, v, "invalidated by the pipeline")
+	found, e = r[1].MGet(ctxt, "k1", "k2")
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "changed"}, found)
+
+	hashes, e := r[1].MHGetAll(ctxt, "h1", "h2")
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]map[string]string{"h1": {"f1": "v1"}}, hashes)
+	hashes, e = r[1].MHGetAll(ctxt, "h1", "h2")
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]map[string]string{"h1": {"f1": "v1"}}, hashes, "served from the local tier")
+}
+
+func TestNearCache_Counters(t *testing.T) {
+	ctxt := context.Background()
+	r := newReplicas(t, 2, &Near{Size: 10, TTL: time.Minute})
+
+	_, e := r[0].Incr(ctxt, "k1")
+	assert.Nil(t, e)
+	v, e := r[1].Get(ctxt, "k1")
+	assert.Nil(t, e)
+	assert.Equal(t, "1", v)
+	_, e = r[0].IncrBy(ctxt, "k1", 2)
+	assert.Nil(t, e)
+	v, _ = r[1].Get(ctxt, "k1")
+	assert.Equal(t, "3", v)
+
+	_, e = r[0].Expire(ctxt, "k1", 0)
+	assert.Nil(t, e)
+	ok, e := r[0].SetNX(ctxt, "k1", "v1", 0)
+	assert.Nil(t, e)
+	assert.True(t, ok)
+	v, _ = r[1].Get(ctxt, "k1")
+	assert.Equal(t, "v1", v)
+	testCounters(t, r[0], "n:")
+}
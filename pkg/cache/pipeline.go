@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"context"
+	stderrors "errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type (
+	Cmder              = redis.Cmder
+	StringCmd          = redis.StringCmd
+	StatusCmd          = redis.StatusCmd
+	IntCmd             = redis.IntCmd
+	MapStringStringCmd = redis.MapStringStringCmd
+)
+
+// Pipeliner queues commands which are sent together once the function given
+// to Cache.Pipeline returns, results are available from the commands after
+// that. In cluster mode the keys of a multi-key command must share one slot.
+type Pipeliner interface {
+	Get(ctx context.Context, key string) *StringCmd
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *StatusCmd
+	Del(ctx context.Context, keys ...string) *IntCmd
+	Exists(ctx context.Context, keys ...string) *IntCmd
+	HGet(ctx context.Context, key, field string) *StringCmd
+	HGetAll(ctx context.Context, key string) *MapStringStringCmd
+	HSet(ctx context.Context, key string, values ...interface{}) *IntCmd
+	HDel(ctx context.Context, key string, fields ...string) *IntCmd
+	RPush(ctx context.Context, key string, values ...interface{}) *IntCmd
+	LPop(ctx context.Context, key string) *StringCmd
+	LLen(ctx context.Context, key string) *IntCmd
+	LMove(ctx context.Context, source, destination, srcpos, destpos string) *StringCmd
+}
+
+// pipelined returns the first failure of cmds, missing keys are reported by
+// the commands only.
+func pipelined(cmds []Cmder, err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, c := range cmds {
+		if e := c.Err(); e != nil && !stderrors.Is(e, Nil) {
+			return e
+		}
+	}
+	if stderrors.Is(err, Nil) {
+		return nil
+	}
+	return err
+}
+
+// slot returns the cluster hash slot of key, only the hash tag between the
+// first "{" and the following "}" counts when present.
+func slot(key string) uint16 {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc % 16384
+}
+
+// memoryPipeline runs the queued commands against a MemoryCache one after the
+// other.
+type memoryPipeline struct {
+	cache *MemoryCache
+	cmds  []Cmder
+	ops   []func() error
+}
+
+func (p *memoryPipeline) queue(cmd Cmder, op func() error) {
+	p.cmds = append(p.cmds, cmd)
+	p.ops = append(p.ops, func() error {
+		err := op()
+		if err != nil {
+			cmd.SetErr(err)
+		}
+		return err
+	})
+}
+
+func (p *memoryPipeline) exec() error {
+	var first error
+	for _, op := range p.ops {
+		if err := op(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return pipelined(p.cmds, first)
+}
+
+func (p *memoryPipeline) Get(ctx context.Context, key string) *StringCmd {
+	cmd := redis.NewStringCmd(ctx, "get", key)
+	p.queue(cmd, func() error {
+		v, err := p.cache.Get(ctx, key)
+		cmd.SetVal(v)
+		return err
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *StatusCmd {
+	cmd := redis.NewStatusCmd(ctx, "set", key, value)
+	p.queue(cmd, func() error {
+		v, err := p.cache.Set(ctx, key, value, expiration)
+		cmd.SetVal(v)
+		return err
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) Del(ctx context.Context, keys ...string) *IntCmd {
+	cmd := redis.NewIntCmd(ctx, "del")
+	p.queue(cmd, func() error {
+		v, err := p.cache.Del(ctx, keys...)
+		cmd.SetVal(v)
+		return err
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) Exists(ctx context.Context, keys ...string) *IntCmd {
+	cmd := redis.NewIntCmd(ctx, "exists")
+	p.queue(cmd, func() error {
+		v, err := p.cache.Exists(ctx, keys...)
+		cmd.SetVal(v)
+		return err
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) HGet(ctx context.Context, key, field string) *StringCmd {
+	cmd := redis.NewStringCmd(ctx, "hget", key, field)
+	p.queue(cmd, func() error {
+		v, err := p.cache.HGet(ctx, key, field)
+		cmd.SetVal(v)
+		return err
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) HGetAll(ctx context.Context, key string) *MapStringStringCmd {
+	cmd := redis.NewMapStringStringCmd(ctx, "hgetall", key)
+	p.queue(cmd, func() error {
+		v, err := p.cache.HGetAll(ctx, key)
+		cmd.SetVal(v)
+		return err
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) HSet(ctx context.Context, key string, values ...interface{}) *IntCmd {
+	cmd := redis.NewIntCmd(ctx, "hset", key)
+	p.queue(cmd, func() error {
+		v, err := p.cache.HSet(ctx, key, values...)
+		cmd.SetVal(v)
+		return err
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) HDel(ctx context.Context, key string, fields ...string) *IntCmd {
+	cmd := redis.NewIntCmd(ctx, "hdel", key)
+	p.queue(cmd, func() error {
+		v, err := p.cache.HDel(ctx, key, fields...)
+		cmd.SetVal(v)
+		return err
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) RPush(ctx context.Context, key string, values ...interface{}) *IntCmd {
+	cmd := redis.NewIntCmd(ctx, "rpush", key)
+	p.queue(cmd, func() error {
+		v, err := p.cache.push(key, values)
+		cmd.SetVal(v)
+		return err
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) LPop(ctx context.Context, key string) *StringCmd {
+	cmd := redis.NewStringCmd(ctx, "lpop", key)
+	p.queue(cmd, func() error {
+		v, err := p.cache.LPop(ctx, key)
+		cmd.SetVal(v)
+		return err
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) LLen(ctx context.Context, key string) *IntCmd {
+	cmd := redis.NewIntCmd(ctx, "llen", key)
+	p.queue(cmd, func() error {
+		v, err := p.cache.Count(ctx, key)
+		cmd.SetVal(v)
+		return err
+	})
+	return cmd
+}
+
+func (p *memoryPipeline) LMove(ctx context.Context, source, destination, srcpos, destpos string) *StringCmd {
+	cmd := redis.NewStringCmd(ctx, "lmove", source, destination, srcpos, destpos)
+	p.queue(cmd, func() error {
+		v, err := p.cache.LMove(ctx, source, destination, srcpos, destpos)
+		cmd.SetVal(v)
+		return err
+	})
+	return cmd
+}
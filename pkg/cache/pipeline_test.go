@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlot(t *testing.T) {
+	assert.Equal(t, uint16(12182), slot("foo"))
+	assert.Equal(t, uint16(12739), slot("123456789"))
+	assert.Equal(t, slot("user1000"), slot("{user1000}.following"))
+	assert.Equal(t, slot("{user1000}.following"), slot("{user1000}.followers"))
+	assert.NotEqual(t, slot(""), slot("{}foo"), "empty hash tags are ignored")
+}
+
+func TestRedisCache_Slots(t *testing.T) {
+	keys := []string{"{a}1", "{b}1", "{a}2", "{b}2", "{c}1"}
+	assert.Equal(t, [][]string{keys}, (&RedisCache{}).slots(keys))
+	assert.Equal(t, [][]string{{"{a}1", "{a}2"}, {"{b}1", "{b}2"}, {"{c}1"}}, (&RedisCache{cluster: true}).slots(keys))
+}
+
+// testBatch exercises the batch operations of c, keys are prefixed by
+// "knife:batch:".
+func testBatch(t *testing.T, c Cache) {
+	ctxt := context.Background()
+	keys := make([]string, 0, 20)
+	values := map[string]interface{}{}
+	for i := 0; i < 20; i++ {
+		k := fmt.Sprintf("knife:batch:{%d}", i)
+		keys = append(keys, k)
+		values[k] = i
+	}
+	defer func() { _, _ = c.Del(ctxt, keys...) }()
+
+	assert.Nil(t, c.MSet(ctxt, values, time.Minute))
+	found, e := c.MGet(ctxt, append(keys, "knife:batch:missing")...)
+	assert.Nil(t, e)
+	assert.Len(t, found, 20)
+	assert.Equal(t, "7", found["knife:batch:{7}"])
+	assert.Nil(t, c.MSet(ctxt, map[string]interface{}{"knife:batch:{1}": "one"}, 0))
+	v, e := c.Get(ctxt, "knife:batch:{1}")
+	assert.Nil(t, e)
+	assert.Equal(t, "one", v)
+
+	var get *StringCmd
+	var hash *MapStringStringCmd
+	e = c.Pipeline(ctxt, func(p Pipeliner) error {
+		p.HSet(ctxt, keys[0]+"h", "f1", "v1")
+		p.HSet(ctxt, keys[1]+"h", "f2", "v2")
+		p.Del(ctxt, keys[2])
+		get = p.Get(ctxt, keys[2])
+		hash = p.HGetAll(ctxt, keys[0]+"h")
+		return nil
+	})
+	defer func() { _, _ = c.Del(ctxt, keys[0]+"h", keys[1]+"h") }()
+	assert.Nil(t, e)
+	assert.ErrorIs(t, get.Err(), Nil)
+	assert.Equal(t, map[string]string{"f1": "v1"}, hash.Val())
+
+	hashes, e := c.MHGetAll(ctxt, keys[0]+"h", keys[1]+"h", "knife:batch:missing")
+	assert.Nil(t, e)
+	assert.Equal(t, map[string]map[string]string{
+		keys[0] + "h": {"f1": "v1"},
+		keys[1] + "h": {"f2": "v2"},
+	}, hashes)
+
+	e = c.Pipeline(ctxt, func(p Pipeliner) error {
+		p.HSet(ctxt, keys[3], "f1", "v1")
+		return nil
+	})
+	assert.NotNil(t, e, "wrong type")
+	e = c.Pipeline(ctxt, func(p Pipeliner) error {
+		p.Del(ctxt, keys[4])
+		return fmt.Errorf("abort")
+	})
+	assert.EqualError(t, e, "abort")
+	n, e := c.Exists(ctxt, keys[4])
+	assert.Nil(t, e)
+	assert.Equal(t, int64(1), n, "nothing is sent when fn fails")
+}
+
+func TestMemoryCache_Batch(t *testing.T) {
+	testBatch(t, newMemory(t))
+}
+
+func TestRedisCache_Batch(t *testing.T) {
+	c, m := NewRedis(context.Background(), &properties)
+	if !m.Fine() {
+		t.Skip("Redis not available")
+	}
+	testBatch(t, c)
+}
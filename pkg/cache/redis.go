@@ -2,56 +2,139 @@ package cache
 
 import (
 	"context"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gantries/knife/pkg/errors"
 	"github.com/gantries/knife/pkg/log"
 	"github.com/gantries/knife/pkg/national"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/metric"
 )
 
 var logger = log.New("knife/cache/redis")
 
 type client interface {
+	redis.Scripter
 	Ping(ctx context.Context) *redis.StatusCmd
+	PoolStats() *redis.PoolStats
+	Close() error
+	Pipeline() redis.Pipeliner
 	RPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd
 	LPop(ctx context.Context, key string) *redis.StringCmd
 	LLen(ctxt context.Context, key string) *redis.IntCmd
+	LMove(ctx context.Context, source, destination, srcpos, destpos string) *redis.StringCmd
+	LRem(ctx context.Context, key string, count int64, value interface{}) *redis.IntCmd
+	LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd
 	SetNX(ctxt context.Context, key string, value interface{}, timeout time.Duration) *redis.BoolCmd
 	Del(ctxt context.Context, keys ...string) *redis.IntCmd
 	Get(ctxt context.Context, key string) *redis.StringCmd
 	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd
 	HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd
 	HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd
+	HLen(ctx context.Context, key string) *redis.IntCmd
 	HGet(ctx context.Context, key, field string) *redis.StringCmd
 	HSet(ctx context.Context, key string, values ...interface{}) *redis.IntCmd
 	Exists(ctx context.Context, keys ...string) *redis.IntCmd
+	Incr(ctx context.Context, key string) *redis.IntCmd
+	IncrBy(ctx context.Context, key string, value int64) *redis.IntCmd
+	Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd
+	TTL(ctx context.Context, key string) *redis.DurationCmd
+	Persist(ctx context.Context, key string) *redis.BoolCmd
+	SAdd(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	SRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	SIsMember(ctx context.Context, key string, member interface{}) *redis.BoolCmd
+	SMembers(ctx context.Context, key string) *redis.StringSliceCmd
+	SCard(ctx context.Context, key string) *redis.IntCmd
+	ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd
+	ZIncrBy(ctx context.Context, key string, increment float64, member string) *redis.FloatCmd
+	ZRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	ZScore(ctx context.Context, key, member string) *redis.FloatCmd
+	ZRank(ctx context.Context, key, member string) *redis.IntCmd
+	ZRevRank(ctx context.Context, key, member string) *redis.IntCmd
+	ZCard(ctx context.Context, key string) *redis.IntCmd
+	ZRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd
+	ZRevRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd
+	ZRangeByScoreWithScores(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.ZSliceCmd
+	Publish(ctx context.Context, channel string, message interface{}) *redis.IntCmd
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+	XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd
+	XGroupCreateMkStream(ctx context.Context, stream, group, start string) *redis.StatusCmd
+	XReadGroup(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd
+	XAck(ctx context.Context, stream, group string, ids ...string) *redis.IntCmd
+	XAutoClaim(ctx context.Context, a *redis.XAutoClaimArgs) *redis.XAutoClaimCmd
 }
 
 type RedisCache struct {
-	redis client
+	redis   client
+	cluster bool
+	mutex   sync.Mutex
+	pool    []metric.Registration // of the pool gauges, see observePool
 }
 
 func NewRedis(ctxt context.Context, cfg *Properties) (*RedisCache, *national.Message) {
+	if cfg.Addresses.Empty() {
+		return nil, errors.MissingValueError.Build("value", "addresses")
+	}
+	password, err := cfg.Credential.Secret()
+	if err != nil {
+		return nil, errors.No(err)
+	}
+	secure, err := cfg.TLS.Config()
+	if err != nil {
+		return nil, errors.No(err)
+	}
 	var rc RedisCache
-	if cfg.Addresses.Length() > 1 {
+	switch mode := cfg.mode(); mode {
+	case Cluster:
 		rc = RedisCache{redis: redis.NewClusterClient(&redis.ClusterOptions{
-			Addrs:           cfg.Addresses,
-			Username:        cfg.Credential.Username,
-			Password:        cfg.Credential.Password,
-			MaxRetries:      cfg.Pool.MaxRetries,
-			MinIdleConns:    cfg.Pool.MinIdleConnections,
-			MaxIdleConns:    cfg.Pool.MaxIdleConnections,
-			MaxActiveConns:  cfg.Pool.MaxActiveConnections,
-			ConnMaxIdleTime: cfg.Pool.MaxConnectionIdleTime,
-			ConnMaxLifetime: cfg.Pool.MaxConnectionLifeTime,
+			Addrs:                 cfg.Addresses,
+			Username:              cfg.Credential.Username,
+			Password:              password,
+			TLSConfig:             secure,
+			MaxRetries:            cfg.Pool.MaxRetries,
+			ContextTimeoutEnabled: true,
+			MinIdleConns:          cfg.Pool.MinIdleConnections,
+			MaxIdleConns:          cfg.Pool.MaxIdleConnections,
+			MaxActiveConns:        cfg.Pool.MaxActiveConnections,
+			ConnMaxIdleTime:       cfg.Pool.MaxConnectionIdleTime,
+			ConnMaxLifetime:       cfg.Pool.MaxConnectionLifeTime,
+		}), cluster: true}
+	case Sentinel:
+		if len(cfg.Failover.MasterName) == 0 {
+			return nil, errors.MissingValueError.Build("value", "failover.master_name")
+		}
+		sentinelPassword, err := cfg.Failover.Credential.Secret()
+		if err != nil {
+			return nil, errors.No(err)
+		}
+		rc = RedisCache{redis: redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:            cfg.Failover.MasterName,
+			SentinelAddrs:         cfg.Addresses,
+			SentinelUsername:      cfg.Failover.Credential.Username,
+			SentinelPassword:      sentinelPassword,
+			Username:              cfg.Credential.Username,
+			Password:              password,
+			DB:                    cfg.Database,
+			TLSConfig:             secure,
+			MaxRetries:            cfg.Pool.MaxRetries,
+			ContextTimeoutEnabled: true,
+			PoolFIFO:              false,
+			PoolSize:              cfg.Pool.Size,
+			MinIdleConns:          cfg.Pool.MinIdleConnections,
+			MaxIdleConns:          cfg.Pool.MaxIdleConnections,
+			MaxActiveConns:        cfg.Pool.MaxActiveConnections,
+			ConnMaxIdleTime:       cfg.Pool.MaxConnectionIdleTime,
+			ConnMaxLifetime:       cfg.Pool.MaxConnectionLifeTime,
 		})}
-	} else {
+	case Standalone:
 		rc = RedisCache{redis: redis.NewClient(&redis.Options{
 			Addr:                  cfg.Addresses[0],
 			Username:              cfg.Credential.Username,
-			Password:              cfg.Credential.Password,
+			Password:              password,
 			DB:                    cfg.Database,
+			TLSConfig:             secure,
 			MaxRetries:            cfg.Pool.MaxRetries,
 			ContextTimeoutEnabled: true,
 			PoolFIFO:              false,
@@ -63,6 +146,8 @@ func NewRedis(ctxt context.Context, cfg *Properties) (*RedisCache, *national.Mes
 			ConnMaxLifetime:       cfg.Pool.MaxConnectionLifeTime,
 			DisableIndentity:      false,
 		})}
+	default:
+		return nil, errors.UnsupportedValueError.Build("type", "cache-mode", "value", mode)
 	}
 	if err := rc.Ping(ctxt); err != nil {
 		return nil, errors.No(err)
@@ -90,28 +175,55 @@ func (r *RedisCache) Count(ctx context.Context, key string) (int64, error) {
 	return r.redis.LLen(ctx, key).Result()
 }
 
+var unlockScript = redis.NewScript(`local key = KEYS[1] local value = ARGV[1] if redis.call('get',key) == value then return redis.call('del',key) else return 0 end`)
+
+// Lock is a plain SETNX, see synch.RedisLock for a lock with lease renewal.
 func (r *RedisCache) Lock(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
-	result, err := r.redis.SetNX(ctx, source, owner, timeout).Result()
-	if result {
-		return result, nil
-	}
-	if err != nil {
-		logger.Error("Lock failed", "error", err, "source", source, "owner", owner)
-		return false, nil
-	}
-	return false, nil
+	return r.redis.SetNX(ctx, source, owner, timeout).Result()
 }
 
 func (r *RedisCache) Unlock(ctx context.Context, source, owner string) (bool, error) {
-	var script = redis.NewScript(`local key = KEYS[1] local value = ARGV[1] if redis.call('get',key) == value then return redis.call('del',key) else return 0 end`)
-	keys := []string{source}
-	values := []string{owner}
-	nums, err := script.Run(ctx, r.redis.(redis.Scripter), keys, values).Bool()
-	if err != nil {
-		logger.Error("Unlock failed", "error", err, "source", source, "owner", owner)
-		return false, nil
+	return unlockScript.Run(ctx, r.redis, []string{source}, owner).Bool()
+}
+
+// Close stops exporting the pool statistics and closes the client.
+func (r *RedisCache) Close() error {
+	r.mutex.Lock()
+	pool := r.pool
+	r.pool = nil
+	r.mutex.Unlock()
+	for _, g := range pool {
+		if err := g.Unregister(); err != nil {
+			logger.Warn("Unable to stop observing cache pool", "error", err)
+		}
 	}
-	return nums, nil
+	return r.redis.Close()
+}
+
+// PoolStats reports the usage of the connection pool.
+func (r *RedisCache) PoolStats() *PoolStats {
+	return r.redis.PoolStats()
+}
+
+// ScripterOf returns the redis client behind c and the caches it wraps, or
+// nil when c is not backed by redis. Keys used through it are not namespaced.
+func ScripterOf(c Cache) redis.Scripter {
+	for c != nil {
+		if r, ok := c.(*RedisCache); ok {
+			return r.Scripter()
+		}
+		w, ok := c.(interface{ Unwrap() Cache })
+		if !ok {
+			return nil
+		}
+		c = w.Unwrap()
+	}
+	return nil
+}
+
+// Scripter gives access to lua scripting on the underlying client.
+func (r *RedisCache) Scripter() redis.Scripter {
+	return r.redis
 }
 
 func (r *RedisCache) HSet(ctx context.Context, key string, values ...interface{}) (int64, error) {
@@ -130,6 +242,10 @@ func (r *RedisCache) HGetAll(ctx context.Context, key string) (map[string]string
 	return r.redis.HGetAll(ctx, key).Result()
 }
 
+func (r *RedisCache) HLen(ctx context.Context, key string) (int64, error) {
+	return r.redis.HLen(ctx, key).Result()
+}
+
 func (r *RedisCache) Del(ctxt context.Context, keys ...string) (int64, error) {
 	return r.redis.Del(ctxt, keys...).Result()
 }
@@ -142,6 +258,18 @@ func (r *RedisCache) LPop(ctx context.Context, key string) (string, error) {
 	return r.redis.LPop(ctx, key).Result()
 }
 
+func (r *RedisCache) LMove(ctx context.Context, source, destination, srcpos, destpos string) (string, error) {
+	return r.redis.LMove(ctx, source, destination, srcpos, destpos).Result()
+}
+
+func (r *RedisCache) LRem(ctx context.Context, key string, count int64, value interface{}) (int64, error) {
+	return r.redis.LRem(ctx, key, count, value).Result()
+}
+
+func (r *RedisCache) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return r.redis.LRange(ctx, key, start, stop).Result()
+}
+
 func (r *RedisCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) (string, error) {
 	return r.redis.Set(ctx, key, value, expiration).Result()
 }
@@ -153,3 +281,283 @@ func (r *RedisCache) Get(ctxt context.Context, key string) (string, error) {
 func (r *RedisCache) Exists(ctx context.Context, keys ...string) (int64, error) {
 	return r.redis.Exists(ctx, keys...).Result()
 }
+
+func (r *RedisCache) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	return r.redis.SetNX(ctx, key, value, expiration).Result()
+}
+
+func (r *RedisCache) Incr(ctx context.Context, key string) (int64, error) {
+	return r.redis.Incr(ctx, key).Result()
+}
+
+func (r *RedisCache) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	return r.redis.IncrBy(ctx, key, value).Result()
+}
+
+func (r *RedisCache) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	if expiration <= 0 {
+		n, err := r.redis.Del(ctx, key).Result()
+		return n > 0, err
+	}
+	return r.redis.Expire(ctx, key, expiration).Result()
+}
+
+func (r *RedisCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return r.redis.TTL(ctx, key).Result()
+}
+
+func (r *RedisCache) Persist(ctx context.Context, key string) (bool, error) {
+	return r.redis.Persist(ctx, key).Result()
+}
+
+func (r *RedisCache) SAdd(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return r.redis.SAdd(ctx, key, members...).Result()
+}
+
+func (r *RedisCache) SRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return r.redis.SRem(ctx, key, members...).Result()
+}
+
+func (r *RedisCache) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	return r.redis.SIsMember(ctx, key, member).Result()
+}
+
+func (r *RedisCache) SMembers(ctx context.Context, key string) ([]string, error) {
+	return r.redis.SMembers(ctx, key).Result()
+}
+
+func (r *RedisCache) SCard(ctx context.Context, key string) (int64, error) {
+	return r.redis.SCard(ctx, key).Result()
+}
+
+func (r *RedisCache) ZAdd(ctx context.Context, key string, members ...Z) (int64, error) {
+	return r.redis.ZAdd(ctx, key, members...).Result()
+}
+
+func (r *RedisCache) ZIncrBy(ctx context.Context, key string, increment float64, member string) (float64, error) {
+	return r.redis.ZIncrBy(ctx, key, increment, member).Result()
+}
+
+func (r *RedisCache) ZRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return r.redis.ZRem(ctx, key, members...).Result()
+}
+
+func (r *RedisCache) ZScore(ctx context.Context, key, member string) (float64, error) {
+	return r.redis.ZScore(ctx, key, member).Result()
+}
+
+func (r *RedisCache) ZRank(ctx context.Context, key, member string) (int64, error) {
+	return r.redis.ZRank(ctx, key, member).Result()
+}
+
+func (r *RedisCache) ZRevRank(ctx context.Context, key, member string) (int64, error) {
+	return r.redis.ZRevRank(ctx, key, member).Result()
+}
+
+func (r *RedisCache) ZCard(ctx context.Context, key string) (int64, error) {
+	return r.redis.ZCard(ctx, key).Result()
+}
+
+func (r *RedisCache) ZRange(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	return r.redis.ZRangeWithScores(ctx, key, start, stop).Result()
+}
+
+func (r *RedisCache) ZRevRange(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	return r.redis.ZRevRangeWithScores(ctx, key, start, stop).Result()
+}
+
+func (r *RedisCache) ZRangeByScore(ctx context.Context, key string, opt *ZRangeBy) ([]Z, error) {
+	return r.redis.ZRangeByScoreWithScores(ctx, key, opt).Result()
+}
+
+// slots groups keys by cluster hash slot, multi-key commands fail when their
+// keys belong to different slots. Outside cluster mode all keys form one group.
+func (r *RedisCache) slots(keys []string) [][]string {
+	if !r.cluster {
+		return [][]string{keys}
+	}
+	var groups [][]string
+	index := map[uint16]int{}
+	for _, k := range keys {
+		s := slot(k)
+		i, ok := index[s]
+		if !ok {
+			i = len(groups)
+			index[s] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], k)
+	}
+	return groups
+}
+
+func (r *RedisCache) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	found := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return found, nil
+	}
+	groups := r.slots(keys)
+	p := r.redis.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(groups))
+	for i, g := range groups {
+		cmds[i] = p.MGet(ctx, g...)
+	}
+	if err := pipelined(p.Exec(ctx)); err != nil {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		for j, v := range cmd.Val() {
+			if s, ok := v.(string); ok {
+				found[groups[i][j]] = s
+			}
+		}
+	}
+	return found, nil
+}
+
+func (r *RedisCache) MSet(ctx context.Context, values map[string]interface{}, expiration time.Duration) error {
+	if len(values) == 0 {
+		return nil
+	}
+	p := r.redis.Pipeline()
+	if expiration > 0 {
+		for k, v := range values {
+			p.Set(ctx, k, v, expiration)
+		}
+	} else {
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		for _, g := range r.slots(keys) {
+			pairs := make([]interface{}, 0, 2*len(g))
+			for _, k := range g {
+				pairs = append(pairs, k, values[k])
+			}
+			p.MSet(ctx, pairs...)
+		}
+	}
+	return pipelined(p.Exec(ctx))
+}
+
+func (r *RedisCache) MHGetAll(ctx context.Context, keys ...string) (map[string]map[string]string, error) {
+	found := make(map[string]map[string]string, len(keys))
+	if len(keys) == 0 {
+		return found, nil
+	}
+	p := r.redis.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(keys))
+	for i, k := range keys {
+		cmds[i] = p.HGetAll(ctx, k)
+	}
+	if err := pipelined(p.Exec(ctx)); err != nil {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		if h := cmd.Val(); len(h) > 0 {
+			found[keys[i]] = h
+		}
+	}
+	return found, nil
+}
+
+func (r *RedisCache) Pipeline(ctx context.Context, fn func(p Pipeliner) error) error {
+	p := r.redis.Pipeline()
+	if err := fn(p); err != nil {
+		return err
+	}
+	return pipelined(p.Exec(ctx))
+}
+
+func (r *RedisCache) Publish(ctx context.Context, channel string, message interface{}) (int64, error) {
+	return r.redis.Publish(ctx, channel, message).Result()
+}
+
+func (r *RedisCache) Subscribe(ctx context.Context, channels ...string) (*Subscription, error) {
+	ps := r.redis.Subscribe(ctx, channels...)
+	if _, err := ps.Receive(ctx); err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+	s := newSubscription(ctx, ps.Close)
+	go func() {
+		defer close(s.messages)
+		ch := ps.Channel()
+		for {
+			select {
+			case <-s.done:
+				return
+			case m, ok := <-ch:
+				if !ok || !s.deliver(Message{Channel: m.Channel, Payload: m.Payload}) {
+					return
+				}
+			}
+		}
+	}()
+	return s, nil
+}
+
+func (r *RedisCache) XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	return r.redis.XAdd(ctx, &redis.XAddArgs{Stream: stream, MaxLen: maxLen, Approx: maxLen > 0, Values: values}).Result()
+}
+
+func (r *RedisCache) XGroupCreate(ctx context.Context, stream, group, start string) error {
+	err := r.redis.XGroupCreateMkStream(ctx, stream, group, start).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+func (r *RedisCache) XReadGroup(ctx context.Context, stream, group, consumer string, count int64,
+	block time.Duration) ([]Entry, error) {
+	if block <= 0 {
+		block = -1
+	}
+	streams, err := r.redis.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, s := range streams {
+		entries = append(entries, toEntries(s.Messages)...)
+	}
+	return entries, nil
+}
+
+func (r *RedisCache) XAck(ctx context.Context, stream, group string, ids ...string) (int64, error) {
+	return r.redis.XAck(ctx, stream, group, ids...).Result()
+}
+
+func (r *RedisCache) XClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration,
+	count int64) ([]Entry, error) {
+	messages, _, err := r.redis.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Start:    "0-0",
+		Count:    count,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return toEntries(messages), nil
+}
+
+func toEntries(messages []redis.XMessage) []Entry {
+	entries := make([]Entry, len(messages))
+	for i, m := range messages {
+		entries[i] = Entry{ID: m.ID, Values: m.Values}
+	}
+	return entries
+}
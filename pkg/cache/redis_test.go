@@ -115,6 +115,9 @@ func TestRedisCache_Hash(t *testing.T) {
 	h, e := c.HGetAll(ctxt, key)
 	assert.Nil(t, e)
 	assert.True(t, h["k1"] == "v1" && h["k2"] == "v2")
+	n, e = c.HLen(ctxt, key)
+	assert.Nil(t, e)
+	assert.Equal(t, int64(2), n)
 	v, e := c.HGet(ctxt, key, "k1")
 	assert.Nil(t, e)
 	assert.True(t, v == "v1")
@@ -159,3 +162,37 @@ func TestRedisCache_String(t *testing.T) {
 	assert.Nil(t, e)
 	assert.True(t, v == "v1")
 }
+
+func TestScripterOf(t *testing.T) {
+	ctxt := context.Background()
+	assert.Nil(t, ScripterOf(NewNamespaced(newMemory(t), &Namespace{Service: "orders"})))
+	r, m := NewRedis(ctxt, &properties)
+	if !m.Fine() {
+		t.Skip("Redis not available")
+	}
+	assert.NotNil(t, ScripterOf(NewInstrumented(NewNamespaced(r, &Namespace{Service: "orders"}))))
+}
+
+func TestRedisCache_Counters(t *testing.T) {
+	c, m := NewRedis(context.Background(), &properties)
+	if !m.Fine() {
+		t.Skip("Redis not available")
+	}
+	testCounters(t, c, "knife:counters:")
+}
+
+func TestRedisCache_Moves(t *testing.T) {
+	c, m := NewRedis(context.Background(), &properties)
+	if !m.Fine() {
+		t.Skip("Redis not available")
+	}
+	testMoves(t, c, "knife:moves:")
+}
+
+func TestRedisCache_Sets(t *testing.T) {
+	c, m := NewRedis(context.Background(), &properties)
+	if !m.Fine() {
+		t.Skip("Redis not available")
+	}
+	testSets(t, c, "knife:sets:")
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Secret returns the password, reading it from PasswordFile when configured.
+// Trailing line breaks of the file are ignored.
+func (c *Credential) Secret() (string, error) {
+	if len(c.PasswordFile) == 0 {
+		return c.Password, nil
+	}
+	buf, err := os.ReadFile(c.PasswordFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// Config builds the tls configuration, nil is returned when TLS is disabled.
+func (t *TLS) Config() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify, // #nosec G402 - explicitly requested by configuration
+	}
+	if len(t.CAFile) > 0 {
+		buf, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("no certificate found in %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if len(t.CertFile) > 0 || len(t.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// mode returns the configured deployment mode, or guesses it from the
+// addresses for configurations written before Mode existed.
+func (p *Properties) mode() Mode {
+	if len(p.Mode) > 0 {
+		return p.Mode
+	}
+	if p.Addresses.Length() > 1 {
+		return Cluster
+	}
+	return Standalone
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/lists"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis.knife.local"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), 0o600))
+	return
+}
+
+func TestTLS_Config(t *testing.T) {
+	cfg, err := (&TLS{}).Config()
+	assert.Nil(t, err)
+	assert.Nil(t, cfg)
+
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir)
+	cfg, err = (&TLS{
+		Enabled:    true,
+		CAFile:     certFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "redis.knife.local",
+	}).Config()
+	assert.Nil(t, err)
+	assert.Equal(t, "redis.knife.local", cfg.ServerName)
+	assert.NotNil(t, cfg.RootCAs)
+	assert.Len(t, cfg.Certificates, 1)
+
+	_, err = (&TLS{Enabled: true, CAFile: keyFile}).Config()
+	assert.NotNil(t, err)
+	_, err = (&TLS{Enabled: true, CertFile: certFile}).Config()
+	assert.NotNil(t, err)
+}
+
+func TestCredential_Secret(t *testing.T) {
+	s, err := (&Credential{Password: "inline"}).Secret()
+	assert.Nil(t, err)
+	assert.Equal(t, "inline", s)
+
+	file := filepath.Join(t.TempDir(), "password")
+	assert.Nil(t, os.WriteFile(file, []byte("from-file\n"), 0o600))
+	s, err = (&Credential{Password: "inline", PasswordFile: file}).Secret()
+	assert.Nil(t, err)
+	assert.Equal(t, "from-file", s)
+
+	_, err = (&Credential{PasswordFile: file + ".missing"}).Secret()
+	assert.NotNil(t, err)
+}
+
+func TestProperties_Mode(t *testing.T) {
+	p := Properties{Addresses: *lists.Of[string]("127.0.0.1:6379")}
+	assert.Equal(t, Standalone, p.mode())
+	p.Addresses.Add("127.0.0.1:6380")
+	assert.Equal(t, Cluster, p.mode())
+	p.Mode = Sentinel
+	assert.Equal(t, Sentinel, p.mode())
+}
+
+func TestNewRedis_Invalid(t *testing.T) {
+	ctxt := context.Background()
+	_, m := NewRedis(ctxt, &Properties{Type: Redis})
+	assert.False(t, m.Fine())
+	_, m = NewRedis(ctxt, &Properties{Type: Redis, Mode: "ring", Addresses: *lists.Of[string]("127.0.0.1:6379")})
+	assert.False(t, m.Fine())
+	_, m = NewRedis(ctxt, &Properties{Type: Redis, Mode: Sentinel, Addresses: *lists.Of[string]("127.0.0.1:26379")})
+	assert.False(t, m.Fine())
+}
@@ -0,0 +1,330 @@
+package cache
+
+import (
+	"context"
+	stderrors "errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gantries/knife/pkg/tel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// InstrumentedCache traces every operation of the wrapped cache and records
+// its duration in milliseconds to knife.cache.duration, failures are counted
+// by knife.cache.error. Both carry the command as attribute, missing keys are
+// not failures.
+type InstrumentedCache struct {
+	cache    Cache
+	duration tel.SimpleHistogram
+	errors   tel.SimpleCounter
+}
+
+func NewInstrumented(c Cache) *InstrumentedCache {
+	return &InstrumentedCache{
+		cache:    c,
+		duration: tel.Histogram("knife.cache.duration"),
+		errors:   tel.Counter("knife.cache.error"),
+	}
+}
+
+// Unwrap returns the wrapped cache.
+func (i *InstrumentedCache) Unwrap() Cache {
+	return i.cache
+}
+
+// observePool exports the connection pool statistics of r as gauges, once per
+// client until it is closed.
+func observePool(r *RedisCache) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.pool != nil {
+		return
+	}
+	stats := map[string]func(s *PoolStats) uint32{
+		"knife.cache.pool.total":    func(s *PoolStats) uint32 { return s.TotalConns },
+		"knife.cache.pool.idle":     func(s *PoolStats) uint32 { return s.IdleConns },
+		"knife.cache.pool.stale":    func(s *PoolStats) uint32 { return s.StaleConns },
+		"knife.cache.pool.hits":     func(s *PoolStats) uint32 { return s.Hits },
+		"knife.cache.pool.misses":   func(s *PoolStats) uint32 { return s.Misses },
+		"knife.cache.pool.timeouts": func(s *PoolStats) uint32 { return s.Timeouts },
+	}
+	r.pool = make([]metric.Registration, 0, len(stats))
+	for name, stat := range stats {
+		g, err := tel.Gauge(name).Observe(func(ctx context.Context) int64 {
+			return int64(stat(r.PoolStats()))
+		})
+		if err != nil {
+			logger.Error("Unable to observe cache pool", "error", err, "gauge", name)
+			continue
+		}
+		r.pool = append(r.pool, g)
+	}
+}
+
+// pattern masks the key segments holding identifiers so that spans of the same
+// kind of key look alike, "user:42:orders" becomes "user:*:orders".
+func pattern(key string) string {
+	var b strings.Builder
+	start := 0
+	for i := 0; i <= len(key); i++ {
+		if i < len(key) && key[i] != ':' && key[i] != '/' {
+			continue
+		}
+		if segment := key[start:i]; strings.IndexFunc(segment, unicode.IsDigit) >= 0 {
+			b.WriteByte('*')
+		} else {
+			b.WriteString(segment)
+		}
+		if i < len(key) {
+			b.WriteByte(key[i])
+		}
+		start = i + 1
+	}
+	return b.String()
+}
+
+// observe starts the span of command, the returned function ends it and
+// records the outcome.
+func (i *InstrumentedCache) observe(ctx *context.Context, command string, keys ...string) func(err *error) {
+	start := time.Now()
+	span := tel.Span(ctx, "cache."+command)
+	attrs := []attribute.KeyValue{attribute.String("cache.command", command)}
+	span.SetAttributes(attrs...)
+	if len(keys) > 0 {
+		span.SetAttributes(attribute.String("cache.key", pattern(keys[0])), attribute.Int("cache.keys", len(keys)))
+	}
+	return func(err *error) {
+		failure := *err
+		if stderrors.Is(failure, Nil) {
+			failure = nil
+		}
+		i.duration.Record(*ctx, float64(time.Since(start))/float64(time.Millisecond), metric.WithAttributes(attrs...))
+		if failure != nil {
+			i.errors.Add(*ctx, 1, metric.WithAttributes(attrs...))
+			span.RecordError(failure)
+		}
+		tel.Do(span, &failure)
+	}
+}
+
+func (i *InstrumentedCache) Ping(ctxt context.Context) (err error) {
+	defer i.observe(&ctxt, "ping")(&err)
+	return i.cache.Ping(ctxt)
+}
+
+func (i *InstrumentedCache) Push(ctx context.Context, key string, values ...interface{}) (err error) {
+	defer i.observe(&ctx, "push", key)(&err)
+	return i.cache.Push(ctx, key, values...)
+}
+
+func (i *InstrumentedCache) Pop(ctx context.Context, key string) (s string, err error) {
+	defer i.observe(&ctx, "pop", key)(&err)
+	return i.cache.Pop(ctx, key)
+}
+
+func (i *InstrumentedCache) Count(ctx context.Context, key string) (n int64, err error) {
+	defer i.observe(&ctx, "count", key)(&err)
+	return i.cache.Count(ctx, key)
+}
+
+func (i *InstrumentedCache) Del(ctxt context.Context, keys ...string) (n int64, err error) {
+	defer i.observe(&ctxt, "del", keys...)(&err)
+	return i.cache.Del(ctxt, keys...)
+}
+
+func (i *InstrumentedCache) Exists(ctx context.Context, keys ...string) (n int64, err error) {
+	defer i.observe(&ctx, "exists", keys...)(&err)
+	return i.cache.Exists(ctx, keys...)
+}
+
+func (i *InstrumentedCache) Get(ctxt context.Context, key string) (s string, err error) {
+	defer i.observe(&ctxt, "get", key)(&err)
+	return i.cache.Get(ctxt, key)
+}
+
+func (i *InstrumentedCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) (s string, err error) {
+	defer i.observe(&ctx, "set", key)(&err)
+	return i.cache.Set(ctx, key, value, expiration)
+}
+
+func (i *InstrumentedCache) HDel(ctx context.Context, key string, fields ...string) (n int64, err error) {
+	defer i.observe(&ctx, "hdel", key)(&err)
+	return i.cache.HDel(ctx, key, fields...)
+}
+
+func (i *InstrumentedCache) HGet(ctx context.Context, key, field string) (s string, err error) {
+	defer i.observe(&ctx, "hget", key)(&err)
+	return i.cache.HGet(ctx, key, field)
+}
+
+func (i *InstrumentedCache) HGetAll(ctx context.Context, key string) (h map[string]string, err error) {
+	defer i.observe(&ctx, "hgetall", key)(&err)
+	return i.cache.HGetAll(ctx, key)
+}
+
+func (i *InstrumentedCache) HLen(ctx context.Context, key string) (n int64, err error) {
+	defer i.observe(&ctx, "hlen", key)(&err)
+	return i.cache.HLen(ctx, key)
+}
+
+func (i *InstrumentedCache) HSet(ctx context.Context, key string, values ...interface{}) (n int64, err error) {
+	defer i.observe(&ctx, "hset", key)(&err)
+	return i.cache.HSet(ctx, key, values...)
+}
+
+func (i *InstrumentedCache) LPop(ctx context.Context, key string) (s string, err error) {
+	defer i.observe(&ctx, "lpop", key)(&err)
+	return i.cache.LPop(ctx, key)
+}
+
+func (i *InstrumentedCache) RPush(ctx context.Context, key string, fields ...string) (n int64, err error) {
+	defer i.observe(&ctx, "rpush", key)(&err)
+	return i.cache.RPush(ctx, key, fields...)
+}
+
+func (i *InstrumentedCache) LMove(ctx context.Context, source, destination, srcpos, destpos string) (s string, err error) {
+	defer i.observe(&ctx, "lmove", source, destination)(&err)
+	return i.cache.LMove(ctx, source, destination, srcpos, destpos)
+}
+
+func (i *InstrumentedCache) LRem(ctx context.Context, key string, count int64, value interface{}) (n int64, err error) {
+	defer i.observe(&ctx, "lrem", key)(&err)
+	return i.cache.LRem(ctx, key, count, value)
+}
+
+func (i *InstrumentedCache) LRange(ctx context.Context, key string, start, stop int64) (l []string, err error) {
+	defer i.observe(&ctx, "lrange", key)(&err)
+	return i.cache.LRange(ctx, key, start, stop)
+}
+
+func (i *InstrumentedCache) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (b bool, err error) {
+	defer i.observe(&ctx, "setnx", key)(&err)
+	return i.cache.SetNX(ctx, key, value, expiration)
+}
+
+func (i *InstrumentedCache) Incr(ctx context.Context, key string) (n int64, err error) {
+	defer i.observe(&ctx, "incr", key)(&err)
+	return i.cache.Incr(ctx, key)
+}
+
+func (i *InstrumentedCache) IncrBy(ctx context.Context, key string, value int64) (n int64, err error) {
+	defer i.observe(&ctx, "incrby", key)(&err)
+	return i.cache.IncrBy(ctx, key, value)
+}
+
+func (i *InstrumentedCache) Expire(ctx context.Context, key string, expiration time.Duration) (b bool, err error) {
+	defer i.observe(&ctx, "expire", key)(&err)
+	return i.cache.Expire(ctx, key, expiration)
+}
+
+func (i *InstrumentedCache) TTL(ctx context.Context, key string) (d time.Duration, err error) {
+	defer i.observe(&ctx, "ttl", key)(&err)
+	return i.cache.TTL(ctx, key)
+}
+
+func (i *InstrumentedCache) Persist(ctx context.Context, key string) (b bool, err error) {
+	defer i.observe(&ctx, "persist", key)(&err)
+	return i.cache.Persist(ctx, key)
+}
+
+func (i *InstrumentedCache) SAdd(ctx context.Context, key string, members ...interface{}) (n int64, err error) {
+	defer i.observe(&ctx, "sadd", key)(&err)
+	return i.cache.SAdd(ctx, key, members...)
+}
+
+func (i *InstrumentedCache) SRem(ctx context.Context, key string, members ...interface{}) (n int64, err error) {
+	defer i.observe(&ctx, "srem", key)(&err)
+	return i.cache.SRem(ctx, key, members...)
+}
+
+func (i *InstrumentedCache) SIsMember(ctx context.Context, key string, member interface{}) (b bool, err error) {
+	defer i.observe(&ctx, "sismember", key)(&err)
+	return i.cache.SIsMember(ctx, key, member)
+}
+
+func (i *InstrumentedCache) SMembers(ctx context.Context, key string) (a []string, err error) {
+	defer i.observe(&ctx, "smembers", key)(&err)
+	return i.cache.SMembers(ctx, key)
+}
+
+func (i *InstrumentedCache) SCard(ctx context.Context, key string) (n int64, err error) {
+	defer i.observe(&ctx, "scard", key)(&err)
+	return i.cache.SCard(ctx, key)
+}
+
+func (i *InstrumentedCache) ZAdd(ctx context.Context, key string, members ...Z) (n int64, err error) {
+	defer i.observe(&ctx, "zadd", key)(&err)
+	return i.cache.ZAdd(ctx, key, members...)
+}
+
+func (i *InstrumentedCache) ZIncrBy(ctx context.Context, key string, increment float64, member string) (f float64, err error) {
+	defer i.observe(&ctx, "zincrby", key)(&err)
+	return i.cache.ZIncrBy(ctx, key, increment, member)
+}
+
+func (i *InstrumentedCache) ZRem(ctx context.Context, key string, members ...interface{}) (n int64, err error) {
+	defer i.observe(&ctx, "zrem", key)(&err)
+	return i.cache.ZRem(ctx, key, members...)
+}
+
+func (i *InstrumentedCache) ZScore(ctx context.Context, key, member string) (f float64, err error) {
+	defer i.observe(&ctx, "zscore", key)(&err)
+	return i.cache.ZScore(ctx, key, member)
+}
+
+func (i *InstrumentedCache) ZRank(ctx context.Context, key, member string) (n int64, err error) {
+	defer i.observe(&ctx, "zrank", key)(&err)
+	return i.cache.ZRank(ctx, key, member)
+}
+
+func (i *InstrumentedCache) ZRevRank(ctx context.Context, key, member string) (n int64, err error) {
+	defer i.observe(&ctx, "zrevrank", key)(&err)
+	return i.cache.ZRevRank(ctx, key, member)
+}
+
+func (i *InstrumentedCache) ZCard(ctx context.Context, key string) (n int64, err error) {
+	defer i.observe(&ctx, "zcard", key)(&err)
+	return i.cache.ZCard(ctx, key)
+}
+
+func (i *InstrumentedCache) ZRange(ctx context.Context, key string, start, stop int64) (a []Z, err error) {
+	defer i.observe(&ctx, "zrange", key)(&err)
+	return i.cache.ZRange(ctx, key, start, stop)
+}
+
+func (i *InstrumentedCache) ZRevRange(ctx context.Context, key string, start, stop int64) (a []Z, err error) {
+	defer i.observe(&ctx, "zrevrange", key)(&err)
+	return i.cache.ZRevRange(ctx, key, start, stop)
+}
+
+func (i *InstrumentedCache) ZRangeByScore(ctx context.Context, key string, opt *ZRangeBy) (a []Z, err error) {
+	defer i.observe(&ctx, "zrangebyscore", key)(&err)
+	return i.cache.ZRangeByScore(ctx, key, opt)
+}
+
+func (i *InstrumentedCache) MGet(ctx context.Context, keys ...string) (m map[string]string, err error) {
+	defer i.observe(&ctx, "mget", keys...)(&err)
+	return i.cache.MGet(ctx, keys...)
+}
+
+func (i *InstrumentedCache) MSet(ctx context.Context, values map[string]interface{}, expiration time.Duration) (err error) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	defer i.observe(&ctx, "mset", keys...)(&err)
+	return i.cache.MSet(ctx, values, expiration)
+}
+
+func (i *InstrumentedCache) MHGetAll(ctx context.Context, keys ...string) (m map[string]map[string]string, err error) {
+	defer i.observe(&ctx, "mhgetall", keys...)(&err)
+	return i.cache.MHGetAll(ctx, keys...)
+}
+
+func (i *InstrumentedCache) Pipeline(ctx context.Context, fn func(p Pipeliner) error) (err error) {
+	defer i.observe(&ctx, "pipeline")(&err)
+	return i.cache.Pipeline(ctx, fn)
+}
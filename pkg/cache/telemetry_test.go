@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gantries/knife/pkg/tel"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestPattern(t *testing.T) {
+	assert.Equal(t, "user:*:orders", pattern("user:42:orders"))
+	assert.Equal(t, "knife/lock/*", pattern("knife/lock/a1b2"))
+	assert.Equal(t, "session", pattern("session"))
+	assert.Equal(t, "a::*", pattern("a::1"))
+}
+
+func TestInstrumentedCache(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	spans := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer("test")
+	tel.SetupTracer(&tracer)
+	defer tel.SetupTracer(nil)
+
+	ctxt := context.Background()
+	c, m := New(ctxt, &Properties{Type: Memory, Telemetry: true})
+	assert.True(t, m.Fine())
+	_, ok := c.(*InstrumentedCache)
+	assert.True(t, ok)
+
+	_, e := c.Set(ctxt, "user:42", "v1", 0)
+	assert.Nil(t, e)
+	_, e = c.Get(ctxt, "user:43")
+	assert.ErrorIs(t, e, Nil)
+	_, e = c.HGet(ctxt, "user:42", "f1")
+	assert.NotNil(t, e)
+
+	ended := spans.Ended()
+	assert.Len(t, ended, 3)
+	assert.Equal(t, "cache.set", ended[0].Name())
+	assert.Contains(t, ended[0].Attributes(), attribute.String("cache.key", "user:*"))
+	assert.Equal(t, codes.Ok, ended[1].Status().Code, "missing keys are no failures")
+	assert.Equal(t, codes.Error, ended[2].Status().Code)
+
+	var rm metricdata.ResourceMetrics
+	assert.Nil(t, reader.Collect(ctxt, &rm))
+	metrics := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	duration := metrics["knife.cache.duration"].(metricdata.Histogram[float64])
+	assert.Len(t, duration.DataPoints, 3)
+	failures := metrics["knife.cache.error"].(metricdata.Sum[int64])
+	assert.Len(t, failures.DataPoints, 1)
+	command, _ := failures.DataPoints[0].Attributes.Value("cache.command")
+	assert.Equal(t, "hget", command.AsString())
+	assert.Equal(t, int64(1), failures.DataPoints[0].Value)
+}
+
+func TestInstrumentedCache_Sets(t *testing.T) {
+	c := NewInstrumented(newMemory(t))
+	testCounters(t, c, "")
+	testSets(t, c, "")
+}
+
+func TestObservePool(t *testing.T) {
+	r := &RedisCache{redis: redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})}
+	observePool(r)
+	pool := r.pool
+	assert.Len(t, pool, 6)
+	observePool(r)
+	assert.Equal(t, pool, r.pool, "once per client")
+	assert.Nil(t, Close(NewInstrumented(r)))
+	assert.Nil(t, r.pool)
+}
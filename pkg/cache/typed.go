@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gantries/knife/pkg/serde"
+	"golang.org/x/sync/singleflight"
+)
+
+// negative marks a key whose loader reported that no value exists. It can't
+// be produced by serializing a value as json.
+const negative = "\x00knife/cache/nil"
+
+// Typed stores values of T as json in any Cache.
+//
+// GetOrLoad collapses concurrent misses of the same key, so the loader runs
+// once per process, and remembers missing values for the negative duration.
+type Typed[T any] struct {
+	cache    Cache
+	negative time.Duration
+	group    singleflight.Group
+}
+
+// NewTyped wraps c, missing values are cached for negative, a non-positive
+// duration disables negative caching.
+func NewTyped[T any](c Cache, negative time.Duration) *Typed[T] {
+	return &Typed[T]{cache: c, negative: negative}
+}
+
+// Get returns Nil when key is missing or known to have no value.
+func (t *Typed[T]) Get(ctx context.Context, key string) (*T, error) {
+	v, found, err := t.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !found || v == nil {
+		return nil, Nil
+	}
+	return v, nil
+}
+
+// get reports found for negative entries as well, v is nil for them.
+func (t *Typed[T]) get(ctx context.Context, key string) (v *T, found bool, err error) {
+	s, err := t.cache.Get(ctx, key)
+	if errors.Is(err, Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if s == negative {
+		return nil, true, nil
+	}
+	v, err = serde.Deserialize[T]([]byte(s))
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+func (t *Typed[T]) Set(ctx context.Context, key string, value T, ttl time.Duration) error {
+	buf, err := serde.Serialize(value)
+	if err != nil {
+		return err
+	}
+	_, err = t.cache.Set(ctx, key, buf, ttl)
+	return err
+}
+
+func (t *Typed[T]) Delete(ctx context.Context, keys ...string) error {
+	_, err := t.cache.Del(ctx, keys...)
+	return err
+}
+
+// GetOrLoad returns the cached value of key, or calls loader and caches its
+// result for ttl. A nil value from loader means the value does not exist, it
+// is returned as (nil, nil) and remembered for the negative duration.
+//
+// The loader runs without the cancellation of ctx because its result is
+// shared with other callers, each caller still stops waiting when its own
+// ctx is done.
+func (t *Typed[T]) GetOrLoad(ctx context.Context, key string, ttl time.Duration,
+	loader func(ctx context.Context) (*T, error)) (*T, error) {
+	if v, found, err := t.get(ctx, key); err != nil || found {
+		return v, err
+	}
+	ch := t.group.DoChan(key, func() (interface{}, error) {
+		ctxt := context.WithoutCancel(ctx)
+		// another caller may have filled the key while this one was waiting
+		if v, found, err := t.get(ctxt, key); err != nil || found {
+			return v, err
+		}
+		v, err := loader(ctxt)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			if err := t.Set(ctxt, key, *v, ttl); err != nil {
+				logger.Error("Unable to cache loaded value", "error", err, "key", key)
+			}
+		} else if t.negative > 0 {
+			if _, err := t.cache.Set(ctxt, key, negative, t.negative); err != nil {
+				logger.Error("Unable to cache missing value", "error", err, "key", key)
+			}
+		}
+		return v, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.(*T), nil
+	}
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestTyped_GetSetDelete(t *testing.T) {
+	ctxt := context.Background()
+	c := newMemory(t)
+	users := NewTyped[user](c, time.Minute)
+
+	_, e := users.Get(ctxt, "user:1")
+	assert.ErrorIs(t, e, Nil)
+	assert.Nil(t, users.Set(ctxt, "user:1", user{Name: "john", Age: 30}, time.Minute))
+	u, e := users.Get(ctxt, "user:1")
+	assert.Nil(t, e)
+	assert.Equal(t, &user{Name: "john", Age: 30}, u)
+	raw, e := c.Get(ctxt, "user:1")
+	assert.Nil(t, e)
+	assert.Equal(t, `{"name":"john","age":30}`, raw)
+
+	assert.Nil(t, users.Delete(ctxt, "user:1"))
+	_, e = users.Get(ctxt, "user:1")
+	assert.ErrorIs(t, e, Nil)
+
+	_, e = c.Set(ctxt, "user:2", "not json", 0)
+	assert.Nil(t, e)
+	_, e = users.Get(ctxt, "user:2")
+	assert.NotNil(t, e)
+}
+
+func TestTyped_GetOrLoad(t *testing.T) {
+	ctxt := context.Background()
+	users := NewTyped[user](newMemory(t), time.Minute)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (*user, error) {
+		calls.Add(1)
+		<-release
+		return &user{Name: "john"}, nil
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, e := users.GetOrLoad(ctxt, "user:1", time.Minute, loader)
+			assert.Nil(t, e)
+			assert.Equal(t, "john", u.Name)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+
+	u, e := users.GetOrLoad(ctxt, "user:1", time.Minute, loader)
+	assert.Nil(t, e)
+	assert.Equal(t, "john", u.Name)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestTyped_GetOrLoad_Negative(t *testing.T) {
+	ctxt := context.Background()
+	users := NewTyped[user](newMemory(t), time.Minute)
+
+	calls := 0
+	missing := func(ctx context.Context) (*user, error) {
+		calls++
+		return nil, nil
+	}
+	for i := 0; i < 3; i++ {
+		u, e := users.GetOrLoad(ctxt, "user:404", time.Minute, missing)
+		assert.Nil(t, e)
+		assert.Nil(t, u)
+	}
+	assert.Equal(t, 1, calls)
+	_, e := users.Get(ctxt, "user:404")
+	assert.ErrorIs(t, e, Nil)
+
+	failure := fmt.Errorf("database is down")
+	_, e = users.GetOrLoad(ctxt, "user:500", time.Minute, func(ctx context.Context) (*user, error) {
+		return nil, failure
+	})
+	assert.ErrorIs(t, e, failure)
+	_, e = users.Get(ctxt, "user:500")
+	assert.ErrorIs(t, e, Nil, "errors are not cached")
+}
+
+func TestTyped_GetOrLoad_Cancel(t *testing.T) {
+	users := NewTyped[user](newMemory(t), 0)
+	ctxt, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, e := users.GetOrLoad(ctxt, "user:1", time.Minute, func(ctx context.Context) (*user, error) {
+			<-release
+			assert.Nil(t, ctx.Err())
+			return &user{Name: "john"}, nil
+		})
+		assert.ErrorIs(t, e, context.Canceled)
+	}()
+	cancel()
+	<-done
+	close(release)
+
+	u, e := users.GetOrLoad(context.Background(), "user:1", time.Minute, func(ctx context.Context) (*user, error) {
+		return &user{Name: "jane"}, nil
+	})
+	assert.Nil(t, e)
+	assert.NotNil(t, u)
+}
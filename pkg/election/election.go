@@ -0,0 +1,289 @@
+// Package election elects a leader among the replicas campaigning for a name.
+//
+// The leader holds a lease on a synch.Lock and renews it in the background,
+// another candidate is elected once the leader stopped renewing and its lease
+// expired. Leaders failing to renew step down before their lease may expire,
+// so that two candidates do not lead at the same time.
+package election
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/lang"
+	"github.com/gantries/knife/pkg/log"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/gantries/knife/pkg/synch"
+)
+
+var logger = log.New("knife/election")
+
+type Properties struct {
+	// Name is the source of the lock held by the leader.
+	Name string `json:"name" yaml:"name"`
+	// Lease is how long a dead leader keeps leading.
+	Lease time.Duration `json:"lease" yaml:"lease" default:"15s"`
+	// Interval is how often the leader renews its lease and the other
+	// candidates campaign, a third of the lease when not shorter than it.
+	Interval time.Duration `json:"interval" yaml:"interval" default:"5s"`
+}
+
+func (p *Properties) lease() time.Duration {
+	if p.Lease > 0 {
+		return p.Lease
+	}
+	return 15 * time.Second
+}
+
+func (p *Properties) interval() time.Duration {
+	if p.Interval > 0 && p.Interval < p.lease() {
+		return p.Interval
+	}
+	return p.lease() / 3
+}
+
+// Candidate campaigns for the name of its properties on behalf of a replica.
+type Candidate struct {
+	lock    synch.Lock
+	props   Properties
+	id      string
+	mutex   sync.Mutex
+	term    *term
+	stale   time.Time
+	elected []func(ctx context.Context)
+	revoked []func()
+}
+
+// term is the leadership of a candidate, its context is cancelled once it is
+// revoked.
+type term struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New returns a candidate identified by id on lock, a random id is used when
+// empty. The lock must be a synch.Renewer, locking again to extend a lease
+// fails with locks which are not reentrant.
+func New(lock synch.Lock, props *Properties, id string) (*Candidate, *national.Message) {
+	if lock == nil {
+		return nil, errors.MissingValueError.Build("value", "lock")
+	}
+	if _, ok := lock.(synch.Renewer); !ok {
+		return nil, errors.UnsupportedValueError.Build("type", "election-lock", "value", fmt.Sprintf("%T", lock))
+	}
+	if len(props.Name) == 0 {
+		return nil, errors.MissingValueError.Build("value", "name")
+	}
+	if len(id) == 0 {
+		id = lang.StringUUID()
+	}
+	return &Candidate{lock: lock, props: *props, id: id}, errors.Yes()
+}
+
+func (c *Candidate) ID() string {
+	return c.id
+}
+
+// OnElected calls fn every time the candidate is elected, with a context
+// cancelled once the leadership is revoked. Callbacks run before Campaign
+// returns and should start long running work in goroutines.
+func (c *Candidate) OnElected(fn func(ctx context.Context)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.elected = append(c.elected, fn)
+}
+
+// OnRevoked calls fn every time the leadership is lost or resigned, before
+// the lock is released by Resign.
+func (c *Candidate) OnRevoked(fn func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.revoked = append(c.revoked, fn)
+}
+
+func (c *Candidate) Leading() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.term != nil
+}
+
+// Leader returns the id of the current leader. It is empty when nobody leads,
+// or when the lock is no synch.Inspector and this candidate does not lead.
+func (c *Candidate) Leader(ctx context.Context) (string, error) {
+	if i, ok := c.lock.(synch.Inspector); ok {
+		return i.Owner(ctx, c.props.Name)
+	}
+	if c.Leading() {
+		return c.id, nil
+	}
+	return "", nil
+}
+
+// Campaign blocks until the candidate is elected or ctx is done, trying every
+// interval. The lease is renewed in the background until the candidate
+// resigns or fails to renew it.
+func (c *Candidate) Campaign(ctx context.Context) error {
+	ticker := time.NewTicker(c.props.interval())
+	defer ticker.Stop()
+	for {
+		if c.Leading() {
+			return nil
+		}
+		if c.released() {
+			ok, err := c.lock.Lock(ctx, c.props.Name, c.id, c.props.lease())
+			if err != nil {
+				logger.Warn("Unable to campaign", "error", err, "name", c.props.Name, "id", c.id)
+			}
+			if ok {
+				c.elect(ctx)
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Candidate) elect(ctx context.Context) {
+	ctxt, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	t := &term{ctx: ctxt, cancel: cancel}
+	c.mutex.Lock()
+	c.term = t
+	elected := slices.Clone(c.elected)
+	c.mutex.Unlock()
+	logger.Info("Elected leader", "name", c.props.Name, "id", c.id)
+	go c.renew(t)
+	for _, fn := range elected {
+		fn(t.ctx)
+	}
+}
+
+// renew extends the lease of t every interval until it is revoked.
+func (c *Candidate) renew(t *term) {
+	interval, lease := c.props.interval(), c.props.lease()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ok, err := c.extend(t.ctx)
+		if t.ctx.Err() != nil {
+			return
+		}
+		if ok && err == nil {
+			renewed = time.Now()
+			continue
+		}
+		if err != nil {
+			logger.Warn("Unable to renew leadership", "error", err, "name", c.props.Name, "id", c.id)
+			// Step down when the lease may expire before the next attempt
+			if time.Since(renewed)+interval < lease {
+				continue
+			}
+		}
+		logger.Error("Leadership lost", "name", c.props.Name, "id", c.id)
+		if c.revoke(t, time.Now().Add(lease)) {
+			c.release()
+		}
+		return
+	}
+}
+
+// release gives up the lease after the candidate stepped down, so that
+// another candidate is elected without waiting for it to expire. A lease
+// which cannot be unlocked is left to expire before campaigning again, since
+// locking a reentrant lock again would hold it twice.
+func (c *Candidate) release() {
+	if a, ok := c.lock.(synch.Abandoner); ok {
+		a.Abandon(c.props.Name, c.id)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.props.interval())
+	defer cancel()
+	if _, err := c.lock.Unlock(ctx, c.props.Name, c.id); err != nil {
+		logger.Warn("Unable to release leadership", "error", err, "name", c.props.Name, "id", c.id)
+		return
+	}
+	c.mutex.Lock()
+	c.stale = time.Time{}
+	c.mutex.Unlock()
+}
+
+// released returns whether the lease of the last term was released or
+// expired.
+func (c *Candidate) released() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return !time.Now().Before(c.stale)
+}
+
+// extend renews the lease, it returns false when the candidate lost it.
+func (c *Candidate) extend(ctx context.Context) (bool, error) {
+	return c.lock.(synch.Renewer).Renew(ctx, c.props.Name, c.id, c.props.lease())
+}
+
+// revoke ends t unless it ended already, it returns whether it did. The
+// candidate does not campaign again before stale, unless the lease is
+// released.
+func (c *Candidate) revoke(t *term, stale time.Time) bool {
+	c.mutex.Lock()
+	if c.term != t {
+		c.mutex.Unlock()
+		return false
+	}
+	c.term = nil
+	c.stale = stale
+	revoked := slices.Clone(c.revoked)
+	c.mutex.Unlock()
+	t.cancel()
+	for _, fn := range revoked {
+		fn()
+	}
+	return true
+}
+
+// Resign revokes the leadership and releases the lock, it does nothing when
+// the candidate does not lead.
+func (c *Candidate) Resign(ctx context.Context) error {
+	c.mutex.Lock()
+	t := c.term
+	c.mutex.Unlock()
+	if t == nil || !c.revoke(t, time.Time{}) {
+		return nil
+	}
+	logger.Info("Resigned leadership", "name", c.props.Name, "id", c.id)
+	_, err := c.lock.Unlock(ctx, c.props.Name, c.id)
+	return err
+}
+
+// Run campaigns until ctx is done, again whenever the leadership is lost, and
+// resigns then.
+func (c *Candidate) Run(ctx context.Context) {
+	for c.Campaign(ctx) == nil {
+		c.mutex.Lock()
+		t := c.term
+		c.mutex.Unlock()
+		if t != nil {
+			select {
+			case <-ctx.Done():
+			case <-t.ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if err := c.Resign(context.WithoutCancel(ctx)); err != nil {
+		logger.Error("Unable to resign leadership", "error", err, "name", c.props.Name, "id", c.id)
+	}
+}
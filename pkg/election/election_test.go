@@ -0,0 +1,304 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/synch"
+	"github.com/stretchr/testify/assert"
+)
+
+var properties = Properties{Name: "ut", Lease: 300 * time.Millisecond, Interval: 50 * time.Millisecond}
+
+// events passes the callbacks of candidates in their order.
+type events struct {
+	next chan string
+}
+
+func newEvents() *events {
+	return &events{next: make(chan string, 16)}
+}
+
+func (e *events) watch(c *Candidate) {
+	c.OnElected(func(ctx context.Context) {
+		e.add(c.ID() + " elected")
+	})
+	c.OnRevoked(func() {
+		e.add(c.ID() + " revoked")
+	})
+}
+
+func (e *events) add(event string) {
+	e.next <- event
+}
+
+func (e *events) wait(t *testing.T, event string) {
+	select {
+	case got := <-e.next:
+		assert.Equal(t, event, got)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", event)
+	}
+}
+
+// flaky fails to extend the leases of down owners.
+type flaky struct {
+	*synch.MemoryLock
+	down sync.Map
+}
+
+func (f *flaky) Renew(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
+	if _, ok := f.down.Load(owner); ok {
+		return false, fmt.Errorf("unavailable")
+	}
+	return f.MemoryLock.Renew(ctx, source, owner, timeout)
+}
+
+// watched renews the leases it holds in the background like a synch.RedisLock
+// until they are unlocked or abandoned, it fails to unlock stuck owners.
+type watched struct {
+	*flaky
+	stuck    sync.Map
+	mutex    sync.Mutex
+	watchdog map[string]context.CancelFunc
+}
+
+func newWatched() *watched {
+	return &watched{flaky: &flaky{MemoryLock: synch.NewMemoryLock()}, watchdog: map[string]context.CancelFunc{}}
+}
+
+func (w *watched) Lock(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
+	ok, err := w.MemoryLock.Lock(ctx, source, owner, timeout)
+	if !ok {
+		return ok, err
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, ok := w.watchdog[owner]; !ok {
+		ctxt, cancel := context.WithCancel(context.Background())
+		w.watchdog[owner] = cancel
+		go func() {
+			ticker := time.NewTicker(timeout / 3)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctxt.Done():
+					return
+				case <-ticker.C:
+					_, _ = w.MemoryLock.Renew(ctxt, source, owner, timeout)
+				}
+			}
+		}()
+	}
+	return true, nil
+}
+
+func (w *watched) Unlock(ctx context.Context, source, owner string) (bool, error) {
+	if _, ok := w.stuck.Load(owner); ok {
+		return false, fmt.Errorf("unavailable")
+	}
+	ok, err := w.MemoryLock.Unlock(ctx, source, owner)
+	if leader, _ := w.Owner(ctx, source); leader != owner {
+		w.Abandon(source, owner)
+	}
+	return ok, err
+}
+
+func (w *watched) Abandon(source, owner string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if cancel, ok := w.watchdog[owner]; ok {
+		delete(w.watchdog, owner)
+		cancel()
+	}
+}
+
+// plain only locks and unlocks.
+type plain struct {
+	lock synch.Lock
+}
+
+func (p plain) Lock(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
+	return p.lock.Lock(ctx, source, owner, timeout)
+}
+
+func (p plain) Unlock(ctx context.Context, source, owner string) (bool, error) {
+	return p.lock.Unlock(ctx, source, owner)
+}
+
+func TestNew(t *testing.T) {
+	_, m := New(nil, &properties, "a")
+	assert.False(t, m.Fine())
+	_, m = New(synch.NewMemoryLock(), &Properties{}, "a")
+	assert.False(t, m.Fine())
+	_, m = New(plain{synch.NewMemoryLock()}, &properties, "a")
+	assert.False(t, m.Fine(), "not a synch.Renewer")
+	c, m := New(synch.NewMemoryLock(), &properties, "")
+	assert.True(t, m.Fine())
+	assert.NotEmpty(t, c.ID())
+}
+
+func TestCandidate(t *testing.T) {
+	ctxt := context.Background()
+	lock := synch.NewMemoryLock()
+	a, _ := New(lock, &properties, "a")
+	b, _ := New(lock, &properties, "b")
+	var term context.Context
+	a.OnElected(func(ctx context.Context) {
+		term = ctx
+	})
+	revoked := false
+	a.OnRevoked(func() {
+		revoked = true
+	})
+
+	assert.Nil(t, a.Campaign(ctxt))
+	assert.True(t, a.Leading())
+	assert.NotNil(t, term)
+	assert.Nil(t, a.Campaign(ctxt), "leading already")
+	leader, err := b.Leader(ctxt)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", leader)
+
+	timeout, cancel := context.WithTimeout(ctxt, 4*properties.Lease)
+	defer cancel()
+	assert.ErrorIs(t, b.Campaign(timeout), context.DeadlineExceeded, "renewed past its lease")
+	assert.False(t, b.Leading())
+
+	assert.Nil(t, a.Resign(ctxt))
+	assert.True(t, revoked)
+	assert.ErrorIs(t, term.Err(), context.Canceled)
+	assert.False(t, a.Leading())
+	assert.Nil(t, a.Resign(ctxt), "resigning twice is harmless")
+	assert.Nil(t, b.Campaign(ctxt))
+	leader, _ = a.Leader(ctxt)
+	assert.Equal(t, "b", leader)
+	assert.Nil(t, b.Resign(ctxt))
+	leader, _ = a.Leader(ctxt)
+	assert.Empty(t, leader)
+}
+
+func TestCandidate_Failover(t *testing.T) {
+	ctxt := context.Background()
+	lock := &flaky{MemoryLock: synch.NewMemoryLock()}
+	seen := newEvents()
+	a, _ := New(lock, &properties, "a")
+	b, _ := New(lock, &properties, "b")
+	seen.watch(a)
+	seen.watch(b)
+
+	assert.Nil(t, a.Campaign(ctxt))
+	seen.wait(t, "a elected")
+	ctx, cancel := context.WithCancel(ctxt)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Run(ctx)
+	}()
+
+	lock.down.Store("a", true)
+	seen.wait(t, "a revoked")
+	seen.wait(t, "b elected")
+	assert.False(t, a.Leading())
+
+	cancel()
+	<-done
+	seen.wait(t, "b revoked")
+	leader, _ := a.Leader(ctxt)
+	assert.Empty(t, leader, "resigned once done")
+}
+
+func TestCandidate_StepDown(t *testing.T) {
+	ctxt := context.Background()
+	lock := newWatched()
+	seen := newEvents()
+	a, _ := New(lock, &properties, "a")
+	b, _ := New(lock, &properties, "b")
+	seen.watch(a)
+	seen.watch(b)
+
+	assert.Nil(t, a.Campaign(ctxt))
+	seen.wait(t, "a elected")
+	ctx, cancel := context.WithCancel(ctxt)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Run(ctx)
+	}()
+
+	// the lease is released although the watchdog would keep it forever
+	lock.down.Store("a", true)
+	seen.wait(t, "a revoked")
+	seen.wait(t, "b elected")
+
+	cancel()
+	<-done
+	seen.wait(t, "b revoked")
+	leader, _ := a.Leader(ctxt)
+	assert.Empty(t, leader, "resigned once done")
+}
+
+func TestCandidate_StepDownStuck(t *testing.T) {
+	ctxt := context.Background()
+	lock := newWatched()
+	seen := newEvents()
+	a, _ := New(lock, &properties, "a")
+	seen.watch(a)
+
+	assert.Nil(t, a.Campaign(ctxt))
+	seen.wait(t, "a elected")
+	lock.stuck.Store("a", true)
+	lock.down.Store("a", true)
+	seen.wait(t, "a revoked")
+
+	// locking the lease left to expire again would hold it twice
+	timeout, cancel := context.WithTimeout(ctxt, properties.Lease/2)
+	defer cancel()
+	assert.ErrorIs(t, a.Campaign(timeout), context.DeadlineExceeded)
+	lock.stuck.Delete("a")
+	lock.down.Delete("a")
+	assert.Nil(t, a.Campaign(ctxt))
+	seen.wait(t, "a elected")
+	assert.Nil(t, a.Resign(ctxt))
+	seen.wait(t, "a revoked")
+	leader, _ := a.Leader(ctxt)
+	assert.Empty(t, leader, "held once")
+}
+
+func TestCandidate_Run(t *testing.T) {
+	ctxt := context.Background()
+	lock := synch.NewMemoryLock()
+	seen := newEvents()
+	a, _ := New(lock, &properties, "a")
+	seen.watch(a)
+	var working atomic.Int32
+	a.OnElected(func(ctx context.Context) {
+		working.Add(1)
+		go func() {
+			<-ctx.Done()
+			working.Add(-1)
+		}()
+	})
+	ctx, cancel := context.WithCancel(ctxt)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Run(ctx)
+	}()
+	seen.wait(t, "a elected")
+	assert.Equal(t, int32(1), working.Load())
+
+	// Run campaigns again whenever the leadership ends
+	assert.Nil(t, a.Resign(ctxt))
+	seen.wait(t, "a revoked")
+	seen.wait(t, "a elected")
+
+	cancel()
+	<-done
+	seen.wait(t, "a revoked")
+	assert.Eventually(t, func() bool { return working.Load() == 0 }, time.Second, time.Millisecond)
+}
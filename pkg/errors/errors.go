@@ -18,6 +18,7 @@ const (
 	OverwriteInternalBuiltinError i.Sentence = "Internal builtin {{.builtin}} can't be overwritten"
 	OverwriteBuiltinError         i.Sentence = "Builtin {{.builtin}} can't be overwritten"
 	OverwriteIsForbiddenError     i.Sentence = "Overwrite {{.target}} of {{.type}} is not allowed"
+	RateLimitExceededError        i.Sentence = "Too many requests, retry after {{.retry}}"
 	Unauthorized                  i.Sentence = "Unauthorized"
 	UnexpectedTypeError           i.Sentence = "Got unexpected {{.type}}"
 	UnexpectedValueError          i.Sentence = "Got unexpected {{.type}} {{.value}}"
@@ -45,6 +46,7 @@ func init() {
 	OverwriteInternalBuiltinError.Register()
 	OverwriteBuiltinError.Register()
 	OverwriteIsForbiddenError.Register()
+	RateLimitExceededError.Register()
 	UnexpectedValueError.Register()
 	UnrecognizedError.Register()
 	UnsupportedValueError.Register()
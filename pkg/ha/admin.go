@@ -0,0 +1,135 @@
+package ha
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/national"
+)
+
+// Queue names the elements of a kind by their state.
+type Queue string
+
+const (
+	// Ready elements are taken by the next drain.
+	Ready Queue = "ready"
+	// Retry elements wait for their due time, scheduled ones included.
+	Retry Queue = "retry"
+	// Dead elements exhausted their attempts.
+	Dead Queue = "dead"
+)
+
+// Stats counts the elements of a kind per queue.
+type Stats struct {
+	Kind     Kind  `json:"kind"`
+	Ready    int64 `json:"ready"`
+	Retry    int64 `json:"retry"`
+	InFlight int64 `json:"in_flight"` // taken by a consumer
+	Dead     int64 `json:"dead"`
+}
+
+// Element is an envelope seen in a queue.
+type Element[T any] struct {
+	Envelope[T]
+	Due *time.Time `json:"due_at,omitempty"` // of retries
+}
+
+// Admin inspects and repairs the queues of an executor, which are shared by
+// all its replicas.
+type Admin[T any] interface {
+	// Kinds returns the kinds which were drained or stored elements.
+	Kinds(ctxt context.Context) ([]Kind, *national.Message)
+	Stats(ctxt context.Context, kind Kind) (Stats, *national.Message)
+	// Peek pages through queue in the order its elements are taken, dead
+	// letters oldest first.
+	Peek(ctxt context.Context, kind Kind, queue Queue, offset, limit int) ([]Element[T], *national.Message)
+	// Requeue makes the elements of the Retry or Dead queue with ids due right
+	// away, all of them when no id is given. Dead letters get fresh attempts.
+	Requeue(ctxt context.Context, kind Kind, queue Queue, ids ...string) (int, *national.Message)
+	// Purge drops all the elements of queue.
+	Purge(ctxt context.Context, kind Kind, queue Queue) (int, *national.Message)
+	// Delete drops the elements of queue with ids.
+	Delete(ctxt context.Context, kind Kind, queue Queue, ids ...string) (int, *national.Message)
+}
+
+func unsupportedQueue(queue Queue) *national.Message {
+	return errors.UnsupportedValueError.Build("type", "ha-queue", "value", queue)
+}
+
+func (e *executor[T]) Admin() Admin[T] {
+	return e
+}
+
+func (e *executor[T]) Kinds(ctxt context.Context) ([]Kind, *national.Message) {
+	kinds, err := e.store.kinds(ctxt)
+	if err != nil {
+		return nil, errors.No(err)
+	}
+	slices.Sort(kinds)
+	return slices.Compact(kinds), errors.Yes()
+}
+
+func (e *executor[T]) Stats(ctxt context.Context, kind Kind) (Stats, *national.Message) {
+	s, err := e.store.stats(ctxt, kind, e.now())
+	if err != nil {
+		return s, errors.No(err)
+	}
+	return s, errors.Yes()
+}
+
+func (e *executor[T]) Peek(ctxt context.Context, kind Kind, queue Queue, offset, limit int) ([]Element[T],
+	*national.Message) {
+	offset, limit = max(0, offset), max(0, limit)
+	switch queue {
+	case Ready, Retry, Dead:
+		r, err := e.store.peek(ctxt, kind, queue, offset, limit, e.now())
+		if err != nil {
+			return nil, errors.No(err)
+		}
+		return r, errors.Yes()
+	}
+	return nil, unsupportedQueue(queue)
+}
+
+func (e *executor[T]) Requeue(ctxt context.Context, kind Kind, queue Queue, ids ...string) (int, *national.Message) {
+	switch queue {
+	case Retry:
+		n, err := e.store.advance(ctxt, kind, ids, e.now())
+		if err != nil {
+			return n, errors.No(err)
+		}
+		return n, errors.Yes()
+	case Dead:
+		return e.Replay(ctxt, kind, ids...)
+	}
+	return 0, unsupportedQueue(queue)
+}
+
+func (e *executor[T]) Purge(ctxt context.Context, kind Kind, queue Queue) (int, *national.Message) {
+	return e.drop(ctxt, kind, queue, nil)
+}
+
+func (e *executor[T]) Delete(ctxt context.Context, kind Kind, queue Queue, ids ...string) (int, *national.Message) {
+	if len(ids) == 0 {
+		return 0, errors.MissingValueError.Build("value", "ids")
+	}
+	return e.drop(ctxt, kind, queue, ids)
+}
+
+// drop removes the elements of queue with ids, all of them when ids is empty.
+func (e *executor[T]) drop(ctxt context.Context, kind Kind, queue Queue, ids []string) (int, *national.Message) {
+	switch queue {
+	case Ready, Retry:
+		n, err := e.store.remove(ctxt, kind, queue, ids, e.now())
+		if err != nil {
+			return n, errors.No(err)
+		}
+		logger.Warn("Dropped ha elements", "kind", kind, "queue", queue, "count", n)
+		return n, errors.Yes()
+	case Dead:
+		return e.discard(ctxt, kind, ids)
+	}
+	return 0, unsupportedQueue(queue)
+}
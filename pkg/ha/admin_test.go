@@ -0,0 +1,117 @@
+package ha
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/stretchr/testify/assert"
+)
+
+var adminProperties = Properties{Retry: Policy{MaxAttempts: 2}}
+
+// testAdmin exercises the admin of e on adminProperties, whose clock is c.
+func testAdmin(t *testing.T, e *executor[string], c *clock) {
+	ctxt := context.Background()
+	w := register(e, "admin", func(v ...string) *national.Message {
+		return errors.No(fmt.Errorf("unavailable"))
+	})
+	a := e.Admin()
+
+	assert.True(t, e.ExecAfter(ctxt, "admin", time.Hour, "later").Fine())
+	assert.True(t, e.ExecAfter(ctxt, "admin", time.Minute, "soon").Fine())
+	assert.True(t, e.ExecAt(ctxt, "admin", c.at, "ready", "now").Fine())
+	for _, v := range []string{"x", "y", "z"} {
+		assert.True(t, e.Exec(ctxt, "admin", v).Fine())
+	}
+	c.at = c.at.Add(time.Second)
+	e.store.prepare(ctxt, "admin", c.at)
+	batch, err := e.store.take(ctxt, "admin", 1, c.at)
+	assert.Nil(t, err)
+	assert.Len(t, batch, 1)
+
+	kinds, m := a.Kinds(ctxt)
+	assert.True(t, m.Fine())
+	assert.Contains(t, kinds, Kind("admin"))
+	stats, m := a.Stats(ctxt, "admin")
+	assert.True(t, m.Fine())
+	assert.Equal(t, int64(1), stats.InFlight)
+	assert.Equal(t, Kind("admin"), stats.Kind)
+	assert.Equal(t, int64(6), stats.Ready+stats.Retry+stats.InFlight)
+
+	retries, m := a.Peek(ctxt, "admin", Retry, 0, 100)
+	assert.True(t, m.Fine())
+	assert.Equal(t, int(stats.Retry), len(retries))
+	assert.Equal(t, []string{"later"}, retries[len(retries)-1].Values, "latest due last")
+	assert.True(t, c.at.Add(time.Hour-time.Second).Equal(*retries[len(retries)-1].Due))
+	page, _ := a.Peek(ctxt, "admin", Retry, 1, 1)
+	assert.Equal(t, retries[1:2], page)
+	_, m = a.Peek(ctxt, "admin", "unknown", 0, 10)
+	assert.False(t, m.Fine())
+
+	n, m := a.Requeue(ctxt, "admin", Retry, retries[len(retries)-1].ID, "missing")
+	assert.True(t, m.Fine())
+	assert.Equal(t, 1, n)
+	ready, _ := a.Peek(ctxt, "admin", Ready, 0, 100)
+	assert.Contains(t, values(ready), "later")
+	_, m = a.Requeue(ctxt, "admin", Ready)
+	assert.False(t, m.Fine(), "ready elements are due already")
+
+	n, m = a.Delete(ctxt, "admin", Ready, ready[0].ID)
+	assert.True(t, m.Fine())
+	assert.Equal(t, 1, n)
+	_, m = a.Delete(ctxt, "admin", Ready)
+	assert.False(t, m.Fine(), "ids are required")
+	n, _ = a.Purge(ctxt, "admin", Retry)
+	assert.Equal(t, int(stats.Retry)-1, n)
+	n, _ = a.Purge(ctxt, "admin", Ready)
+	assert.Equal(t, len(ready)-1, n)
+	stats, _ = a.Stats(ctxt, "admin")
+	assert.Equal(t, Stats{Kind: "admin", InFlight: 1}, stats)
+
+	// The element taken fails for the last time
+	batch[0].env.Attempts = 1
+	e.handle(ctxt, w, &batch[0])
+	dead, _ := a.Peek(ctxt, "admin", Dead, 0, 10)
+	assert.Len(t, dead, 1)
+	n, _ = a.Requeue(ctxt, "admin", Dead)
+	assert.Equal(t, 1, n)
+	stats, _ = a.Stats(ctxt, "admin")
+	assert.Equal(t, Stats{Kind: "admin", Ready: 1}, stats)
+
+	// Dead letters are paged oldest first
+	for i, v := range []string{"b", "c", "a"} {
+		failed := c.at.Add(time.Duration((i+1)%3) * time.Minute)
+		env := &Envelope[string]{ID: v, Values: []string{v}, FailedAt: failed}
+		assert.Nil(t, e.store.bury(ctxt, "admin", nil, env))
+	}
+	dead, _ = a.Peek(ctxt, "admin", Dead, 0, 10)
+	assert.Equal(t, []string{"a", "b", "c"}, values(dead))
+	dead, _ = a.Peek(ctxt, "admin", Dead, 1, 1)
+	assert.Equal(t, []string{"b"}, values(dead))
+	n, _ = a.Purge(ctxt, "admin", Dead)
+	assert.Equal(t, 3, n)
+	dead, _ = a.Peek(ctxt, "admin", Dead, 0, 10)
+	assert.Empty(t, dead)
+}
+
+func values(elements []Element[string]) []string {
+	var r []string
+	for _, e := range elements {
+		r = append(r, e.Values...)
+	}
+	return r
+}
+
+func TestAdmin_Cache(t *testing.T) {
+	e, _, c := newTestExecutor(&adminProperties)
+	testAdmin(t, e, c)
+}
+
+func TestAdmin_Database(t *testing.T) {
+	e, c := newDatabaseTestExecutor(t, newTestDatabase(t), &adminProperties)
+	testAdmin(t, e, c)
+}
@@ -2,26 +2,55 @@ package ha
 
 import (
 	"context"
+	stderrors "errors"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/gantries/knife/pkg/cache"
 	"github.com/gantries/knife/pkg/errors"
-	"github.com/gantries/knife/pkg/lists"
-	"github.com/gantries/knife/pkg/log"
-	"github.com/gantries/knife/pkg/maps"
+	"github.com/gantries/knife/pkg/lang"
 	"github.com/gantries/knife/pkg/national"
-	"github.com/gantries/knife/pkg/serde"
 	"github.com/gantries/knife/pkg/synch"
 )
 
-var logger = log.New("knife/ha/redis")
-
 const keyRedisPrefix = "knife/ha/redis/"
 
-type cacheExecutor[T any] struct {
-	redis     cache.Cache
-	registry  maps.Map[Kind, func(v ...T) *national.Message]
-	generator func(Kind) string
+// keyRedisKinds is the set of kinds which were drained or stored envelopes.
+const keyRedisKinds = "knife/ha/kinds/redis"
+
+// keyRedisLockPrefix prefixes the locks electing the drainers of coordinated
+// kinds when no lock is given.
+const keyRedisLockPrefix = "knife/ha/lock/"
+
+// Every kind owns the queue list of envelopes to call back, the retry sorted
+// set of retried and scheduled envelopes scored by the unix milliseconds they
+// are due, the dead hash of envelopes by id with its failed index of ids
+// scored by the unix milliseconds they failed, and the consumers hash of the
+// unix milliseconds every consumer beat last. Consumers move the envelopes they
+// call back to their in-flight list and remove them once done. The keys of a
+// kind are hash tagged with it, so they share a cluster slot even when
+// namespaced.
+const (
+	keyRetrySuffix     = "/retry"
+	keyDeadSuffix      = "/dead"
+	keyFailedSuffix    = "/dead/failed"
+	keyConsumersSuffix = "/consumers"
+	keyInFlightSuffix  = "/inflight/"
+)
+
+// keyOf returns the queue of kind, the other keys of kind are suffixed to it.
+func keyOf(kind Kind) string {
+	return keyRedisPrefix + "{" + string(kind) + "}"
+}
+
+// cacheStore keeps the envelopes in redis, the receipt of a delivery is the
+// element in the in-flight list.
+type cacheStore[T any] struct {
+	redis      cache.Cache
+	generator  func(Kind) string
+	consumer   string
+	visibility time.Duration
 }
 
 func newCacheExecutor[T any](ctxt context.Context, props *Properties) (Executor[T], *national.Message) {
@@ -29,72 +58,610 @@ func newCacheExecutor[T any](ctxt context.Context, props *Properties) (Executor[
 	if !m.Fine() {
 		return nil, m
 	}
-	return &cacheExecutor[T]{
-		redis:    c,
-		registry: maps.Map[Kind, func(v ...T) *national.Message]{},
-		generator: func(kind Kind) string {
-			return keyRedisPrefix + string(kind)
-		}}, errors.Yes()
+	if props.coordinated() && props.Lock == nil {
+		r := redisOf(c)
+		if r == nil {
+			return nil, errors.MissingValueError.Build("value", "lock")
+		}
+		p := *props
+		p.Lock = synch.NewRedisLock(r, &synch.LockProperties{Prefix: keyRedisLockPrefix})
+		props = &p
+	}
+	return withCache[T](c, props), errors.Yes()
 }
 
-func (e *cacheExecutor[T]) Register(ctxt context.Context, kind Kind, interval time.Duration,
-	cb func(v ...T) *national.Message) (*time.Ticker, *national.Message) {
-	if _, ok := e.registry[kind]; ok {
-		return nil, errors.OverwriteIsForbiddenError.Msg("target", kind, "type", "ha-registry")
+// redisOf returns the redis cache wrapped by c, or nil.
+func redisOf(c cache.Cache) *cache.RedisCache {
+	for c != nil {
+		if r, ok := c.(*cache.RedisCache); ok {
+			return r
+		}
+		w, ok := c.(interface{ Unwrap() cache.Cache })
+		if !ok {
+			return nil
+		}
+		c = w.Unwrap()
 	}
-	e.registry[kind] = cb
+	return nil
+}
 
-	ticker := time.NewTicker(interval)
-	runner := synch.Runner(1, func() {
-		logger.Info("Ha worker start", "kind", kind, "interval", interval)
-	})
-	go func() {
-		for t := range ticker.C {
-			_ = runner.Run()
-			queue := e.generator(kind)
-			ctxt := context.Background()
-			l, err := e.redis.Count(ctxt, queue)
-			if err != nil {
-				logger.Error("Unable to count element", "error", err, "when", t, "kind", kind, "interval", interval)
+// withCache returns an executor on c.
+func withCache[T any](c cache.Cache, props *Properties) *executor[T] {
+	return newExecutor[T](&cacheStore[T]{
+		redis:      c,
+		generator:  keyOf,
+		consumer:   lang.StringUUID(),
+		visibility: props.visibility(),
+	}, props)
+}
+
+func (s *cacheStore[T]) inFlight(kind Kind, consumer string) string {
+	return s.generator(kind) + keyInFlightSuffix + consumer
+}
+
+// prepare beats and recovers the envelopes of kind nobody is calling back.
+func (s *cacheStore[T]) prepare(ctxt context.Context, kind Kind, now time.Time) {
+	s.known(ctxt, kind)
+	s.keep(ctxt, kind, now)
+	s.recover(ctxt, kind, now)
+	s.migrate(ctxt, kind)
+	s.index(ctxt, kind)
+}
+
+// move queues v and then claims it from where it was kept, v is taken out of
+// the queue again when another replica claimed it first. A failure in between
+// leaves v in both places, so that it is delivered twice rather than lost. It
+// returns whether v was moved.
+func (s *cacheStore[T]) move(ctxt context.Context, kind Kind, v string, claim func() (int64, error)) (bool, error) {
+	queue := s.generator(kind)
+	if err := s.redis.Push(ctxt, queue, v); err != nil {
+		return false, err
+	}
+	n, err := claim()
+	if err != nil || n > 0 {
+		return n > 0, err
+	}
+	_, err = s.redis.LRem(ctxt, queue, -1, v)
+	return false, err
+}
+
+// migrate moves the envelopes of kind queued under its key without hash tag,
+// by replicas which do not tag the keys of kinds yet.
+func (s *cacheStore[T]) migrate(ctxt context.Context, kind Kind) {
+	legacy := keyRedisPrefix + string(kind)
+	for {
+		l, err := s.redis.LRange(ctxt, legacy, 0, 99)
+		if err != nil && !stderrors.Is(err, cache.Nil) {
+			logger.Error("Unable to list untagged elements", "error", err, "kind", kind)
+			return
+		}
+		if len(l) == 0 {
+			return
+		}
+		for _, v := range l {
+			if _, err := s.move(ctxt, kind, v, func() (int64, error) {
+				return s.redis.LRem(ctxt, legacy, 1, v)
+			}); err != nil {
+				logger.Error("Unable to move untagged element", "error", err, "kind", kind, "element", v)
+				return
+			}
+		}
+	}
+}
+
+// index adds the dead letters of kind to the failed index, when it misses
+// some buried by replicas which do not index them yet.
+func (s *cacheStore[T]) index(ctxt context.Context, kind Kind) {
+	dead, err := s.redis.HLen(ctxt, s.generator(kind)+keyDeadSuffix)
+	if err != nil && !stderrors.Is(err, cache.Nil) {
+		logger.Error("Unable to count dead letters", "error", err, "kind", kind)
+		return
+	}
+	indexed, err := s.redis.ZCard(ctxt, s.generator(kind)+keyFailedSuffix)
+	if err != nil && !stderrors.Is(err, cache.Nil) || dead <= indexed {
+		return
+	}
+	letters, err := s.letters(ctxt, kind, nil)
+	if err != nil {
+		logger.Error("Unable to list dead letters", "error", err, "kind", kind)
+		return
+	}
+	members := make([]cache.Z, 0, len(letters))
+	for id, v := range letters {
+		if env, err := decode[T](v); err == nil {
+			members = append(members, cache.Z{Score: float64(env.FailedAt.UnixMilli()), Member: id})
+		}
+	}
+	if len(members) == 0 {
+		return
+	}
+	if _, err := s.redis.ZAdd(ctxt, s.generator(kind)+keyFailedSuffix, members...); err != nil {
+		logger.Error("Unable to index dead letters", "error", err, "kind", kind)
+	}
+}
+
+// known adds kind to the kinds listed by the admin.
+func (s *cacheStore[T]) known(ctxt context.Context, kind Kind) {
+	if _, err := s.redis.SAdd(ctxt, keyRedisKinds, string(kind)); err != nil {
+		logger.Error("Unable to record ha kind", "error", err, "kind", kind)
+	}
+}
+
+// keep tells the other consumers of kind that this one is alive.
+func (s *cacheStore[T]) keep(ctxt context.Context, kind Kind, now time.Time) {
+	if _, err := s.redis.HSet(ctxt, s.generator(kind)+keyConsumersSuffix, s.consumer, now.UnixMilli()); err != nil {
+		logger.Error("Unable to beat", "error", err, "kind", kind, "consumer", s.consumer)
+	}
+}
+
+// recover queues the in-flight envelopes of kind again which no batch is
+// calling back: the ones left behind by this consumer when acknowledging
+// failed and the ones of consumers which did not beat within the visibility
+// timeout. Moving is atomic per envelope, so replicas recovering the same
+// consumer do not duplicate its envelopes.
+func (s *cacheStore[T]) recover(ctxt context.Context, kind Kind, now time.Time) {
+	s.requeue(ctxt, kind, s.consumer)
+	consumers := s.generator(kind) + keyConsumersSuffix
+	beats, err := s.redis.HGetAll(ctxt, consumers)
+	if err != nil && !stderrors.Is(err, cache.Nil) {
+		logger.Error("Unable to list ha consumers", "error", err, "kind", kind)
+		return
+	}
+	for consumer, beat := range beats {
+		if consumer == s.consumer {
+			continue
+		}
+		if at, err := strconv.ParseInt(beat, 10, 64); err == nil && now.Sub(time.UnixMilli(at)) < s.visibility {
+			continue
+		}
+		if n := s.requeue(ctxt, kind, consumer); n > 0 {
+			logger.Warn("Recovered elements of a dead consumer", "kind", kind, "consumer", consumer, "count", n)
+		}
+		if _, err := s.redis.HDel(ctxt, consumers, consumer); err != nil {
+			logger.Error("Unable to remove ha consumer", "error", err, "kind", kind, "consumer", consumer)
+		}
+	}
+}
+
+// requeue moves the in-flight envelopes of consumer back to the head of the
+// queue in their order and returns how many were moved.
+func (s *cacheStore[T]) requeue(ctxt context.Context, kind Kind, consumer string) int {
+	queue, inFlight := s.generator(kind), s.inFlight(kind, consumer)
+	n := 0
+	for {
+		if _, err := s.redis.LMove(ctxt, inFlight, queue, "RIGHT", "LEFT"); err != nil {
+			if !stderrors.Is(err, cache.Nil) {
+				logger.Error("Unable to requeue in-flight elements", "error", err, "kind", kind, "consumer", consumer)
 			}
-			if l > 0 {
-				v, err := e.redis.Pop(context.Background(), queue)
-				if err != nil {
-					logger.Error("Unable to pop element", "error", err, "when", t, "kind", kind, "interval", interval)
-				} else {
-					a, _ := serde.DeserializeArray[T]([]byte(v))
-					m := cb(a...)
-					logger.Info("Ha result", "result", *m.Body().Raw(), "when", t, "kind", kind, "interval",
-						interval)
-				}
+			return n
+		}
+		n++
+	}
+}
+
+// release requeues the in-flight envelopes of this consumer and forgets it.
+func (s *cacheStore[T]) release(ctxt context.Context, kind Kind, now time.Time) {
+	s.requeue(ctxt, kind, s.consumer)
+	if _, err := s.redis.HDel(ctxt, s.generator(kind)+keyConsumersSuffix, s.consumer); err != nil {
+		logger.Error("Unable to remove ha consumer", "error", err, "kind", kind, "consumer", s.consumer)
+	}
+}
+
+func (s *cacheStore[T]) owner() string {
+	return s.consumer
+}
+
+func (s *cacheStore[T]) members(ctxt context.Context, kind Kind, now time.Time) ([]string, error) {
+	s.keep(ctxt, kind, now)
+	beats, err := s.redis.HGetAll(ctxt, s.generator(kind)+keyConsumersSuffix)
+	if err != nil && !stderrors.Is(err, cache.Nil) {
+		return nil, err
+	}
+	members := make([]string, 0, len(beats))
+	for consumer, beat := range beats {
+		if at, err := strconv.ParseInt(beat, 10, 64); err == nil && now.Sub(time.UnixMilli(at)) < s.visibility {
+			members = append(members, consumer)
+		}
+	}
+	return members, nil
+}
+
+// take queues the due retries of kind and moves up to n envelopes from the
+// queue to the in-flight list of this consumer. Elements which cannot be
+// decoded are dropped.
+func (s *cacheStore[T]) take(ctxt context.Context, kind Kind, n int, now time.Time) ([]delivery[T], error) {
+	s.promote(ctxt, kind, now)
+	queue, inFlight := s.generator(kind), s.inFlight(kind, s.consumer)
+	var popped []*cache.StringCmd
+	err := s.redis.Pipeline(ctxt, func(p cache.Pipeliner) error {
+		for i := 0; i < n; i++ {
+			popped = append(popped, p.LMove(ctxt, queue, inFlight, "LEFT", "RIGHT"))
+		}
+		return nil
+	})
+	if stderrors.Is(err, cache.Nil) {
+		err = nil
+	}
+	var batch []delivery[T]
+	for _, cmd := range popped {
+		if cmd.Err() != nil {
+			continue
+		}
+		d := delivery[T]{receipt: cmd.Val()}
+		env, derr := decode[T](d.receipt)
+		if derr != nil {
+			logger.Error("Unable to deserialize element, dropping it", "error", derr, "kind", kind, "element", d.receipt)
+			if ackErr := s.ack(ctxt, kind, &d); ackErr != nil {
+				logger.Error("Unable to drop element", "error", ackErr, "kind", kind, "element", d.receipt)
 			}
+			continue
 		}
-	}()
+		d.env = env
+		batch = append(batch, d)
+	}
+	return batch, err
+}
+
+// promote moves the due retries of kind to its queue, a retry is moved by the
+// replica which removes it from the retry set.
+func (s *cacheStore[T]) promote(ctxt context.Context, kind Kind, now time.Time) {
+	retries := s.generator(kind) + keyRetrySuffix
+	due, err := s.redis.ZRangeByScore(ctxt, retries, &cache.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.UnixMilli(), 10),
+	})
+	if err != nil {
+		logger.Error("Unable to list due retries", "error", err, "kind", kind)
+		return
+	}
+	for _, z := range due {
+		v, _ := z.Member.(string)
+		if _, err := s.move(ctxt, kind, v, func() (int64, error) {
+			return s.redis.ZRem(ctxt, retries, v)
+		}); err != nil {
+			logger.Error("Unable to queue retry", "error", err, "kind", kind, "element", v)
+			return
+		}
+	}
+}
+
+func (s *cacheStore[T]) depths(ctxt context.Context, kind Kind, now time.Time) (int64, int64, error) {
+	ready, err := s.redis.Count(ctxt, s.generator(kind))
+	if err != nil {
+		return 0, 0, err
+	}
+	retrying, err := s.redis.ZCard(ctxt, s.generator(kind)+keyRetrySuffix)
+	return ready, retrying, err
+}
+
+func (s *cacheStore[T]) ack(ctxt context.Context, kind Kind, d *delivery[T]) error {
+	_, err := s.redis.LRem(ctxt, s.inFlight(kind, s.consumer), 1, d.receipt)
+	return err
+}
 
-	return ticker, errors.Yes()
+// settle acknowledges d once its envelope is stored elsewhere, d stays in
+// flight when that fails and is queued again by the next drain. New envelopes
+// record their kind instead.
+func (s *cacheStore[T]) settle(ctxt context.Context, kind Kind, d *delivery[T]) {
+	if d == nil {
+		s.known(ctxt, kind)
+		return
+	}
+	if err := s.ack(ctxt, kind, d); err != nil {
+		logger.Error("Unable to acknowledge element", "error", err, "kind", kind, "element", d.receipt)
+	}
+}
+
+func (s *cacheStore[T]) delay(ctxt context.Context, kind Kind, d *delivery[T], env *Envelope[T], due time.Time) error {
+	v, err := encode(env)
+	if err != nil {
+		return err
+	}
+	if _, err := s.redis.ZAdd(ctxt, s.generator(kind)+keyRetrySuffix,
+		cache.Z{Score: float64(due.UnixMilli()), Member: v}); err != nil {
+		return err
+	}
+	s.settle(ctxt, kind, d)
+	return nil
+}
+
+func (s *cacheStore[T]) bury(ctxt context.Context, kind Kind, d *delivery[T], env *Envelope[T]) error {
+	v, err := encode(env)
+	if err != nil {
+		return err
+	}
+	if _, err := s.redis.HSet(ctxt, s.generator(kind)+keyDeadSuffix, env.ID, v); err != nil {
+		return err
+	}
+	// Missing letters are indexed by the next drain
+	if _, err := s.redis.ZAdd(ctxt, s.generator(kind)+keyFailedSuffix,
+		cache.Z{Score: float64(env.FailedAt.UnixMilli()), Member: env.ID}); err != nil {
+		logger.Error("Unable to index dead letter", "error", err, "kind", kind, "id", env.ID)
+	}
+	s.settle(ctxt, kind, d)
+	return nil
 }
 
-func (e *cacheExecutor[T]) Exec(ctxt context.Context, kind Kind, va ...T) *national.Message {
-	if cb, ok := e.registry[kind]; ok {
-		failed := lists.List[T]{}
-		for _, v := range va {
-			if m := cb(v); !m.Fine() {
-				failed.Add(v)
+// unindex removes ids from the failed index of kind once their letters are
+// gone, stale ids are skipped by peek.
+func (s *cacheStore[T]) unindex(ctxt context.Context, kind Kind, ids ...string) {
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	if _, err := s.redis.ZRem(ctxt, s.generator(kind)+keyFailedSuffix, members...); err != nil {
+		logger.Error("Unable to unindex dead letters", "error", err, "kind", kind, "ids", ids)
+	}
+}
+
+func (s *cacheStore[T]) deadLetters(ctxt context.Context, kind Kind) ([]Envelope[T], error) {
+	all, err := s.redis.HGetAll(ctxt, s.generator(kind)+keyDeadSuffix)
+	if err != nil && !stderrors.Is(err, cache.Nil) {
+		return nil, err
+	}
+	r := make([]Envelope[T], 0, len(all))
+	for id, v := range all {
+		env, err := decode[T](v)
+		if err != nil {
+			logger.Error("Unable to deserialize dead letter", "error", err, "kind", kind, "id", id)
+			continue
+		}
+		r = append(r, *env)
+	}
+	return r, nil
+}
+
+func (s *cacheStore[T]) replay(ctxt context.Context, kind Kind, ids []string, now time.Time) (int, error) {
+	key := s.generator(kind) + keyDeadSuffix
+	letters, lerr := s.letters(ctxt, kind, ids)
+	n := 0
+	for id, v := range letters {
+		env, derr := decode[T](v)
+		if derr != nil {
+			logger.Error("Unable to deserialize dead letter, dropping it", "error", derr, "kind", kind, "element", v)
+			if _, err := s.redis.HDel(ctxt, key, id); err != nil {
+				return n, err
 			}
+			s.unindex(ctxt, kind, id)
+			continue
+		}
+		env.Attempts = 0
+		buf, err := encode(env)
+		if err != nil {
+			return n, err
+		}
+		moved, err := s.move(ctxt, kind, buf, func() (int64, error) {
+			return s.redis.HDel(ctxt, key, id)
+		})
+		if err != nil {
+			logger.Error("Unable to queue dead letter", "error", err, "kind", kind, "id", env.ID)
+			return n, err
+		}
+		if moved {
+			s.unindex(ctxt, kind, id)
+			n++
+		}
+	}
+	return n, lerr
+}
+
+func (s *cacheStore[T]) discard(ctxt context.Context, kind Kind, ids []string) (int, error) {
+	key := s.generator(kind) + keyDeadSuffix
+	letters, lerr := s.letters(ctxt, kind, ids)
+	n := 0
+	for id := range letters {
+		// Letters removed by another replica meanwhile are skipped
+		removed, err := s.redis.HDel(ctxt, key, id)
+		if err != nil {
+			return n, err
+		}
+		s.unindex(ctxt, kind, id)
+		n += int(removed)
+	}
+	return n, lerr
+}
+
+// letters returns the dead letters with ids by id, all of them when ids is
+// empty.
+func (s *cacheStore[T]) letters(ctxt context.Context, kind Kind, ids []string) (map[string]string, error) {
+	all, err := s.redis.HGetAll(ctxt, s.generator(kind)+keyDeadSuffix)
+	if err != nil && !stderrors.Is(err, cache.Nil) {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return all, nil
+	}
+	r := map[string]string{}
+	for _, id := range ids {
+		if v, ok := all[id]; ok {
+			r[id] = v
+		}
+	}
+	return r, nil
+}
+
+func (s *cacheStore[T]) kinds(ctxt context.Context) ([]Kind, error) {
+	members, err := s.redis.SMembers(ctxt, keyRedisKinds)
+	if err != nil && !stderrors.Is(err, cache.Nil) {
+		return nil, err
+	}
+	r := make([]Kind, 0, len(members))
+	for _, m := range members {
+		r = append(r, Kind(m))
+	}
+	return r, nil
+}
+
+func (s *cacheStore[T]) stats(ctxt context.Context, kind Kind, now time.Time) (Stats, error) {
+	r := Stats{Kind: kind}
+	var err error
+	if r.Ready, r.Retry, err = s.depths(ctxt, kind, now); err != nil {
+		return r, err
+	}
+	if r.Dead, err = s.redis.HLen(ctxt, s.generator(kind)+keyDeadSuffix); err != nil && !stderrors.Is(err, cache.Nil) {
+		return r, err
+	}
+	beats, err := s.redis.HGetAll(ctxt, s.generator(kind)+keyConsumersSuffix)
+	if err != nil && !stderrors.Is(err, cache.Nil) {
+		return r, err
+	}
+	for consumer := range beats {
+		n, err := s.redis.Count(ctxt, s.inFlight(kind, consumer))
+		if err != nil {
+			return r, err
 		}
-		if len(failed) > 0 {
-			buf, err := serde.Serialize(failed)
-			if err != nil {
-				logger.Error("Unable to serialize element", "error", err)
-				return errors.No(err)
+		r.InFlight += n
+	}
+	return r, nil
+}
+
+func (s *cacheStore[T]) peek(ctxt context.Context, kind Kind, queue Queue, offset, limit int,
+	now time.Time) ([]Element[T], error) {
+	r := []Element[T]{}
+	if limit == 0 {
+		return r, nil
+	}
+	start, stop := int64(offset), int64(offset+limit-1)
+	if queue == Dead {
+		return s.peekDead(ctxt, kind, start, stop)
+	}
+	if queue == Ready {
+		l, err := s.redis.LRange(ctxt, s.generator(kind), start, stop)
+		if err != nil && !stderrors.Is(err, cache.Nil) {
+			return nil, err
+		}
+		for _, v := range l {
+			if env, err := decode[T](v); err == nil {
+				r = append(r, Element[T]{Envelope: *env})
 			}
-			if err := e.redis.Push(ctxt, e.generator(kind), buf); err != nil {
-				logger.Error("Unable to push element to ha queue", "error", err, "kind", kind)
-				return errors.No(err)
+		}
+		return r, nil
+	}
+	z, err := s.redis.ZRange(ctxt, s.generator(kind)+keyRetrySuffix, start, stop)
+	if err != nil && !stderrors.Is(err, cache.Nil) {
+		return nil, err
+	}
+	for _, m := range z {
+		v, _ := m.Member.(string)
+		if env, err := decode[T](v); err == nil {
+			due := time.UnixMilli(int64(m.Score))
+			r = append(r, Element[T]{Envelope: *env, Due: &due})
+		}
+	}
+	return r, nil
+}
+
+// peekDead returns the dead letters of kind from start to stop of the failed
+// index, oldest first. Ids whose letter is gone are dropped from the index.
+func (s *cacheStore[T]) peekDead(ctxt context.Context, kind Kind, start, stop int64) ([]Element[T], error) {
+	r := []Element[T]{}
+	z, err := s.redis.ZRange(ctxt, s.generator(kind)+keyFailedSuffix, start, stop)
+	if err != nil && !stderrors.Is(err, cache.Nil) {
+		return nil, err
+	}
+	if len(z) == 0 {
+		return r, nil
+	}
+	key := s.generator(kind) + keyDeadSuffix
+	letters := make([]*cache.StringCmd, len(z))
+	err = s.redis.Pipeline(ctxt, func(p cache.Pipeliner) error {
+		for i, m := range z {
+			id, _ := m.Member.(string)
+			letters[i] = p.HGet(ctxt, key, id)
+		}
+		return nil
+	})
+	if err != nil && !stderrors.Is(err, cache.Nil) {
+		return nil, err
+	}
+	var stale []string
+	for i, cmd := range letters {
+		if stderrors.Is(cmd.Err(), cache.Nil) {
+			id, _ := z[i].Member.(string)
+			stale = append(stale, id)
+			continue
+		}
+		if env, err := decode[T](cmd.Val()); err == nil {
+			r = append(r, Element[T]{Envelope: *env})
+		}
+	}
+	if len(stale) > 0 {
+		s.unindex(ctxt, kind, stale...)
+	}
+	return r, nil
+}
+
+// retries returns the members of the retry set of kind with ids, all of them
+// when ids is empty.
+func (s *cacheStore[T]) retries(ctxt context.Context, kind Kind, ids []string) ([]string, error) {
+	z, err := s.redis.ZRange(ctxt, s.generator(kind)+keyRetrySuffix, 0, -1)
+	if err != nil && !stderrors.Is(err, cache.Nil) {
+		return nil, err
+	}
+	var r []string
+	for _, m := range z {
+		v, _ := m.Member.(string)
+		if len(ids) == 0 {
+			r = append(r, v)
+		} else if env, err := decode[T](v); err == nil && slices.Contains(ids, env.ID) {
+			r = append(r, v)
+		}
+	}
+	return r, nil
+}
+
+func (s *cacheStore[T]) advance(ctxt context.Context, kind Kind, ids []string, now time.Time) (int, error) {
+	members, err := s.retries(ctxt, kind, ids)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range members {
+		// Retries moved by a drain meanwhile are skipped
+		moved, err := s.move(ctxt, kind, m, func() (int64, error) {
+			return s.redis.ZRem(ctxt, s.generator(kind)+keyRetrySuffix, m)
+		})
+		if err != nil {
+			return n, err
+		}
+		if moved {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *cacheStore[T]) remove(ctxt context.Context, kind Kind, queue Queue, ids []string,
+	now time.Time) (int, error) {
+	if queue == Retry {
+		members, err := s.retries(ctxt, kind, ids)
+		if err != nil || len(members) == 0 {
+			return 0, err
+		}
+		args := make([]interface{}, 0, len(members))
+		for _, m := range members {
+			args = append(args, m)
+		}
+		n, err := s.redis.ZRem(ctxt, s.generator(kind)+keyRetrySuffix, args...)
+		return int(n), err
+	}
+	l, err := s.redis.LRange(ctxt, s.generator(kind), 0, -1)
+	if err != nil && !stderrors.Is(err, cache.Nil) {
+		return 0, err
+	}
+	n := 0
+	for _, v := range l {
+		if len(ids) > 0 {
+			if env, err := decode[T](v); err != nil || !slices.Contains(ids, env.ID) {
+				continue
 			}
 		}
-		return errors.Yes()
-	} else {
-		return errors.NotFoundError.Build("type", "ha-callback", "value", kind)
+		// Elements taken by a drain meanwhile are skipped
+		removed, err := s.redis.LRem(ctxt, s.generator(kind), 1, v)
+		if err != nil {
+			return n, err
+		}
+		n += int(removed)
 	}
+	return n, nil
 }
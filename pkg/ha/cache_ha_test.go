@@ -2,14 +2,22 @@ package ha
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gantries/knife/pkg/cache"
+	"github.com/gantries/knife/pkg/cache/cachetest"
 	"github.com/gantries/knife/pkg/errors"
 	"github.com/gantries/knife/pkg/lists"
 	"github.com/gantries/knife/pkg/national"
+	"github.com/gantries/knife/pkg/synch"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
 
 var properties = Properties{
@@ -47,14 +55,728 @@ func TestNew(t *testing.T) {
 				break
 			}
 		}
-		//ticker.Stop()
 	}()
-	ha.Register(ctxt, kind, 5*time.Second, func(v ...any) *national.Message {
+	h, m := ha.Register(ctxt, kind, 5*time.Second, func(v ...any) *national.Message {
 		logger.Info("got job", "job", v)
 		ch <- "stop"
 		return errors.Yes()
 	})
+	assert.True(t, m.Fine())
 	ha.Exec(ctxt, kind, "hello", "world")
 	ha.Exec(ctxt, kind, "ha job")
 	logger.Info("ready to exit")
+	assert.True(t, h.Stop(ctxt).Fine())
+
+	single := properties
+	single.Coordination = Single
+	ha, m = New[any](ctxt, &single)
+	assert.True(t, m.Fine(), "drainers are elected through a redis lock")
+	assert.IsType(t, &synch.RedisLock{}, ha.(*executor[any]).lock)
+}
+
+type clock struct {
+	at time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.at
+}
+
+func newTestExecutor(props *Properties) (*executor[string], *cachetest.Fake, *clock) {
+	fake := cachetest.New()
+	c := &clock{at: time.UnixMilli(1_700_000_000_000)}
+	e := withCache[string](fake, props)
+	e.now = c.now
+	return e, fake, c
+}
+
+func cached(e *executor[string]) *cacheStore[string] {
+	return e.store.(*cacheStore[string])
+}
+
+// register registers cb without draining it periodically.
+func register(e *executor[string], kind Kind, cb func(v ...string) *national.Message) *worker[string] {
+	w := &worker[string]{kind: kind, cb: cb, policy: e.props.policy(kind)}
+	e.registry[kind] = w
+	return w
+}
+
+func TestCacheExecutor_Retry(t *testing.T) {
+	ctxt := context.Background()
+	kind := Kind("retry")
+	e, fake, c := newTestExecutor(&Properties{Retry: Policy{
+		MaxAttempts: 3,
+		Backoff:     synch.Backoff{Initial: time.Second, Multiplier: 2},
+	}})
+	var calls [][]string
+	healthy := false
+	cb := func(v ...string) *national.Message {
+		calls = append(calls, v)
+		if healthy {
+			return errors.Yes()
+		}
+		return errors.No(fmt.Errorf("unavailable"))
+	}
+	w := register(e, kind, cb)
+
+	assert.True(t, e.Exec(ctxt, kind, "a", "b").Fine())
+	assert.Len(t, calls, 2)
+	n, _ := fake.ZCard(ctxt, keyOf("retry")+keyRetrySuffix)
+	assert.Equal(t, int64(1), n)
+
+	// Not due before the first backoff passed
+	e.drain(ctxt, w)
+	assert.Len(t, calls, 2)
+
+	c.at = c.at.Add(time.Second)
+	e.drain(ctxt, w)
+	assert.Equal(t, []string{"a", "b"}, calls[2])
+
+	c.at = c.at.Add(time.Second)
+	e.drain(ctxt, w)
+	assert.Len(t, calls, 3, "the second retry waits twice as long")
+
+	c.at = c.at.Add(time.Second)
+	e.drain(ctxt, w)
+	assert.Len(t, calls, 4)
+
+	dead, m := e.DeadLetters(ctxt, kind)
+	assert.True(t, m.Fine())
+	assert.Len(t, dead, 1)
+	assert.Equal(t, 3, dead[0].Attempts)
+	assert.Equal(t, []string{"a", "b"}, dead[0].Values)
+	assert.Contains(t, dead[0].Error, "unavailable")
+	assert.True(t, c.at.Equal(dead[0].FailedAt))
+	n, _ = fake.ZCard(ctxt, keyOf("retry")+keyRetrySuffix)
+	assert.Equal(t, int64(0), n)
+
+	healthy = true
+	replayed, m := e.Replay(ctxt, kind)
+	assert.True(t, m.Fine())
+	assert.Equal(t, 1, replayed)
+	e.drain(ctxt, w)
+	assert.Len(t, calls, 5)
+	dead, _ = e.DeadLetters(ctxt, kind)
+	assert.Empty(t, dead)
+	l, _ := fake.Count(ctxt, keyOf("retry"))
+	assert.Equal(t, int64(0), l)
+}
+
+func TestCacheExecutor_Policies(t *testing.T) {
+	ctxt := context.Background()
+	e, _, c := newTestExecutor(&Properties{
+		Retry:    Policy{MaxAttempts: 5},
+		Policies: map[Kind]Policy{"once": {MaxAttempts: 1}},
+	})
+	cb := func(v ...string) *national.Message {
+		return errors.NotFoundError.Build("type", "test", "value", v)
+	}
+	register(e, "once", cb)
+	register(e, "often", cb)
+
+	assert.True(t, e.Exec(ctxt, "once", "x").Fine())
+	c.at = c.at.Add(time.Millisecond)
+	assert.True(t, e.Exec(ctxt, "once", "y").Fine())
+	assert.True(t, e.Exec(ctxt, "often", "z").Fine())
+
+	once, _ := e.DeadLetters(ctxt, "once")
+	assert.Len(t, once, 2)
+	assert.Equal(t, []string{"x"}, once[0].Values, "oldest first")
+	often, _ := e.DeadLetters(ctxt, "often")
+	assert.Empty(t, often)
+
+	n, m := e.Discard(ctxt, "once", once[0].ID, "missing")
+	assert.True(t, m.Fine())
+	assert.Equal(t, 1, n)
+	n, _ = e.Replay(ctxt, "once", "missing")
+	assert.Equal(t, 0, n)
+	once, _ = e.DeadLetters(ctxt, "once")
+	assert.Len(t, once, 1)
+	assert.Equal(t, []string{"y"}, once[0].Values)
+}
+
+func TestCacheExecutor_Failures(t *testing.T) {
+	ctxt := context.Background()
+	e, fake, _ := newTestExecutor(&Properties{Retry: Policy{MaxAttempts: 1}})
+	register(e, "broken", func(v ...string) *national.Message {
+		return errors.No(fmt.Errorf("unavailable"))
+	})
+	fake.On("HSet", cachetest.Any).Fail(fmt.Errorf("down"))
+	assert.False(t, e.Exec(ctxt, "broken", "x").Fine())
+	assert.False(t, e.Exec(ctxt, "unknown", "x").Fine())
+}
+
+func TestDecode(t *testing.T) {
+	env, err := decode[string](`["a","b"]`)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, env.Attempts, "arrays queued before envelopes failed once")
+	assert.Equal(t, []string{"a", "b"}, env.Values)
+	assert.NotEmpty(t, env.ID)
+
+	env, err = decode[string](`{"id":"1","attempts":2,"values":["c"]}`)
+	assert.Nil(t, err)
+	assert.Equal(t, Envelope[string]{ID: "1", Attempts: 2, Values: []string{"c"}}, *env)
+
+	_, err = decode[string](`nope`)
+	assert.NotNil(t, err)
+}
+
+func TestCacheExecutor_Drain(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	ctxt := context.Background()
+	kind := Kind("drain")
+	e, fake, _ := newTestExecutor(&Properties{Retry: Policy{MaxAttempts: 2, Batch: 10, Concurrency: 4}})
+	for i := 0; i < 25; i++ {
+		assert.Nil(t, fake.Push(ctxt, keyOf("drain"), fmt.Sprintf(`["%d"]`, i)))
+	}
+	var mutex sync.Mutex
+	called, running, most := 0, 0, 0
+	w := register(e, kind, func(v ...string) *national.Message {
+		mutex.Lock()
+		called++
+		running++
+		most = max(most, running)
+		mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		if v[0] == "13" {
+			return errors.No(fmt.Errorf("unavailable"))
+		}
+		return errors.Yes()
+	})
+	e.observe(w)
+
+	e.drain(ctxt, w)
+	assert.Equal(t, 25, called, "drains until the queue is empty")
+	assert.Len(t, fake.Calls("Pipeline"), 4, "three batches and an empty one")
+	assert.Greater(t, most, 1)
+	assert.LessOrEqual(t, most, 4)
+	dead, _ := e.DeadLetters(ctxt, kind)
+	assert.Len(t, dead, 1)
+
+	var rm metricdata.ResourceMetrics
+	assert.Nil(t, reader.Collect(ctxt, &rm))
+	metrics := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	results := map[string]int64{}
+	for _, p := range metrics["knife.ha.processed"].(metricdata.Sum[int64]).DataPoints {
+		result, _ := p.Attributes.Value("ha.result")
+		results[result.AsString()] = p.Value
+	}
+	assert.Equal(t, map[string]int64{"success": 24, "dead": 1}, results)
+	duration := metrics["knife.ha.duration"].(metricdata.Histogram[float64])
+	assert.Equal(t, uint64(25), duration.DataPoints[0].Count)
+	queues := 0
+	for _, p := range metrics["knife.ha.depth"].(metricdata.Gauge[int64]).DataPoints {
+		if k, _ := p.Attributes.Value("ha.kind"); k.AsString() == string(kind) {
+			queues++
+			assert.Equal(t, int64(0), p.Value)
+		}
+	}
+	assert.Equal(t, 2, queues)
+}
+
+func TestCacheExecutor_Stop(t *testing.T) {
+	ctxt := context.Background()
+	e, fake, _ := newTestExecutor(&Properties{Retry: Policy{MaxAttempts: 3, Batch: 10}})
+	started, release := make(chan string), make(chan struct{})
+	h, m := e.Register(ctxt, "stop", time.Millisecond, func(v ...string) *national.Message {
+		started <- v[0]
+		<-release
+		return errors.Yes()
+	})
+	assert.True(t, m.Fine())
+	assert.Equal(t, Kind("stop"), h.Kind())
+	_, m = e.Register(ctxt, "stop", time.Millisecond, nil)
+	assert.False(t, m.Fine(), "kinds are registered once")
+
+	assert.Nil(t, fake.Push(ctxt, keyOf("stop"), `["a"]`, `["b"]`))
+	assert.Equal(t, "a", <-started)
+
+	// The batch in progress outlives a short deadline
+	timeout, cancel := context.WithTimeout(ctxt, 10*time.Millisecond)
+	defer cancel()
+	assert.False(t, h.Stop(timeout).Fine())
+	close(release)
+	assert.Equal(t, "b", <-started, "the popped batch is finished")
+	<-h.Done()
+	assert.True(t, h.Stop(ctxt).Fine())
+	assert.False(t, e.Exec(ctxt, "stop", "c").Fine(), "stopped kinds are unregistered")
+	assert.False(t, e.Unregister(ctxt, "stop").Fine())
+
+	h, m = e.Register(ctxt, "stop", time.Millisecond, func(v ...string) *national.Message {
+		return errors.Yes()
+	})
+	assert.True(t, m.Fine(), "stopped kinds can be registered again")
+	assert.True(t, e.Unregister(ctxt, "stop").Fine())
+	select {
+	case <-h.Done():
+	default:
+		assert.Fail(t, "unregister waits for the handle")
+	}
+}
+
+func TestCacheExecutor_Context(t *testing.T) {
+	e, _, _ := newTestExecutor(&Properties{})
+	ctxt, cancel := context.WithCancel(context.Background())
+	h, m := e.Register(ctxt, "context", time.Hour, func(v ...string) *national.Message {
+		return errors.Yes()
+	})
+	assert.True(t, m.Fine())
+	cancel()
+	select {
+	case <-h.Done():
+	case <-time.After(time.Second):
+		assert.Fail(t, "the registration context stops draining")
+	}
+	assert.False(t, e.Exec(context.Background(), "context", "a").Fine())
+}
+
+func TestCacheExecutor_Concurrent(t *testing.T) {
+	ctxt := context.Background()
+	e, _, _ := newTestExecutor(&Properties{})
+	cb := func(v ...string) *national.Message {
+		return errors.Yes()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			kind := Kind(fmt.Sprintf("concurrent-%d", i%2))
+			for j := 0; j < 20; j++ {
+				if h, m := e.Register(ctxt, kind, time.Hour, cb); m.Fine() {
+					_ = e.Exec(ctxt, kind, "a")
+					assert.True(t, h.Stop(ctxt).Fine())
+				} else {
+					_ = e.Exec(ctxt, kind, "a")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Empty(t, e.registry)
+}
+
+func TestCacheExecutor_InFlight(t *testing.T) {
+	ctxt := context.Background()
+	e, fake, _ := newTestExecutor(&Properties{Retry: Policy{Batch: 10}})
+	s := cached(e)
+	inFlight := s.inFlight("flight", s.consumer)
+	assert.Equal(t, keyRedisPrefix+"{flight}/inflight/"+s.consumer, inFlight, "hash tagged with the queue")
+	var seen []int64
+	w := register(e, "flight", func(v ...string) *national.Message {
+		n, _ := fake.Count(ctxt, inFlight)
+		seen = append(seen, n)
+		return errors.Yes()
+	})
+	assert.Nil(t, fake.Push(ctxt, keyOf("flight"), `["a"]`, `["b"]`))
+	e.drain(ctxt, w)
+	assert.Equal(t, []int64{2, 1}, seen, "elements are acknowledged one by one")
+	n, _ := fake.Exists(ctxt, inFlight)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestCacheExecutor_Recover(t *testing.T) {
+	ctxt := context.Background()
+	props := &Properties{Retry: Policy{Batch: 10}, Visibility: time.Minute}
+	crashed, fake, c := newTestExecutor(props)
+	alive := withCache[string](fake, props)
+	alive.now = c.now
+	var called []string
+	w := register(alive, "recover", func(v ...string) *national.Message {
+		called = append(called, v...)
+		return errors.Yes()
+	})
+
+	// A consumer taking a batch and crashing before calling it back
+	assert.Nil(t, fake.Push(ctxt, keyOf("recover"), `["a"]`, `["b"]`))
+	cached(crashed).keep(ctxt, "recover", c.at)
+	batch, err := cached(crashed).take(ctxt, "recover", 10, c.at)
+	assert.Nil(t, err)
+	assert.Len(t, batch, 2)
+	assert.Nil(t, fake.Push(ctxt, keyOf("recover"), `["c"]`))
+
+	alive.drain(ctxt, w)
+	assert.Equal(t, []string{"c"}, called, "in-flight elements are invisible until the consumer times out")
+
+	c.at = c.at.Add(time.Minute)
+	alive.drain(ctxt, w)
+	assert.Equal(t, []string{"c", "a", "b"}, called)
+	n, _ := fake.Exists(ctxt, cached(crashed).inFlight("recover", cached(crashed).consumer))
+	assert.Equal(t, int64(0), n)
+	beats, _ := fake.HGetAll(ctxt, keyOf("recover")+keyConsumersSuffix)
+	assert.NotContains(t, beats, cached(crashed).consumer)
+	assert.Contains(t, beats, cached(alive).consumer)
+}
+
+func TestCacheExecutor_Redeliver(t *testing.T) {
+	ctxt := context.Background()
+	e, fake, _ := newTestExecutor(&Properties{Retry: Policy{MaxAttempts: 1}})
+	calls := 0
+	w := register(e, "redeliver", func(v ...string) *national.Message {
+		calls++
+		return errors.No(fmt.Errorf("unavailable"))
+	})
+	assert.Nil(t, fake.Push(ctxt, keyOf("redeliver"), `["a"]`))
+	fake.On("HSet", keyOf("redeliver")+keyDeadSuffix).Fail(fmt.Errorf("down")).Times(1)
+	e.drain(ctxt, w)
+	assert.Equal(t, 1, calls)
+	n, _ := fake.Count(ctxt, cached(e).inFlight("redeliver", cached(e).consumer))
+	assert.Equal(t, int64(1), n, "kept in flight while its failure is not stored")
+
+	e.drain(ctxt, w)
+	assert.Equal(t, 2, calls, "delivered at least once")
+	dead, _ := e.DeadLetters(ctxt, "redeliver")
+	assert.Len(t, dead, 1)
+	n, _ = fake.Exists(ctxt, cached(e).inFlight("redeliver", cached(e).consumer))
+	assert.Equal(t, int64(0), n)
+}
+
+func TestCacheExecutor_ExecAt(t *testing.T) {
+	ctxt := context.Background()
+	e, fake, c := newTestExecutor(&Properties{Retry: Policy{Batch: 10}})
+	var called []string
+	w := register(e, "later", func(v ...string) *national.Message {
+		called = append(called, v...)
+		return errors.Yes()
+	})
+	assert.False(t, e.ExecAt(ctxt, "unknown", c.at, "a").Fine())
+	assert.True(t, e.ExecAt(ctxt, "later", c.at.Add(time.Hour), "deadline").Fine())
+	assert.True(t, e.ExecAfter(ctxt, "later", 10*time.Minute, "webhook").Fine())
+	assert.True(t, e.ExecAt(ctxt, "later", c.at.Add(-time.Minute), "past").Fine())
+	assert.True(t, e.ExecAfter(ctxt, "later", time.Minute).Fine(), "nothing to queue")
+	assert.Empty(t, called, "not called right away")
+	n, _ := fake.ZCard(ctxt, keyOf("later")+keyRetrySuffix)
+	assert.Equal(t, int64(3), n)
+
+	e.drain(ctxt, w)
+	assert.Equal(t, []string{"past"}, called)
+	c.at = c.at.Add(10 * time.Minute)
+	e.drain(ctxt, w)
+	assert.Equal(t, []string{"past", "webhook"}, called)
+	c.at = c.at.Add(time.Hour)
+	e.drain(ctxt, w)
+	assert.Equal(t, []string{"past", "webhook", "deadline"}, called)
+
+	fake.On("ZAdd", cachetest.Any).Fail(fmt.Errorf("down"))
+	assert.False(t, e.ExecAfter(ctxt, "later", time.Minute, "lost").Fine())
+}
+
+func TestCacheStore_Keys(t *testing.T) {
+	e, _, _ := newTestExecutor(&Properties{})
+	s := cached(e)
+	for _, key := range []string{s.generator("slot"), s.generator("slot") + keyRetrySuffix,
+		s.generator("slot") + keyDeadSuffix, s.generator("slot") + keyConsumersSuffix, s.inFlight("slot", "c")} {
+		// Namespaces prefix the keys, the hash tag keeps them in one slot
+		open := strings.IndexByte(key, '{')
+		assert.Equal(t, "{slot}", key[open:open+len("{slot}")], key)
+	}
+}
+
+func TestCacheStore_Move(t *testing.T) {
+	ctxt := context.Background()
+	e, fake, c := newTestExecutor(&Properties{})
+	s := cached(e)
+	retries := keyOf("move") + keyRetrySuffix
+	register(e, "move", func(v ...string) *national.Message { return errors.Yes() })
+	assert.True(t, e.ExecAt(ctxt, "move", c.at, "a").Fine())
+
+	// Claimed by another replica meanwhile, the push is taken back
+	fake.On("ZRem", retries).Return(0).Times(1)
+	s.promote(ctxt, "move", c.at)
+	ready, retrying, _ := s.depths(ctxt, "move", c.at)
+	assert.Equal(t, []int64{0, 1}, []int64{ready, retrying})
+
+	// Failing to claim delivers twice rather than losing it
+	fake.On("ZRem", retries).Fail(fmt.Errorf("down")).Times(1)
+	s.promote(ctxt, "move", c.at)
+	ready, retrying, _ = s.depths(ctxt, "move", c.at)
+	assert.Equal(t, []int64{1, 1}, []int64{ready, retrying})
+	s.promote(ctxt, "move", c.at)
+	ready, retrying, _ = s.depths(ctxt, "move", c.at)
+	assert.Equal(t, []int64{2, 0}, []int64{ready, retrying})
+
+	// Failing to queue keeps it where it was
+	_, _ = fake.Del(ctxt, keyOf("move"))
+	assert.True(t, e.ExecAt(ctxt, "move", c.at, "b").Fine())
+	fake.On("Push", keyOf("move")).Fail(fmt.Errorf("down")).Times(1)
+	s.promote(ctxt, "move", c.at)
+	ready, retrying, _ = s.depths(ctxt, "move", c.at)
+	assert.Equal(t, []int64{0, 1}, []int64{ready, retrying})
+}
+
+func TestCacheStore_Migrate(t *testing.T) {
+	ctxt := context.Background()
+	e, fake, _ := newTestExecutor(&Properties{Retry: Policy{Batch: 10}})
+	var called []string
+	w := register(e, "legacy", func(v ...string) *national.Message {
+		called = append(called, v...)
+		return errors.Yes()
+	})
+	// Queued by replicas which do not tag the keys of kinds yet
+	assert.Nil(t, fake.Push(ctxt, keyRedisPrefix+"legacy", `["a"]`, `["b"]`))
+	e.drain(ctxt, w)
+	assert.Equal(t, []string{"a", "b"}, called)
+	n, _ := fake.Exists(ctxt, keyRedisPrefix+"legacy")
+	assert.Equal(t, int64(0), n)
+}
+
+func TestCacheStore_Failed(t *testing.T) {
+	ctxt := context.Background()
+	e, fake, c := newTestExecutor(&Properties{})
+	s := cached(e)
+	// Buried by replicas which do not index dead letters yet
+	for i, id := range []string{"b", "a"} {
+		v, _ := encode(&Envelope[string]{ID: id, Values: []string{id}, FailedAt: c.at.Add(-time.Duration(i) * time.Minute)})
+		_, _ = fake.HSet(ctxt, keyOf("failed")+keyDeadSuffix, id, v)
+	}
+	dead, m := e.Admin().Peek(ctxt, "failed", Dead, 0, 10)
+	assert.True(t, m.Fine())
+	assert.Empty(t, dead)
+	s.prepare(ctxt, "failed", c.at)
+	dead, _ = e.Admin().Peek(ctxt, "failed", Dead, 0, 10)
+	assert.Equal(t, []string{"a", "b"}, values(dead))
+
+	// Letters gone behind the back of the index are skipped and unindexed
+	_, _ = fake.HDel(ctxt, keyOf("failed")+keyDeadSuffix, "a")
+	dead, _ = e.Admin().Peek(ctxt, "failed", Dead, 0, 10)
+	assert.Equal(t, []string{"b"}, values(dead))
+	n, _ := fake.ZCard(ctxt, keyOf("failed")+keyFailedSuffix)
+	assert.Equal(t, int64(1), n)
+}
+
+// leaseLock is a reentrant lock whose leases expire on a clock.
+type leaseLock struct {
+	clock  *clock
+	mutex  sync.Mutex
+	leases map[string]*lease
+}
+
+type lease struct {
+	owner string
+	count int
+	until time.Time
+}
+
+func newLeaseLock(c *clock) *leaseLock {
+	return &leaseLock{clock: c, leases: map[string]*lease{}}
+}
+
+// held returns the lease of source unless it expired.
+func (l *leaseLock) held(source string) *lease {
+	if h, ok := l.leases[source]; ok && l.clock.at.Before(h.until) {
+		return h
+	}
+	return nil
+}
+
+func (l *leaseLock) Lock(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	h := l.held(source)
+	if h == nil {
+		h = &lease{owner: owner}
+		l.leases[source] = h
+	} else if h.owner != owner {
+		return false, nil
+	}
+	h.count++
+	h.until = l.clock.at.Add(timeout)
+	return true, nil
+}
+
+func (l *leaseLock) Unlock(ctx context.Context, source, owner string) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	h := l.held(source)
+	if h == nil || h.owner != owner {
+		return false, nil
+	}
+	if h.count--; h.count == 0 {
+		delete(l.leases, source)
+	}
+	return true, nil
+}
+
+func (l *leaseLock) Renew(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	h := l.held(source)
+	if h == nil || h.owner != owner {
+		return false, nil
+	}
+	h.until = l.clock.at.Add(timeout)
+	return true, nil
+}
+
+func TestCacheExecutor_Single(t *testing.T) {
+	ctxt := context.Background()
+	c := &clock{at: time.UnixMilli(1_700_000_000_000)}
+	lock := newLeaseLock(c)
+	props := &Properties{Coordination: Single, Lease: time.Minute, Lock: lock}
+	a, fake, _ := newTestExecutor(props)
+	a.now = c.now
+	b := withCache[string](fake, props)
+	b.now = c.now
+	cb := func(v ...string) *national.Message { return errors.Yes() }
+	wa, wb := register(a, "single", cb), register(b, "single", cb)
+	wb.cancel = func() {}
+
+	assert.True(t, a.active(ctxt, wa))
+	assert.False(t, b.active(ctxt, wb))
+	c.at = c.at.Add(time.Minute / 2)
+	assert.True(t, a.active(ctxt, wa), "the lease is extended")
+	assert.Equal(t, 1, lock.leases["ha/single"].count)
+	c.at = c.at.Add(time.Minute / 2)
+	assert.False(t, b.active(ctxt, wb))
+
+	// The holder dies
+	c.at = c.at.Add(time.Minute)
+	assert.True(t, b.active(ctxt, wb), "taken over once the lease expired")
+	assert.False(t, a.active(ctxt, wa))
+	assert.False(t, wa.leading)
+
+	b.remove(wb)
+	assert.Nil(t, lock.held("ha/single"), "stopping resigns")
+	assert.True(t, a.active(ctxt, wa))
+
+	shared, _, _ := newTestExecutor(&Properties{})
+	assert.True(t, shared.active(ctxt, register(shared, "single", cb)))
+}
+
+// unrenewable is a lock whose holders can only lock again.
+type unrenewable struct {
+	lock *leaseLock
+}
+
+func (u unrenewable) Lock(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
+	return u.lock.Lock(ctx, source, owner, timeout)
+}
+
+func (u unrenewable) Unlock(ctx context.Context, source, owner string) (bool, error) {
+	return u.lock.Unlock(ctx, source, owner)
+}
+
+func TestCacheExecutor_Renewer(t *testing.T) {
+	lock := unrenewable{lock: newLeaseLock(&clock{})}
+	_, m := New[string](context.Background(), &Properties{Type: Cache, Coordination: Single, Lock: lock})
+	assert.False(t, m.Fine(), "leaders renew their lease")
+	_, m = New[string](context.Background(), &Properties{Type: "none", Lock: lock})
+	assert.Contains(t, m.E(nil).Error(), "ha-type", "uncoordinated kinds do not lock")
+}
+
+func TestCacheExecutor_LeaseLost(t *testing.T) {
+	ctxt := context.Background()
+	c := &clock{at: time.UnixMilli(1_700_000_000_000)}
+	lock := newLeaseLock(c)
+	props := &Properties{Coordination: Single, Lease: time.Minute, Lock: lock, Retry: Policy{Batch: 1}}
+	a, fake, _ := newTestExecutor(props)
+	a.now = c.now
+	b := withCache[string](fake, props)
+	b.now = c.now
+	for i := range 3 {
+		assert.Nil(t, fake.Push(ctxt, keyOf("lost"), fmt.Sprintf(`["%d"]`, i)))
+	}
+	var called []string
+	var wb *worker[string]
+	wa := register(a, "lost", func(v ...string) *national.Message {
+		called = append(called, v[0])
+		// The batch outlives the lease, b takes over
+		c.at = c.at.Add(2 * time.Minute)
+		assert.True(t, b.active(ctxt, wb))
+		return errors.Yes()
+	})
+	wb = register(b, "lost", func(v ...string) *national.Message { return errors.Yes() })
+
+	assert.True(t, a.active(ctxt, wa))
+	a.drain(ctxt, wa)
+	assert.Equal(t, []string{"0"}, called, "stops draining once the lease is lost")
+	assert.False(t, wa.leading)
+	ready, _, err := a.store.depths(ctxt, "lost", c.now())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), ready)
+}
+
+func TestCacheExecutor_Partition(t *testing.T) {
+	ctxt := context.Background()
+	c := &clock{at: time.UnixMilli(1_700_000_000_000)}
+	props := &Properties{Coordination: Partition, Lease: time.Minute, Visibility: time.Minute, Lock: newLeaseLock(c)}
+	a, fake, _ := newTestExecutor(props)
+	a.now = c.now
+	b := withCache[string](fake, props)
+	b.now = c.now
+	cached(a).consumer, cached(b).consumer = "a", "b"
+	cb := func(v ...string) *national.Message { return errors.Yes() }
+	kinds := []Kind{"k0", "k1", "k2", "k3", "k4", "k5"}
+	for _, kind := range kinds {
+		register(a, kind, cb)
+		register(b, kind, cb)
+	}
+	round := func() map[Kind]string {
+		leaders := map[Kind]string{}
+		for _, kind := range kinds {
+			for _, e := range []*executor[string]{a, b} {
+				if e.active(ctxt, e.registry[kind]) {
+					assert.Empty(t, leaders[kind], "a single drainer per kind")
+					leaders[kind] = e.store.owner()
+				}
+			}
+		}
+		return leaders
+	}
+
+	// a sees b joining at its second round, and resigns the kinds of b
+	round()
+	round()
+	leaders := round()
+	drained := map[string]int{}
+	for _, kind := range kinds {
+		assert.Equal(t, assignee(kind, []string{"a", "b"}), leaders[kind])
+		drained[leaders[kind]]++
+	}
+	assert.Len(t, drained, 2, "kinds are spread")
+
+	// b dies, its kinds move to a once it stopped beating and its leases expired
+	c.at = c.at.Add(time.Minute / 2)
+	for _, kind := range kinds {
+		assert.Equal(t, leaders[kind] == "a", a.active(ctxt, a.registry[kind]))
+	}
+	c.at = c.at.Add(time.Minute / 2)
+	for _, kind := range kinds {
+		assert.True(t, a.active(ctxt, a.registry[kind]))
+	}
+	members, err := b.store.members(ctxt, "k0", c.at)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, members)
+}
+
+func TestCacheExecutor_PartitionBeats(t *testing.T) {
+	ctxt := context.Background()
+	fake := cachetest.New()
+	props := &Properties{Coordination: Partition, Lease: time.Minute, Visibility: 90 * time.Millisecond,
+		Lock: newLeaseLock(&clock{})}
+	a, b := withCache[string](fake, props), withCache[string](fake, props)
+	cb := func(v ...string) *national.Message { return errors.Yes() }
+	for _, e := range []*executor[string]{a, b} {
+		// Drained less often than members must beat
+		h, m := e.Register(ctxt, "beats", time.Hour, cb)
+		assert.True(t, m.Fine())
+		defer h.Stop(ctxt)
+	}
+
+	time.Sleep(3 * props.Visibility)
+	for _, e := range []*executor[string]{a, b} {
+		members, err := e.store.members(ctxt, "beats", time.Now())
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []string{a.store.owner(), b.store.owner()}, members)
+	}
 }
@@ -0,0 +1,505 @@
+package ha
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/lang"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/gantries/knife/pkg/orm"
+	"github.com/gantries/knife/pkg/synch"
+	"github.com/gantries/knife/pkg/types"
+	"gorm.io/gorm"
+)
+
+// Every envelope is a row of the table, identified by its id column. Rows are
+// due at the unix milliseconds of due_at, retries are due once their backoff
+// passed. A consumer taking a row sets itself as its consumer and moves due_at
+// by the visibility timeout, so that other consumers take the row again only
+// when it stopped keeping it. Dead letters are flagged by dead.
+
+// databaseColumnTypes are the types of the id and envelope columns.
+var databaseColumnTypes = map[types.DatabaseType][2]string{
+	types.MySQL:     {"BIGINT AUTO_INCREMENT PRIMARY KEY", "LONGTEXT"},
+	types.Postgres:  {"BIGSERIAL PRIMARY KEY", "TEXT"},
+	types.Oracle:    {"NUMBER(19) GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY", "CLOB"},
+	types.SQLServer: {"BIGINT IDENTITY(1,1) PRIMARY KEY", "NVARCHAR(MAX)"},
+	types.SQLite:    {"INTEGER PRIMARY KEY AUTOINCREMENT", "TEXT"},
+}
+
+// databaseStore keeps the envelopes in a table, the receipt of a delivery is
+// the id of its row.
+type databaseStore[T any] struct {
+	db         *orm.Database
+	table      string
+	consumers  string
+	consumer   string
+	visibility time.Duration
+}
+
+func newDatabaseExecutor[T any](ctxt context.Context, props *Properties) (Executor[T], *national.Message) {
+	if props.DB == nil {
+		return nil, errors.MissingValueError.Build("value", "db")
+	}
+	if props.coordinated() && props.Lock == nil {
+		lock, m := synch.NewDatabaseLock(ctxt, props.DB, &synch.LockProperties{Table: props.table() + "_lock"})
+		if !m.Fine() {
+			return nil, m
+		}
+		p := *props
+		p.Lock = lock
+		props = &p
+	}
+	s, err := withDatabase[T](ctxt, props.DB, props)
+	if err != nil {
+		return nil, errors.No(err)
+	}
+	return newExecutor[T](s, props), errors.Yes()
+}
+
+// withDatabase returns a store on db, creating its table when missing.
+func withDatabase[T any](ctxt context.Context, db *orm.Database, props *Properties) (*databaseStore[T], error) {
+	s := &databaseStore[T]{db: db, table: props.table(), consumers: props.table() + "_consumer",
+		consumer: lang.StringUUID(), visibility: props.visibility()}
+	if err := s.migrate(ctxt); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// q escapes the identifiers of statement which are enclosed in braces.
+func (s *databaseStore[T]) q(statement string) string {
+	l, r := s.db.EscapeCharacters()
+	return strings.NewReplacer("{", l, "}", r).Replace(statement)
+}
+
+func (s *databaseStore[T]) session(ctxt context.Context) *gorm.DB {
+	if tx := s.db.OptionalTx(ctxt); tx != nil {
+		return tx
+	}
+	return s.db.DB().WithContext(ctxt)
+}
+
+// migrate creates the missing tables.
+func (s *databaseStore[T]) migrate(ctxt context.Context) error {
+	columns, ok := databaseColumnTypes[s.db.Dialect()]
+	if !ok {
+		return errors.UnsupportedValueError.E(nil, "type", "database-dialect", "value", s.db.Dialect())
+	}
+	text := lang.Ternary(s.db.Dialect() == types.Oracle, "VARCHAR2", "VARCHAR")
+	number := lang.Ternary(s.db.Dialect() == types.Oracle, "NUMBER(19)", "BIGINT")
+	err := s.create(ctxt, s.table, "{id} "+columns[0]+", "+
+		"{kind} "+text+"(191) NOT NULL, "+
+		"{dead} SMALLINT NOT NULL, "+
+		"{due_at} "+number+" NOT NULL, "+
+		"{consumer} "+text+"(64) NULL, "+
+		"{envelope_id} "+text+"(64) NOT NULL, "+
+		"{envelope} "+columns[1]+" NOT NULL",
+		"CREATE INDEX {idx_"+s.table+"_due} ON {"+s.table+"} ({kind}, {dead}, {due_at})")
+	if err != nil {
+		return err
+	}
+	return s.create(ctxt, s.consumers, "{kind} "+text+"(191) NOT NULL, "+
+		"{consumer} "+text+"(64) NOT NULL, "+
+		"{beat_at} "+number+" NOT NULL, "+
+		"PRIMARY KEY ({kind}, {consumer})", "")
+}
+
+// create creates table of columns and its index unless it exists.
+func (s *databaseStore[T]) create(ctxt context.Context, table, columns, index string) error {
+	db := s.session(ctxt)
+	if db.Migrator().HasTable(table) {
+		return nil
+	}
+	err := db.Exec(s.q("CREATE TABLE {" + table + "} (" + columns + ")")).Error
+	if err == nil && len(index) > 0 {
+		err = db.Exec(s.q(index)).Error
+	}
+	if err != nil && db.Migrator().HasTable(table) {
+		// Created by another replica meanwhile
+		return nil
+	}
+	return err
+}
+
+func (s *databaseStore[T]) prepare(ctxt context.Context, kind Kind, now time.Time) {
+	// Rows of consumers which stopped keeping them are due again on their own
+}
+
+// take claims up to n due rows of kind, other consumers skip the rows locked
+// meanwhile where the dialect supports it and the claim is conditional on the
+// rows still being due where it does not.
+func (s *databaseStore[T]) take(ctxt context.Context, kind Kind, n int, now time.Time) ([]delivery[T], error) {
+	var batch []delivery[T]
+	err := s.db.Tx(ctxt, func(c context.Context, tx *gorm.DB) error {
+		batch = nil
+		ids, err := s.due(tx, kind, n, now)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := tx.Exec(s.q("UPDATE {"+s.table+"} SET {due_at} = ?, {consumer} = ? "+
+			"WHERE {id} IN ? AND {dead} = 0 AND {due_at} <= ?"),
+			now.Add(s.visibility).UnixMilli(), s.consumer, ids, now.UnixMilli()).Error; err != nil {
+			return err
+		}
+		rows, err := tx.Raw(s.q("SELECT {id}, {envelope} FROM {"+s.table+"} WHERE {id} IN ? AND {consumer} = ? "+
+			"ORDER BY {id}"), ids, s.consumer).Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			var v string
+			if err := rows.Scan(&id, &v); err != nil {
+				return err
+			}
+			d := delivery[T]{receipt: strconv.FormatInt(id, 10)}
+			env, err := decode[T](v)
+			if err != nil {
+				logger.Error("Unable to deserialize element, dropping it", "error", err, "kind", kind, "element", v)
+				if err := tx.Exec(s.q("DELETE FROM {"+s.table+"} WHERE {id} = ?"), id).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			d.env = env
+			batch = append(batch, d)
+		}
+		return rows.Err()
+	})
+	return batch, err
+}
+
+// due returns the ids of up to n due rows of kind and locks them. The fetch
+// stops after n rows, since Oracle locks the rows it does not skip as they
+// are fetched.
+func (s *databaseStore[T]) due(tx *gorm.DB, kind Kind, n int, now time.Time) ([]int64, error) {
+	rows, err := tx.Raw(s.candidates(n), kind, now.UnixMilli()).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for len(ids) < max(1, n) && rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// candidates selects the ids of up to n due rows and locks them.
+func (s *databaseStore[T]) candidates(n int) string {
+	where := " WHERE {kind} = ? AND {dead} = 0 AND {due_at} <= ?"
+	limit := strconv.Itoa(max(1, n))
+	switch s.db.Dialect() {
+	case types.MySQL, types.Postgres:
+		return s.q("SELECT {id} FROM {" + s.table + "}" + where + " ORDER BY {id} LIMIT " + limit +
+			" FOR UPDATE SKIP LOCKED")
+	case types.Oracle:
+		// ROWNUM would be applied before skipping the locked rows, the fetch
+		// stops after n rows instead
+		return s.q("SELECT {id} FROM {" + s.table + "}" + where + " ORDER BY {id} FOR UPDATE SKIP LOCKED")
+	case types.SQLServer:
+		return s.q("SELECT TOP (" + limit + ") {id} FROM {" + s.table + "} WITH (UPDLOCK, READPAST, ROWLOCK)" +
+			where + " ORDER BY {id}")
+	}
+	// SQLite serializes writers, the claim is conditional all the same
+	return s.q("SELECT {id} FROM {" + s.table + "}" + where + " ORDER BY {id} LIMIT " + limit)
+}
+
+// keep moves the due time of the rows of kind taken by this consumer.
+func (s *databaseStore[T]) keep(ctxt context.Context, kind Kind, now time.Time) {
+	if err := s.session(ctxt).Exec(s.q("UPDATE {"+s.table+"} SET {due_at} = ? "+
+		"WHERE {kind} = ? AND {dead} = 0 AND {consumer} = ?"),
+		now.Add(s.visibility).UnixMilli(), kind, s.consumer).Error; err != nil {
+		logger.Error("Unable to keep elements", "error", err, "kind", kind, "consumer", s.consumer)
+	}
+}
+
+func (s *databaseStore[T]) ack(ctxt context.Context, kind Kind, d *delivery[T]) error {
+	return s.session(ctxt).Exec(s.q("DELETE FROM {"+s.table+"} WHERE {id} = ? AND {consumer} = ?"),
+		row(d), s.consumer).Error
+}
+
+// row returns the id of the row of d.
+func row[T any](d *delivery[T]) int64 {
+	id, _ := strconv.ParseInt(d.receipt, 10, 64)
+	return id
+}
+
+func (s *databaseStore[T]) delay(ctxt context.Context, kind Kind, d *delivery[T], env *Envelope[T], due time.Time) error {
+	return s.put(ctxt, kind, d, env, 0, due)
+}
+
+func (s *databaseStore[T]) bury(ctxt context.Context, kind Kind, d *delivery[T], env *Envelope[T]) error {
+	return s.put(ctxt, kind, d, env, 1, env.FailedAt)
+}
+
+// put stores env in the row of d, in a new row when d is nil. Rows taken over
+// by another consumer meanwhile are left to it.
+func (s *databaseStore[T]) put(ctxt context.Context, kind Kind, d *delivery[T], env *Envelope[T], dead int,
+	due time.Time) error {
+	v, err := encode(env)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return s.session(ctxt).Exec(s.q("INSERT INTO {"+s.table+"} "+
+			"({kind}, {dead}, {due_at}, {envelope_id}, {envelope}) VALUES (?, ?, ?, ?, ?)"),
+			kind, dead, due.UnixMilli(), env.ID, v).Error
+	}
+	return s.session(ctxt).Exec(s.q("UPDATE {"+s.table+"} SET {dead} = ?, {due_at} = ?, {consumer} = NULL, "+
+		"{envelope_id} = ?, {envelope} = ? WHERE {id} = ? AND {consumer} = ?"),
+		dead, due.UnixMilli(), env.ID, v, row(d), s.consumer).Error
+}
+
+func (s *databaseStore[T]) depths(ctxt context.Context, kind Kind, now time.Time) (int64, int64, error) {
+	var ready, retrying int64
+	db := s.session(ctxt)
+	if err := db.Raw(s.q("SELECT COUNT(*) FROM {"+s.table+"} WHERE {kind} = ? AND {dead} = 0 AND {due_at} <= ?"),
+		kind, now.UnixMilli()).Scan(&ready).Error; err != nil {
+		return 0, 0, err
+	}
+	err := db.Raw(s.q("SELECT COUNT(*) FROM {"+s.table+"} "+
+		"WHERE {kind} = ? AND {dead} = 0 AND {due_at} > ? AND {consumer} IS NULL"),
+		kind, now.UnixMilli()).Scan(&retrying).Error
+	return ready, retrying, err
+}
+
+// release makes the rows of kind taken by this consumer due again and forgets
+// it.
+func (s *databaseStore[T]) release(ctxt context.Context, kind Kind, now time.Time) {
+	db := s.session(ctxt)
+	if err := db.Exec(s.q("UPDATE {"+s.table+"} SET {due_at} = ?, {consumer} = NULL "+
+		"WHERE {kind} = ? AND {dead} = 0 AND {consumer} = ?"),
+		now.UnixMilli(), kind, s.consumer).Error; err != nil {
+		logger.Error("Unable to release elements", "error", err, "kind", kind, "consumer", s.consumer)
+	}
+	if err := db.Exec(s.q("DELETE FROM {"+s.consumers+"} WHERE {kind} = ? AND {consumer} = ?"),
+		kind, s.consumer).Error; err != nil {
+		logger.Error("Unable to remove ha consumer", "error", err, "kind", kind, "consumer", s.consumer)
+	}
+}
+
+func (s *databaseStore[T]) owner() string {
+	return s.consumer
+}
+
+// members beats and forgets the consumers of kind which stopped beating.
+func (s *databaseStore[T]) members(ctxt context.Context, kind Kind, now time.Time) ([]string, error) {
+	db := s.session(ctxt)
+	r := db.Exec(s.q("UPDATE {"+s.consumers+"} SET {beat_at} = ? WHERE {kind} = ? AND {consumer} = ?"),
+		now.UnixMilli(), kind, s.consumer)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+	if r.RowsAffected == 0 {
+		if err := db.Exec(s.q("INSERT INTO {"+s.consumers+"} ({kind}, {consumer}, {beat_at}) VALUES (?, ?, ?)"),
+			kind, s.consumer, now.UnixMilli()).Error; err != nil {
+			return nil, err
+		}
+	}
+	expired := now.Add(-s.visibility).UnixMilli()
+	if err := db.Exec(s.q("DELETE FROM {"+s.consumers+"} WHERE {kind} = ? AND {beat_at} <= ?"),
+		kind, expired).Error; err != nil {
+		return nil, err
+	}
+	var members []string
+	err := db.Raw(s.q("SELECT {consumer} FROM {"+s.consumers+"} WHERE {kind} = ?"), kind).Scan(&members).Error
+	return members, err
+}
+
+func (s *databaseStore[T]) deadLetters(ctxt context.Context, kind Kind) ([]Envelope[T], error) {
+	rows, err := s.letters(ctxt, kind, nil)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]Envelope[T], 0, len(rows))
+	for id, v := range rows {
+		env, err := decode[T](v)
+		if err != nil {
+			logger.Error("Unable to deserialize dead letter", "error", err, "kind", kind, "id", id)
+			continue
+		}
+		r = append(r, *env)
+	}
+	return r, nil
+}
+
+// letters returns the dead letters of kind with the envelope ids, all of them
+// when ids is empty, by row id.
+func (s *databaseStore[T]) letters(ctxt context.Context, kind Kind, ids []string) (map[int64]string, error) {
+	statement := "SELECT {id}, {envelope} FROM {" + s.table + "} WHERE {kind} = ? AND {dead} = 1"
+	args := []any{kind}
+	if len(ids) > 0 {
+		statement += " AND {envelope_id} IN ?"
+		args = append(args, ids)
+	}
+	rows, err := s.session(ctxt).Raw(s.q(statement), args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	r := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var v sql.NullString
+		if err := rows.Scan(&id, &v); err != nil {
+			return nil, err
+		}
+		r[id] = v.String
+	}
+	return r, rows.Err()
+}
+
+func (s *databaseStore[T]) replay(ctxt context.Context, kind Kind, ids []string, now time.Time) (int, error) {
+	rows, err := s.letters(ctxt, kind, ids)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for id, v := range rows {
+		env, err := decode[T](v)
+		if err != nil {
+			logger.Error("Unable to deserialize dead letter, dropping it", "error", err, "kind", kind, "id", id)
+			if err := s.session(ctxt).Exec(s.q("DELETE FROM {"+s.table+"} WHERE {id} = ? AND {dead} = 1"),
+				id).Error; err != nil {
+				return n, err
+			}
+			continue
+		}
+		env.Attempts = 0
+		buf, err := encode(env)
+		if err != nil {
+			return n, err
+		}
+		// Letters replayed by another replica meanwhile are no longer dead
+		r := s.session(ctxt).Exec(s.q("UPDATE {"+s.table+"} SET {dead} = 0, {due_at} = ?, {consumer} = NULL, "+
+			"{envelope} = ? WHERE {id} = ? AND {dead} = 1"), now.UnixMilli(), buf, id)
+		if r.Error != nil {
+			return n, r.Error
+		}
+		n += int(r.RowsAffected)
+	}
+	return n, nil
+}
+
+func (s *databaseStore[T]) discard(ctxt context.Context, kind Kind, ids []string) (int, error) {
+	statement := "DELETE FROM {" + s.table + "} WHERE {kind} = ? AND {dead} = 1"
+	args := []any{kind}
+	if len(ids) > 0 {
+		statement += " AND {envelope_id} IN ?"
+		args = append(args, ids)
+	}
+	r := s.session(ctxt).Exec(s.q(statement), args...)
+	return int(r.RowsAffected), r.Error
+}
+
+func (s *databaseStore[T]) kinds(ctxt context.Context) ([]Kind, error) {
+	var kinds []string
+	if err := s.session(ctxt).Raw(s.q("SELECT DISTINCT {kind} FROM {" + s.table + "}")).Scan(&kinds).Error; err != nil {
+		return nil, err
+	}
+	r := make([]Kind, 0, len(kinds))
+	for _, k := range kinds {
+		r = append(r, Kind(k))
+	}
+	return r, nil
+}
+
+// queued returns the condition selecting the rows of the Ready or Retry queue
+// of a kind at a time.
+func (s *databaseStore[T]) queued(queue Queue) string {
+	if queue == Retry {
+		return s.q("{kind} = ? AND {dead} = 0 AND {consumer} IS NULL AND {due_at} > ?")
+	}
+	return s.q("{kind} = ? AND {dead} = 0 AND {due_at} <= ?")
+}
+
+func (s *databaseStore[T]) stats(ctxt context.Context, kind Kind, now time.Time) (Stats, error) {
+	r := Stats{Kind: kind}
+	var err error
+	if r.Ready, r.Retry, err = s.depths(ctxt, kind, now); err != nil {
+		return r, err
+	}
+	db := s.session(ctxt)
+	if err := db.Raw(s.q("SELECT COUNT(*) FROM {"+s.table+"} "+
+		"WHERE {kind} = ? AND {dead} = 0 AND {consumer} IS NOT NULL AND {due_at} > ?"),
+		kind, now.UnixMilli()).Scan(&r.InFlight).Error; err != nil {
+		return r, err
+	}
+	err = db.Raw(s.q("SELECT COUNT(*) FROM {"+s.table+"} WHERE {kind} = ? AND {dead} = 1"), kind).Scan(&r.Dead).Error
+	return r, err
+}
+
+func (s *databaseStore[T]) peek(ctxt context.Context, kind Kind, queue Queue, offset, limit int,
+	now time.Time) ([]Element[T], error) {
+	r := []Element[T]{}
+	if limit == 0 {
+		return r, nil
+	}
+	order := lang.Ternary(queue == Ready, "{id}", "{due_at}, {id}")
+	where, args := s.queued(queue), []any{kind, now.UnixMilli()}
+	if queue == Dead {
+		// Dead letters are due when they failed
+		where, args = s.q("{kind} = ? AND {dead} = 1"), []any{kind}
+	}
+	rows, err := s.session(ctxt).Table(s.table).Select(s.q("{due_at}, {envelope}")).
+		Where(where, args...).Order(s.q(order)).Offset(offset).Limit(limit).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var due int64
+		var v string
+		if err := rows.Scan(&due, &v); err != nil {
+			return nil, err
+		}
+		env, err := decode[T](v)
+		if err != nil {
+			continue
+		}
+		e := Element[T]{Envelope: *env}
+		if queue == Retry {
+			at := time.UnixMilli(due)
+			e.Due = &at
+		}
+		r = append(r, e)
+	}
+	return r, rows.Err()
+}
+
+func (s *databaseStore[T]) advance(ctxt context.Context, kind Kind, ids []string, now time.Time) (int, error) {
+	statement := "UPDATE {" + s.table + "} SET {due_at} = ? WHERE " + s.queued(Retry)
+	args := []any{now.UnixMilli(), kind, now.UnixMilli()}
+	if len(ids) > 0 {
+		statement += " AND {envelope_id} IN ?"
+		args = append(args, ids)
+	}
+	r := s.session(ctxt).Exec(s.q(statement), args...)
+	return int(r.RowsAffected), r.Error
+}
+
+func (s *databaseStore[T]) remove(ctxt context.Context, kind Kind, queue Queue, ids []string,
+	now time.Time) (int, error) {
+	statement := "DELETE FROM {" + s.table + "} WHERE " + s.queued(queue)
+	args := []any{kind, now.UnixMilli()}
+	if len(ids) > 0 {
+		statement += " AND {envelope_id} IN ?"
+		args = append(args, ids)
+	}
+	r := s.session(ctxt).Exec(s.q(statement), args...)
+	return int(r.RowsAffected), r.Error
+}
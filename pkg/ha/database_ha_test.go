@@ -0,0 +1,256 @@
+package ha
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/gantries/knife/pkg/orm"
+	"github.com/gantries/knife/pkg/orm/ormtest"
+	"github.com/gantries/knife/pkg/synch"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDatabase(t *testing.T) *orm.Database {
+	return ormtest.NewSQLite(t)
+}
+
+func newDatabaseTestExecutor(t *testing.T, db *orm.Database, props *Properties) (*executor[string], *clock) {
+	s, err := withDatabase[string](context.Background(), db, props)
+	assert.Nil(t, err)
+	c := &clock{at: time.UnixMilli(1_700_000_000_000)}
+	e := newExecutor[string](s, props)
+	e.now = c.now
+	return e, c
+}
+
+func TestNew_Database(t *testing.T) {
+	ctxt := context.Background()
+	_, m := New[string](ctxt, &Properties{Type: Database})
+	assert.False(t, m.Fine(), "a database is required")
+
+	db := newTestDatabase(t)
+	e, m := New[string](ctxt, &Properties{Type: Database, DB: db})
+	assert.True(t, m.Fine())
+	assert.NotNil(t, e)
+	assert.True(t, db.DB().Migrator().HasTable("knife_ha"))
+	_, m = New[string](ctxt, &Properties{Type: Database, DB: db})
+	assert.True(t, m.Fine(), "existing tables are kept")
+
+	e, m = New[string](ctxt, &Properties{Type: Database, DB: db, Coordination: Single})
+	assert.True(t, m.Fine(), "drainers are elected through a database lock")
+	assert.IsType(t, &synch.DatabaseLock{}, e.(*executor[string]).lock)
+	assert.True(t, db.DB().Migrator().HasTable("knife_ha_lock"))
+	_, m = New[string](ctxt, &Properties{Type: Database, DB: db, Coordination: "nope"})
+	assert.False(t, m.Fine())
+}
+
+func TestDatabaseExecutor_Retry(t *testing.T) {
+	ctxt := context.Background()
+	kind := Kind("retry")
+	e, c := newDatabaseTestExecutor(t, newTestDatabase(t), &Properties{Retry: Policy{
+		MaxAttempts: 3,
+		Backoff:     synch.Backoff{Initial: time.Second, Multiplier: 2},
+	}})
+	var calls [][]string
+	healthy := false
+	w := register(e, kind, func(v ...string) *national.Message {
+		calls = append(calls, v)
+		if healthy {
+			return errors.Yes()
+		}
+		return errors.No(fmt.Errorf("unavailable"))
+	})
+
+	assert.True(t, e.Exec(ctxt, kind, "a", "b").Fine())
+	assert.Len(t, calls, 2)
+
+	e.drain(ctxt, w)
+	assert.Len(t, calls, 2, "not due before the first backoff passed")
+	assert.Equal(t, int64(1), w.retrying.Load())
+
+	c.at = c.at.Add(time.Second)
+	e.drain(ctxt, w)
+	assert.Equal(t, []string{"a", "b"}, calls[2])
+
+	c.at = c.at.Add(time.Second)
+	e.drain(ctxt, w)
+	assert.Len(t, calls, 3, "the second retry waits twice as long")
+
+	c.at = c.at.Add(time.Second)
+	e.drain(ctxt, w)
+	assert.Len(t, calls, 4)
+
+	dead, m := e.DeadLetters(ctxt, kind)
+	assert.True(t, m.Fine())
+	assert.Len(t, dead, 1)
+	assert.Equal(t, 3, dead[0].Attempts)
+	assert.Equal(t, []string{"a", "b"}, dead[0].Values)
+	assert.Contains(t, dead[0].Error, "unavailable")
+	assert.Equal(t, int64(0), w.retrying.Load())
+
+	healthy = true
+	replayed, m := e.Replay(ctxt, kind)
+	assert.True(t, m.Fine())
+	assert.Equal(t, 1, replayed)
+	e.drain(ctxt, w)
+	assert.Len(t, calls, 5)
+	dead, _ = e.DeadLetters(ctxt, kind)
+	assert.Empty(t, dead)
+	ready, retrying, err := e.store.depths(ctxt, kind, c.at)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), ready+retrying)
+}
+
+func TestDatabaseExecutor_Letters(t *testing.T) {
+	ctxt := context.Background()
+	e, c := newDatabaseTestExecutor(t, newTestDatabase(t), &Properties{
+		Policies: map[Kind]Policy{"once": {MaxAttempts: 1}},
+	})
+	register(e, "once", func(v ...string) *national.Message {
+		return errors.No(fmt.Errorf("unavailable"))
+	})
+	assert.True(t, e.Exec(ctxt, "once", "x").Fine())
+	c.at = c.at.Add(time.Millisecond)
+	assert.True(t, e.Exec(ctxt, "once", "y").Fine())
+	assert.True(t, e.Exec(ctxt, "once", "z").Fine())
+
+	dead, _ := e.DeadLetters(ctxt, "once")
+	assert.Len(t, dead, 3)
+	assert.Equal(t, []string{"x"}, dead[0].Values, "oldest first")
+	n, m := e.Discard(ctxt, "once", dead[0].ID, "missing")
+	assert.True(t, m.Fine())
+	assert.Equal(t, 1, n)
+	n, _ = e.Replay(ctxt, "once", dead[1].ID)
+	assert.Equal(t, 1, n)
+	n, _ = e.Replay(ctxt, "once", dead[1].ID)
+	assert.Equal(t, 0, n, "replayed letters are no longer dead")
+	_, m = e.Discard(ctxt, "once")
+	assert.False(t, m.Fine(), "ids are required")
+	n, _ = e.Admin().Purge(ctxt, "once", Dead)
+	assert.Equal(t, 1, n)
+	dead, _ = e.DeadLetters(ctxt, "once")
+	assert.Empty(t, dead)
+}
+
+func TestDatabaseExecutor_Claim(t *testing.T) {
+	ctxt := context.Background()
+	db := newTestDatabase(t)
+	props := &Properties{Retry: Policy{MaxAttempts: 1, Batch: 10}, Visibility: time.Minute}
+	crashed, c := newDatabaseTestExecutor(t, db, props)
+	alive, _ := newDatabaseTestExecutor(t, db, props)
+	alive.now = c.now
+	var called []string
+	w := register(alive, "claim", func(v ...string) *national.Message {
+		called = append(called, v...)
+		return errors.Yes()
+	})
+	for _, v := range []string{"a", "b"} {
+		env := &Envelope[string]{ID: v, Attempts: 1, Values: []string{v}}
+		assert.Nil(t, crashed.store.delay(ctxt, "claim", nil, env, c.at))
+	}
+	assert.Nil(t, crashed.store.delay(ctxt, "claim", nil, &Envelope[string]{ID: "bad"}, c.at))
+	assert.Nil(t, db.DB().Exec(`UPDATE "knife_ha" SET "envelope" = 'nope' WHERE "envelope_id" = 'bad'`).Error)
+
+	// A consumer taking a batch and crashing before calling it back
+	batch, err := crashed.store.take(ctxt, "claim", 10, c.at)
+	assert.Nil(t, err)
+	assert.Len(t, batch, 2, "undecodable elements are dropped")
+	assert.Nil(t, crashed.store.delay(ctxt, "claim", nil, &Envelope[string]{ID: "c", Values: []string{"c"}}, c.at))
+
+	alive.drain(ctxt, w)
+	assert.Equal(t, []string{"c"}, called, "taken elements are invisible until the consumer times out")
+	c.at = c.at.Add(time.Minute / 2)
+	crashed.store.keep(ctxt, "claim", c.at)
+	c.at = c.at.Add(time.Minute / 2)
+	alive.drain(ctxt, w)
+	assert.Equal(t, []string{"c"}, called, "kept elements stay invisible")
+
+	c.at = c.at.Add(time.Minute)
+	alive.drain(ctxt, w)
+	assert.Equal(t, []string{"c", "a", "b"}, called)
+	assert.Nil(t, crashed.store.ack(ctxt, "claim", &batch[0]), "acknowledging taken over elements is harmless")
+	ready, retrying, _ := alive.store.depths(ctxt, "claim", c.at)
+	assert.Equal(t, int64(0), ready+retrying)
+}
+
+func TestDatabaseExecutor_Stop(t *testing.T) {
+	ctxt := context.Background()
+	db := newTestDatabase(t)
+	props := &Properties{Retry: Policy{Batch: 10}}
+	e, _ := newDatabaseTestExecutor(t, db, props)
+	e.now = time.Now
+	assert.Nil(t, e.store.delay(ctxt, "stop", nil, &Envelope[string]{ID: "a", Values: []string{"a"}}, time.Now()))
+	started, release := make(chan string), make(chan struct{})
+	h, m := e.Register(ctxt, "stop", time.Millisecond, func(v ...string) *national.Message {
+		started <- v[0]
+		<-release
+		return errors.No(fmt.Errorf("unavailable"))
+	})
+	assert.True(t, m.Fine())
+	assert.Equal(t, "a", <-started)
+	timeout, cancel := context.WithTimeout(ctxt, 10*time.Millisecond)
+	defer cancel()
+	assert.False(t, h.Stop(timeout).Fine())
+	close(release)
+	<-h.Done()
+
+	other, _ := newDatabaseTestExecutor(t, db, props)
+	other.now = time.Now
+	batch, err := other.store.take(ctxt, "stop", 10, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, batch, 1, "stored for a retry")
+	assert.Equal(t, 1, batch[0].env.Attempts)
+}
+
+func TestDatabaseExecutor_ExecAt(t *testing.T) {
+	ctxt := context.Background()
+	e, c := newDatabaseTestExecutor(t, newTestDatabase(t), &Properties{Retry: Policy{MaxAttempts: 1}})
+	var called []string
+	w := register(e, "later", func(v ...string) *national.Message {
+		called = append(called, v...)
+		return errors.No(fmt.Errorf("unavailable"))
+	})
+	assert.True(t, e.ExecAfter(ctxt, "later", time.Minute, "a").Fine())
+	e.drain(ctxt, w)
+	assert.Empty(t, called)
+	assert.Equal(t, int64(1), w.retrying.Load())
+
+	c.at = c.at.Add(time.Minute)
+	e.drain(ctxt, w)
+	assert.Equal(t, []string{"a"}, called)
+	dead, _ := e.DeadLetters(ctxt, "later")
+	assert.Len(t, dead, 1)
+	assert.Equal(t, 1, dead[0].Attempts, "the scheduled call is the first attempt")
+}
+
+func TestDatabaseExecutor_Members(t *testing.T) {
+	ctxt := context.Background()
+	db := newTestDatabase(t)
+	props := &Properties{Visibility: time.Minute}
+	a, c := newDatabaseTestExecutor(t, db, props)
+	b, _ := newDatabaseTestExecutor(t, db, props)
+	assert.True(t, db.DB().Migrator().HasTable("knife_ha_consumer"))
+
+	members, err := a.store.members(ctxt, "members", c.at)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{a.store.owner()}, members)
+	members, _ = b.store.members(ctxt, "members", c.at)
+	assert.ElementsMatch(t, []string{a.store.owner(), b.store.owner()}, members)
+	members, _ = b.store.members(ctxt, "other", c.at)
+	assert.Equal(t, []string{b.store.owner()}, members, "members are per kind")
+
+	c.at = c.at.Add(time.Minute / 2)
+	members, _ = a.store.members(ctxt, "members", c.at)
+	assert.Len(t, members, 2)
+	c.at = c.at.Add(time.Minute / 2)
+	members, _ = a.store.members(ctxt, "members", c.at)
+	assert.Equal(t, []string{a.store.owner()}, members, "b stopped beating")
+
+	a.store.release(ctxt, "members", c.at)
+	members, _ = b.store.members(ctxt, "members", c.at)
+	assert.Equal(t, []string{b.store.owner()}, members)
+}
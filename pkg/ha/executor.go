@@ -0,0 +1,517 @@
+package ha
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/lang"
+	"github.com/gantries/knife/pkg/lists"
+	"github.com/gantries/knife/pkg/log"
+	"github.com/gantries/knife/pkg/maps"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/gantries/knife/pkg/serde"
+	"github.com/gantries/knife/pkg/synch"
+	"github.com/gantries/knife/pkg/tel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/sync/errgroup"
+)
+
+var logger = log.New("knife/ha")
+
+// lockPrefix prefixes the lock sources of coordinated kinds.
+const lockPrefix = "ha/"
+
+// store persists the envelopes of the kinds of an executor. Envelopes taken by
+// a consumer stay invisible to the others until they are settled, or until the
+// consumer stopped keeping them for the visibility timeout. Envelopes are
+// delivered at least once that way.
+type store[T any] interface {
+	// prepare runs before every drain of kind.
+	prepare(ctxt context.Context, kind Kind, now time.Time)
+	// take takes up to n envelopes of kind which are due, the ones taken
+	// before a failure are returned along with it.
+	take(ctxt context.Context, kind Kind, n int, now time.Time) ([]delivery[T], error)
+	// keep keeps the envelopes of kind taken by this consumer invisible.
+	keep(ctxt context.Context, kind Kind, now time.Time)
+	// ack removes a delivered envelope.
+	ack(ctxt context.Context, kind Kind, d *delivery[T]) error
+	// delay stores env to be taken at due, in place of d when given.
+	delay(ctxt context.Context, kind Kind, d *delivery[T], env *Envelope[T], due time.Time) error
+	// bury stores env as dead letter, in place of d when given.
+	bury(ctxt context.Context, kind Kind, d *delivery[T], env *Envelope[T]) error
+	// depths returns how many envelopes of kind are ready and how many wait
+	// for a retry.
+	depths(ctxt context.Context, kind Kind, now time.Time) (ready, retrying int64, err error)
+	// release makes the envelopes of kind taken by this consumer visible
+	// again, it runs once kind is unregistered.
+	release(ctxt context.Context, kind Kind, now time.Time)
+	deadLetters(ctxt context.Context, kind Kind) ([]Envelope[T], error)
+	// replay and discard take the dead letters with ids, all of them when ids
+	// is empty, letters taken by another replica meanwhile are skipped.
+	replay(ctxt context.Context, kind Kind, ids []string, now time.Time) (int, error)
+	discard(ctxt context.Context, kind Kind, ids []string) (int, error)
+	// kinds returns the kinds which were drained or stored envelopes.
+	kinds(ctxt context.Context) ([]Kind, error)
+	stats(ctxt context.Context, kind Kind, now time.Time) (Stats, error)
+	// peek returns up to limit envelopes of queue from offset, in the order
+	// they are taken, dead letters oldest first.
+	peek(ctxt context.Context, kind Kind, queue Queue, offset, limit int, now time.Time) ([]Element[T], error)
+	// advance makes the retries with ids due, all of them when ids is empty.
+	advance(ctxt context.Context, kind Kind, ids []string, now time.Time) (int, error)
+	// remove drops the envelopes of the Ready or Retry queue with ids, all of
+	// them when ids is empty.
+	remove(ctxt context.Context, kind Kind, queue Queue, ids []string, now time.Time) (int, error)
+	// owner identifies this consumer to the other ones.
+	owner() string
+	// members beats for kind and returns the consumers of kind which beat
+	// within the visibility timeout, this one included.
+	members(ctxt context.Context, kind Kind, now time.Time) ([]string, error)
+}
+
+// delivery is an envelope taken from a store, the receipt identifies it to
+// the store.
+type delivery[T any] struct {
+	env     *Envelope[T]
+	receipt string
+}
+
+// executor calls back the registered kinds and keeps their failures in a
+// store. The queue depths of every kind are exported to knife.ha.depth, the
+// elements called back are counted by knife.ha.processed with their result
+// and the callback durations are recorded in milliseconds to
+// knife.ha.duration. Coordinated kinds are drained by the replica holding
+// their lock only.
+type executor[T any] struct {
+	store     store[T]
+	props     Properties
+	lock      synch.Lock
+	mutex     sync.RWMutex
+	registry  maps.Map[Kind, *worker[T]]
+	now       func() time.Time
+	depth     tel.SimpleGauge
+	processed tel.SimpleCounter
+	duration  tel.SimpleHistogram
+}
+
+func newExecutor[T any](s store[T], props *Properties) *executor[T] {
+	return &executor[T]{
+		store:     s,
+		props:     *props,
+		lock:      props.Lock,
+		registry:  maps.Map[Kind, *worker[T]]{},
+		now:       time.Now,
+		depth:     tel.Gauge("knife.ha.depth"),
+		processed: tel.Counter("knife.ha.processed"),
+		duration:  tel.Histogram("knife.ha.duration"),
+	}
+}
+
+// worker drains the queue of a registered kind, the depths are the ones seen
+// by the last drain. Leading tells whether it held the lock of a coordinated
+// kind at the last tick.
+type worker[T any] struct {
+	kind          Kind
+	cb            func(v ...T) *national.Message
+	policy        Policy
+	leading       bool
+	queued        atomic.Int64
+	retrying      atomic.Int64
+	registrations []metric.Registration
+	cancel        context.CancelFunc
+	done          chan struct{}
+}
+
+func (w *worker[T]) Kind() Kind {
+	return w.kind
+}
+
+func (w *worker[T]) Stop(ctx context.Context) *national.Message {
+	w.cancel()
+	select {
+	case <-w.done:
+		return errors.Yes()
+	case <-ctx.Done():
+		return errors.No(ctx.Err())
+	}
+}
+
+func (w *worker[T]) Done() <-chan struct{} {
+	return w.done
+}
+
+func (e *executor[T]) Register(ctxt context.Context, kind Kind, interval time.Duration,
+	cb func(v ...T) *national.Message) (Handle, *national.Message) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if _, ok := e.registry[kind]; ok {
+		return nil, errors.OverwriteIsForbiddenError.Msg("target", kind, "type", "ha-registry")
+	}
+	ctxt, cancel := context.WithCancel(ctxt)
+	w := &worker[T]{kind: kind, cb: cb, policy: e.props.policy(kind), cancel: cancel, done: make(chan struct{})}
+	e.registry[kind] = w
+	e.observe(w)
+	go e.run(ctxt, w, interval)
+	return w, errors.Yes()
+}
+
+func (e *executor[T]) Unregister(ctxt context.Context, kind Kind) *national.Message {
+	e.mutex.RLock()
+	w, ok := e.registry[kind]
+	e.mutex.RUnlock()
+	if !ok {
+		return errors.NotFoundError.Build("type", "ha-callback", "value", kind)
+	}
+	return w.Stop(ctxt)
+}
+
+// run drains w every interval until ctxt is done, a drain in progress is not
+// interrupted. The consumer beats in between, so that it stays a member of
+// the kind when the interval is longer than the visibility timeout.
+func (e *executor[T]) run(ctxt context.Context, w *worker[T], interval time.Duration) {
+	defer close(w.done)
+	defer e.remove(w)
+	logger.Info("Ha worker start", "kind", w.kind, "interval", interval)
+	defer e.keepAlive(context.WithoutCancel(ctxt), w.kind)()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctxt.Done():
+			logger.Info("Ha worker stop", "kind", w.kind)
+			return
+		case <-ticker.C:
+			if e.active(ctxt, w) {
+				e.drain(ctxt, w)
+			}
+		}
+	}
+}
+
+// active tells whether w drains its kind at this tick. Every replica drains
+// shared kinds, coordinated ones are drained by the replica holding their
+// lock. With Partition only the replica the kind is assigned to competes for
+// the lock, the others resign it.
+func (e *executor[T]) active(ctxt context.Context, w *worker[T]) bool {
+	if !e.props.coordinated() {
+		return true
+	}
+	if e.props.Coordination == Partition {
+		members, err := e.store.members(ctxt, w.kind, e.now())
+		if err != nil {
+			logger.Error("Unable to list ha members", "error", err, "kind", w.kind)
+			return false
+		}
+		if assignee(w.kind, members) != e.store.owner() {
+			e.resign(ctxt, w)
+			return false
+		}
+	}
+	return e.lead(ctxt, w)
+}
+
+// lead elects w, or extends the lease of a leading w, and tells whether it
+// leads.
+func (e *executor[T]) lead(ctxt context.Context, w *worker[T]) bool {
+	owner := e.store.owner()
+	ok, err := e.elect(ctxt, w, lockPrefix+string(w.kind), owner)
+	if err != nil {
+		// The lease outlives a transient failure, the next tick tells
+		logger.Error("Unable to lock ha kind", "error", err, "kind", w.kind, "consumer", owner)
+		return false
+	}
+	if ok != w.leading {
+		if ok {
+			logger.Info("Ha drainer elected", "kind", w.kind, "consumer", owner)
+		} else {
+			logger.Warn("Ha drainer lost its lease", "kind", w.kind, "consumer", owner)
+		}
+		w.leading = ok
+	}
+	return ok
+}
+
+// elect locks the kind of w, a leading worker extends its lease instead so
+// that the count of reentrant locks stays at one. New checked that the lock
+// of coordinated kinds is a synch.Renewer.
+func (e *executor[T]) elect(ctxt context.Context, w *worker[T], source, owner string) (bool, error) {
+	if !w.leading {
+		return e.lock.Lock(ctxt, source, owner, e.props.lease())
+	}
+	return e.lock.(synch.Renewer).Renew(ctxt, source, owner, e.props.lease())
+}
+
+// resign unlocks the kind of w when it leads.
+func (e *executor[T]) resign(ctxt context.Context, w *worker[T]) {
+	if !w.leading {
+		return
+	}
+	w.leading = false
+	if _, err := e.lock.Unlock(ctxt, lockPrefix+string(w.kind), e.store.owner()); err != nil {
+		logger.Error("Unable to unlock ha kind", "error", err, "kind", w.kind)
+		return
+	}
+	logger.Info("Ha drainer resigned", "kind", w.kind, "consumer", e.store.owner())
+}
+
+// assignee returns the member kind is assigned to by rendezvous hashing, so
+// that only the kinds of members joining or leaving move.
+func assignee(kind Kind, members []string) string {
+	var r string
+	var top uint64
+	for _, member := range members {
+		h := fnv.New64a()
+		h.Write([]byte(member + "/" + string(kind)))
+		if score := mix(h.Sum64()); len(r) == 0 || score > top || score == top && member < r {
+			r, top = member, score
+		}
+	}
+	return r
+}
+
+// mix is the finalizer of splitmix64, fnv alone scores members differing in
+// a single byte alike.
+func mix(h uint64) uint64 {
+	h = (h ^ h>>30) * 0xbf58476d1ce4e5b9
+	h = (h ^ h>>27) * 0x94d049bb133111eb
+	return h ^ h>>31
+}
+
+func (e *executor[T]) remove(w *worker[T]) {
+	e.mutex.Lock()
+	if e.registry[w.kind] == w {
+		delete(e.registry, w.kind)
+	}
+	e.mutex.Unlock()
+	e.resign(context.Background(), w)
+	e.store.release(context.Background(), w.kind, e.now())
+	for _, r := range w.registrations {
+		if err := r.Unregister(); err != nil {
+			logger.Error("Unable to stop observing ha queue", "error", err, "kind", w.kind)
+		}
+	}
+	w.cancel()
+}
+
+func (e *executor[T]) observe(w *worker[T]) {
+	depths := map[string]*atomic.Int64{"ready": &w.queued, "retry": &w.retrying}
+	for queue, depth := range depths {
+		r, err := e.depth.Observe(func(ctx context.Context) int64 {
+			return depth.Load()
+		}, metric.WithAttributes(attribute.String("ha.kind", string(w.kind)), attribute.String("ha.queue", queue)))
+		if err != nil {
+			logger.Error("Unable to observe ha queue", "error", err, "kind", w.kind, "queue", queue)
+			continue
+		}
+		w.registrations = append(w.registrations, r)
+	}
+}
+
+// drain calls back the due envelopes of w batch by batch until there are no
+// more or ctxt is done. The batch in progress is finished even when ctxt is
+// done meanwhile. Coordinated kinds extend their lease between batches and
+// stop once it is lost, so a long drain does not overlap another drainer.
+func (e *executor[T]) drain(ctxt context.Context, w *worker[T]) {
+	detached := context.WithoutCancel(ctxt)
+	e.store.prepare(detached, w.kind, e.now())
+	for first := true; ctxt.Err() == nil; first = false {
+		if !first && e.props.coordinated() && !e.lead(detached, w) {
+			return
+		}
+		batch, err := e.store.take(detached, w.kind, max(1, w.policy.Batch), e.now())
+		if err != nil {
+			logger.Error("Unable to take elements", "error", err, "kind", w.kind)
+		}
+		if ready, retrying, err := e.store.depths(detached, w.kind, e.now()); err == nil {
+			w.queued.Store(ready)
+			w.retrying.Store(retrying)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		g := errgroup.Group{}
+		g.SetLimit(max(1, w.policy.Concurrency))
+		for i := range batch {
+			g.Go(func() error {
+				e.handle(detached, w, &batch[i])
+				return nil
+			})
+		}
+		_ = g.Wait()
+	}
+}
+
+// keepAlive keeps the envelopes of kind taken by this consumer until the
+// returned function is called, so that batches taking longer than the
+// visibility timeout are not taken by others and the other members see this
+// one between drains.
+func (e *executor[T]) keepAlive(ctxt context.Context, kind Kind) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(e.props.visibility() / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				e.store.keep(ctxt, kind, e.now())
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// handle calls w back with the envelope of d and settles it.
+func (e *executor[T]) handle(ctxt context.Context, w *worker[T], d *delivery[T]) {
+	env := d.env
+	env.Attempts++
+	start := time.Now()
+	m := w.cb(env.Values...)
+	kind := attribute.String("ha.kind", string(w.kind))
+	e.duration.Record(ctxt, float64(time.Since(start))/float64(time.Millisecond), metric.WithAttributes(kind))
+	result := "success"
+	if m.Fine() {
+		logger.Debug("Ha result", "result", *m.Body().Raw(), "kind", w.kind, "id", env.ID, "attempts", env.Attempts)
+		if err := e.store.ack(ctxt, w.kind, d); err != nil {
+			logger.Error("Unable to acknowledge element", "error", err, "kind", w.kind, "id", env.ID)
+		}
+	} else {
+		result = "retry"
+		if w.policy.exhausted(env.Attempts) {
+			result = "dead"
+		}
+		if !e.fail(ctxt, w.kind, d, env, m).Fine() {
+			// Kept by the store, it is delivered again later
+			return
+		}
+	}
+	e.processed.Add(ctxt, int64(len(env.Values)), metric.WithAttributes(kind, attribute.String("ha.result", result)))
+}
+
+// fail schedules the next attempt of env, or moves it to the dead letters when
+// the policy of kind allows no more. d is nil for envelopes not stored yet.
+func (e *executor[T]) fail(ctxt context.Context, kind Kind, d *delivery[T], env *Envelope[T],
+	m *national.Message) *national.Message {
+	policy := e.props.policy(kind)
+	env.Error = m.E(nil).Error()
+	env.FailedAt = e.now()
+	if policy.exhausted(env.Attempts) {
+		logger.Warn("Ha element exhausted its attempts", "kind", kind, "id", env.ID, "attempts", env.Attempts,
+			"error", env.Error)
+		if err := e.store.bury(ctxt, kind, d, env); err != nil {
+			logger.Error("Unable to store dead letter", "error", err, "kind", kind, "id", env.ID)
+			return errors.No(err)
+		}
+		return errors.Yes()
+	}
+	due := env.FailedAt.Add(policy.Backoff.Delay(env.Attempts - 1))
+	if err := e.store.delay(ctxt, kind, d, env, due); err != nil {
+		logger.Error("Unable to schedule retry", "error", err, "kind", kind, "id", env.ID)
+		return errors.No(err)
+	}
+	return errors.Yes()
+}
+
+func (e *executor[T]) Exec(ctxt context.Context, kind Kind, va ...T) *national.Message {
+	e.mutex.RLock()
+	w, ok := e.registry[kind]
+	e.mutex.RUnlock()
+	if ok {
+		failed := lists.List[T]{}
+		var last *national.Message
+		for _, v := range va {
+			if m := w.cb(v); !m.Fine() {
+				failed.Add(v)
+				last = m
+			}
+		}
+		if len(failed) > 0 {
+			return e.fail(ctxt, kind, nil, &Envelope[T]{ID: lang.StringUUID(), Attempts: 1, Values: failed}, last)
+		}
+		return errors.Yes()
+	} else {
+		return errors.NotFoundError.Build("type", "ha-callback", "value", kind)
+	}
+}
+
+func (e *executor[T]) ExecAt(ctxt context.Context, kind Kind, at time.Time, va ...T) *national.Message {
+	e.mutex.RLock()
+	_, ok := e.registry[kind]
+	e.mutex.RUnlock()
+	if !ok {
+		return errors.NotFoundError.Build("type", "ha-callback", "value", kind)
+	}
+	if len(va) == 0 {
+		return errors.Yes()
+	}
+	env := &Envelope[T]{ID: lang.StringUUID(), Values: va}
+	if err := e.store.delay(ctxt, kind, nil, env, at); err != nil {
+		logger.Error("Unable to schedule element", "error", err, "kind", kind, "id", env.ID, "at", at)
+		return errors.No(err)
+	}
+	return errors.Yes()
+}
+
+func (e *executor[T]) ExecAfter(ctxt context.Context, kind Kind, delay time.Duration, va ...T) *national.Message {
+	return e.ExecAt(ctxt, kind, e.now().Add(delay), va...)
+}
+
+func (e *executor[T]) DeadLetters(ctxt context.Context, kind Kind) ([]Envelope[T], *national.Message) {
+	r, err := e.store.deadLetters(ctxt, kind)
+	if err != nil {
+		return nil, errors.No(err)
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		return r[i].FailedAt.Before(r[j].FailedAt)
+	})
+	return r, errors.Yes()
+}
+
+func (e *executor[T]) Replay(ctxt context.Context, kind Kind, ids ...string) (int, *national.Message) {
+	n, err := e.store.replay(ctxt, kind, ids, e.now())
+	if err != nil {
+		return n, errors.No(err)
+	}
+	return n, errors.Yes()
+}
+
+func (e *executor[T]) Discard(ctxt context.Context, kind Kind, ids ...string) (int, *national.Message) {
+	if len(ids) == 0 {
+		return 0, errors.MissingValueError.Build("value", "ids")
+	}
+	return e.discard(ctxt, kind, ids)
+}
+
+// discard drops the dead letters with ids, all of them when ids is empty.
+func (e *executor[T]) discard(ctxt context.Context, kind Kind, ids []string) (int, *national.Message) {
+	n, err := e.store.discard(ctxt, kind, ids)
+	if err != nil {
+		return n, errors.No(err)
+	}
+	return n, errors.Yes()
+}
+
+// decode reads an envelope, bare arrays queued before envelopes existed count
+// as failed once.
+func decode[T any](v string) (*Envelope[T], error) {
+	if serde.IsJSONArray(v) {
+		a, err := serde.DeserializeArray[T]([]byte(v))
+		if err != nil {
+			return nil, err
+		}
+		return &Envelope[T]{ID: lang.StringUUID(), Attempts: 1, Values: a}, nil
+	}
+	return serde.Deserialize[Envelope[T]]([]byte(v))
+}
+
+// encode writes env for a store.
+func encode[T any](env *Envelope[T]) (string, error) {
+	buf, err := serde.Serialize(env)
+	return string(buf), err
+}
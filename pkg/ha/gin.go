@@ -0,0 +1,138 @@
+package ha
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gantries/knife/pkg/auth"
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminScope is the id authorized by auth for the admin handlers, a hook may
+// authenticate it for identities which are not authenticated otherwise.
+const AdminScope = "ha-admin"
+
+// Mount serves admin on the path group of r, requests are authorized by auth
+// with hook first. The group is returned for further middlewares:
+//
+//	GET    /kinds                        stats of every kind
+//	GET    /kinds/:kind                  stats of kind
+//	GET    /kinds/:kind/:queue           elements, by offset and limit
+//	POST   /kinds/:kind/:queue/requeue   elements by id, all without id
+//	POST   /kinds/:kind/:queue/purge     all elements
+//	DELETE /kinds/:kind/:queue           elements by id
+//
+// Elements are chosen by the repeated id query parameter, changes answer the
+// count of elements changed.
+//
+// Being authenticated does not suffice, every route drops or replays elements
+// of all the replicas or exposes their values. Only the identities which hook
+// authenticated for AdminScope and the ones allow returns true for reach
+// them, others are answered 403. With a nil allow only the former do.
+func Mount[T any](r gin.IRouter, path string, admin Admin[T],
+	hook func(context.Context, *auth.Identity) *auth.Identity,
+	allow func(context.Context, *auth.Identity) bool) *gin.RouterGroup {
+	g := r.Group(path, authorize(hook, allow))
+	g.GET("/kinds", func(c *gin.Context) {
+		kinds, m := admin.Kinds(c.Request.Context())
+		if !m.Fine() {
+			abort(c, m)
+			return
+		}
+		stats := make([]Stats, 0, len(kinds))
+		for _, kind := range kinds {
+			s, m := admin.Stats(c.Request.Context(), kind)
+			if !m.Fine() {
+				abort(c, m)
+				return
+			}
+			stats = append(stats, s)
+		}
+		c.JSON(http.StatusOK, stats)
+	})
+	g.GET("/kinds/:kind", func(c *gin.Context) {
+		s, m := admin.Stats(c.Request.Context(), Kind(c.Param("kind")))
+		if !m.Fine() {
+			abort(c, m)
+			return
+		}
+		c.JSON(http.StatusOK, s)
+	})
+	g.GET("/kinds/:kind/:queue", func(c *gin.Context) {
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		elements, m := admin.Peek(c.Request.Context(), Kind(c.Param("kind")), Queue(c.Param("queue")), offset, limit)
+		if !m.Fine() {
+			abort(c, m)
+			return
+		}
+		c.JSON(http.StatusOK, elements)
+	})
+	g.POST("/kinds/:kind/:queue/requeue", func(c *gin.Context) {
+		n, m := admin.Requeue(c.Request.Context(), Kind(c.Param("kind")), Queue(c.Param("queue")), c.QueryArray("id")...)
+		count(c, n, m)
+	})
+	g.POST("/kinds/:kind/:queue/purge", func(c *gin.Context) {
+		n, m := admin.Purge(c.Request.Context(), Kind(c.Param("kind")), Queue(c.Param("queue")))
+		count(c, n, m)
+	})
+	g.DELETE("/kinds/:kind/:queue", func(c *gin.Context) {
+		n, m := admin.Delete(c.Request.Context(), Kind(c.Param("kind")), Queue(c.Param("queue")), c.QueryArray("id")...)
+		count(c, n, m)
+	})
+	return g
+}
+
+func authorize(hook func(context.Context, *auth.Identity) *auth.Identity,
+	allow func(context.Context, *auth.Identity) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.IsAuthorized(c.Request.Context(), AdminScope) {
+			c.Request = auth.PreAuthorize(c)
+			ctx, _, err := auth.Authorize(c.Request.Context(), AdminScope, hook)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			c.Request = c.Request.WithContext(ctx)
+		}
+		ctx := c.Request.Context()
+		i := auth.IdentityFromContext(ctx)
+		if !i.IsAuthenticated(AdminScope) && (allow == nil || !allow(ctx, i)) {
+			logger.Warn("Ha admin denied", "path", c.Request.URL.Path, "user", i.UserName)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": errors.Unauthorized.LocalE(national.Tr(ctx), nil).Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+func count(c *gin.Context, n int, m *national.Message) {
+	if !m.Fine() {
+		abort(c, m)
+		return
+	}
+	logger.Info("Ha admin change", "path", c.Request.URL.Path, "count", n, "user", user(c))
+	c.JSON(http.StatusOK, gin.H{"count": n})
+}
+
+func user(c *gin.Context) string {
+	if i := auth.IdentityFromContext(c.Request.Context()); i != nil {
+		return i.UserName
+	}
+	return ""
+}
+
+// abort answers the failure m localized, with 400 for invalid requests.
+func abort(c *gin.Context, m *national.Message) {
+	status := http.StatusInternalServerError
+	switch *m.Body() {
+	case errors.UnsupportedValueError, errors.MissingValueError:
+		status = http.StatusBadRequest
+	}
+	ctx := c.Request.Context()
+	tr := national.Tr(national.WithLanguage(ctx, c.Request, national.Language(ctx)))
+	c.AbortWithStatusJSON(status, gin.H{"error": m.LocalE(tr, nil).Error()})
+}
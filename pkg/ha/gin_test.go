@@ -0,0 +1,90 @@
+package ha
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gantries/knife/pkg/auth"
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctxt := context.Background()
+	e, _, _ := newTestExecutor(&Properties{Retry: Policy{MaxAttempts: 1}})
+	register(e, "mount", func(v ...string) *national.Message {
+		return errors.No(fmt.Errorf("unavailable"))
+	})
+	assert.True(t, e.Exec(ctxt, "mount", "a").Fine())
+	assert.True(t, e.ExecAfter(ctxt, "mount", 0, "b").Fine())
+
+	router := gin.New()
+	token := "secret"
+	Mount[string](router, "/admin/ha", e.Admin(), func(c context.Context, i *auth.Identity) *auth.Identity {
+		if auth.AuthorizationFromContext(c) == token {
+			return i.Authenticated(AdminScope)
+		}
+		return i
+	}, func(c context.Context, i *auth.Identity) bool {
+		return i.UserName == "admin"
+	})
+	userinfo := base64.StdEncoding.EncodeToString([]byte(`{"email":"a@b.c","name":"Admin","username":"admin"}`))
+	other := base64.StdEncoding.EncodeToString([]byte(`{"email":"d@b.c","name":"User","username":"user"}`))
+	serve := func(method, path, identity string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if len(identity) > 0 {
+			req.Header.Set(string(auth.HeaderIdentity), identity)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/admin/ha/kinds", "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/admin/ha/kinds", other).Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/admin/ha/kinds/mount/dead/purge", other).Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/admin/ha/kinds", token).Code, "authenticated by hook")
+
+	w := serve(http.MethodGet, "/admin/ha/kinds", userinfo)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var stats []Stats
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, []Stats{{Kind: "mount", Retry: 1, Dead: 1}}, stats)
+
+	w = serve(http.MethodGet, "/admin/ha/kinds/mount/dead?limit=5", userinfo)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var dead []Element[string]
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &dead))
+	assert.Len(t, dead, 1)
+	assert.Equal(t, []string{"a"}, dead[0].Values)
+	assert.Nil(t, dead[0].Due)
+
+	w = serve(http.MethodGet, "/admin/ha/kinds/mount/retry", userinfo)
+	var retries []Element[string]
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &retries))
+	assert.NotNil(t, retries[0].Due)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/admin/ha/kinds/mount/nope", userinfo).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodDelete, "/admin/ha/kinds/mount/dead", userinfo).Code)
+
+	w = serve(http.MethodPost, "/admin/ha/kinds/mount/retry/requeue?id="+retries[0].ID, userinfo)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"count":1}`, w.Body.String())
+	w = serve(http.MethodDelete, "/admin/ha/kinds/mount/dead?id="+dead[0].ID+"&id=missing", userinfo)
+	assert.JSONEq(t, `{"count":1}`, w.Body.String())
+	w = serve(http.MethodPost, "/admin/ha/kinds/mount/ready/purge", userinfo)
+	assert.JSONEq(t, `{"count":1}`, w.Body.String())
+
+	w = serve(http.MethodGet, "/admin/ha/kinds/mount", userinfo)
+	var s Stats
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &s))
+	assert.Equal(t, Stats{Kind: "mount"}, s)
+}
@@ -1,41 +1,178 @@
 // Package ha provides high-availability (HA) execution patterns.
 //
-// It supports cache-based HA executors that can register and execute
-// operations with periodic callbacks for health checking and recovery.
+// It supports cache-based and database-based HA executors that can register
+// and execute operations with periodic callbacks for health checking and
+// recovery.
 package ha
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/gantries/knife/pkg/cache"
 	"github.com/gantries/knife/pkg/errors"
 	"github.com/gantries/knife/pkg/national"
+	"github.com/gantries/knife/pkg/orm"
+	"github.com/gantries/knife/pkg/synch"
 )
 
 type Type string
 
 const (
-	Cache Type = "cache"
+	Cache    Type = "cache"
+	Database Type = "database"
+)
+
+// Coordination decides which replicas registering a kind drain it.
+type Coordination string
+
+const (
+	// Shared lets every replica drain every kind it registered.
+	Shared Coordination = "shared"
+	// Single elects one replica per kind through a lease on Properties.Lock,
+	// another one takes over once the lease of a dead holder expired.
+	Single Coordination = "single"
+	// Partition spreads the kinds over the replicas registering them, each
+	// kind is drained by a single replica as with Single.
+	Partition Coordination = "partition"
 )
 
 type Properties struct {
 	Type  Type             `yaml:"type" default:"redis"`
 	Cache cache.Properties `yaml:"cache"`
+	// Table keeps the elements of the Database type.
+	Table string `yaml:"table" default:"knife_ha"`
+	// DB is the database of the Database type.
+	DB *orm.Database `yaml:"-"`
+	// Retry is the policy of the kinds missing from Policies.
+	Retry    Policy          `yaml:"retry"`
+	Policies map[Kind]Policy `yaml:"policies"`
+	// Visibility is how long the elements taken by a consumer stay invisible
+	// to the others once it stopped keeping them.
+	Visibility   time.Duration `yaml:"visibility" default:"1m"`
+	Coordination Coordination  `yaml:"coordination" default:"shared"`
+	// Lease is how long a dead drainer keeps its kind with Single or
+	// Partition, it should exceed the interval of the kinds.
+	Lease time.Duration `yaml:"lease" default:"30s"`
+	// Lock elects the drainers, a RedisLock on Cache and a DatabaseLock on
+	// Database are used when missing. Leaders extend their lease without
+	// locking again, so the lock must be a synch.Renewer.
+	Lock synch.Lock `yaml:"-"`
+}
+
+func (p *Properties) coordinated() bool {
+	return p.Coordination == Single || p.Coordination == Partition
+}
+
+func (p *Properties) lease() time.Duration {
+	if p.Lease > 0 {
+		return p.Lease
+	}
+	return 30 * time.Second
+}
+
+func (p *Properties) table() string {
+	if len(p.Table) > 0 {
+		return p.Table
+	}
+	return "knife_ha"
+}
+
+func (p *Properties) visibility() time.Duration {
+	if p.Visibility > 0 {
+		return p.Visibility
+	}
+	return time.Minute
+}
+
+// Policy decides how the queue of a kind is drained and how often and when
+// failed elements are retried.
+type Policy struct {
+	// MaxAttempts counts the first call as well, elements failing that often
+	// are moved to the dead letters. Non-positive values retry forever.
+	MaxAttempts int           `json:"max_attempts" yaml:"max_attempts" default:"5"`
+	Backoff     synch.Backoff `json:"backoff" yaml:"backoff"`
+	// Batch is how many queued envelopes are popped at once.
+	Batch int `json:"batch" yaml:"batch" default:"10"`
+	// Concurrency is how many callbacks of a batch run at the same time.
+	Concurrency int `json:"concurrency" yaml:"concurrency" default:"1"`
+}
+
+func (p *Policy) exhausted(attempts int) bool {
+	return p.MaxAttempts > 0 && attempts >= p.MaxAttempts
+}
+
+func (p *Properties) policy(kind Kind) Policy {
+	if policy, ok := p.Policies[kind]; ok {
+		return policy
+	}
+	return p.Retry
 }
 
 type Kind string
 
+// Envelope carries failed elements between attempts.
+type Envelope[T any] struct {
+	ID       string    `json:"id"`
+	Attempts int       `json:"attempts"`
+	Values   []T       `json:"values"`
+	Error    string    `json:"error,omitempty"` // of the last attempt
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Handle controls the draining of a registered kind.
+type Handle interface {
+	Kind() Kind
+	// Stop stops draining and unregisters the kind once the batch in progress
+	// is called back, it waits for that until ctx is done.
+	Stop(ctx context.Context) *national.Message
+	// Done is closed once the kind is unregistered.
+	Done() <-chan struct{}
+}
+
 type Executor[T any] interface {
-	Register(ctxt context.Context, kind Kind, interval time.Duration, cb func(v ...T) *national.Message) (*time.Ticker,
+	// Register drains the queue of kind every interval until the returned
+	// handle is stopped, the kind is unregistered or ctxt is done.
+	Register(ctxt context.Context, kind Kind, interval time.Duration, cb func(v ...T) *national.Message) (Handle,
 		*national.Message)
+	// Unregister stops the handle of kind and waits for it until ctxt is done.
+	Unregister(ctxt context.Context, kind Kind) *national.Message
 	Exec(ctxt context.Context, kind Kind, va ...T) *national.Message
+	// ExecAt queues va to be called back by the replica draining kind once at
+	// passed, the callback is not called right away. Past times are due at
+	// the next drain.
+	ExecAt(ctxt context.Context, kind Kind, at time.Time, va ...T) *national.Message
+	// ExecAfter queues va to be called back once delay passed.
+	ExecAfter(ctxt context.Context, kind Kind, delay time.Duration, va ...T) *national.Message
+	// DeadLetters returns the elements of kind which exhausted their retries,
+	// oldest first.
+	DeadLetters(ctxt context.Context, kind Kind) ([]Envelope[T], *national.Message)
+	// Replay queues the dead letters with the given ids again with fresh
+	// attempts, all of them when no id is given. It returns how many were
+	// queued.
+	Replay(ctxt context.Context, kind Kind, ids ...string) (int, *national.Message)
+	// Discard drops the dead letters with the given ids, at least one is
+	// required. Purge the Dead queue of Admin to drop all of them.
+	Discard(ctxt context.Context, kind Kind, ids ...string) (int, *national.Message)
+	// Admin inspects the queues of every kind, registered or not.
+	Admin() Admin[T]
 }
 
 func New[T any](ctxt context.Context, props *Properties) (Executor[T], *national.Message) {
+	switch props.Coordination {
+	case "", Shared, Single, Partition:
+	default:
+		return nil, errors.UnsupportedValueError.Msg("type", "ha-coordination", "value", props.Coordination)
+	}
+	if _, ok := props.Lock.(synch.Renewer); props.coordinated() && props.Lock != nil && !ok {
+		return nil, errors.UnsupportedValueError.Msg("type", "ha-lock", "value", fmt.Sprintf("%T", props.Lock))
+	}
 	switch props.Type {
 	case Cache:
 		return newCacheExecutor[T](ctxt, props)
+	case Database:
+		return newDatabaseExecutor[T](ctxt, props)
 	}
 	return nil, errors.UnsupportedValueError.Msg("type", "ha-type", "value", props.Type)
 }
@@ -39,6 +39,10 @@ func (d *Database) DB() *gorm.DB {
 	return d.db
 }
 
+func (d *Database) Dialect() types.DatabaseType {
+	return d.properties.GetDialect()
+}
+
 func (d *Database) EscapeCharacters() (string, string) {
 	return DatabaseEscapeCharacters(d.properties.GetDialect())
 }
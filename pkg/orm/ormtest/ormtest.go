@@ -0,0 +1,39 @@
+// Package ormtest provides in-memory databases for tests.
+package ormtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/orm"
+	_ "github.com/gantries/knife/pkg/orm/sqlite"
+	"github.com/gantries/knife/pkg/types"
+	"gorm.io/gorm/schema"
+)
+
+// NewSQLite returns an in-memory SQLite database named after t, shared by the
+// connections opened during t and by nothing else.
+func NewSQLite(t testing.TB) *orm.Database {
+	return orm.New(sqliteProps{dsn: "file:" + t.Name() + "?mode=memory&cache=shared"})
+}
+
+type sqliteProps struct {
+	dsn string
+}
+
+func (p sqliteProps) GetDialect() types.DatabaseType    { return types.SQLite }
+func (p sqliteProps) GetDSN() string                    { return p.dsn }
+func (p sqliteProps) GetDriver() string                 { return "" }
+func (p sqliteProps) GetMaxIdleConnections() int        { return 1 }
+func (p sqliteProps) GetMaxOpenConnections() int        { return 1 }
+func (p sqliteProps) GetMaxTableNameLength() int        { return 64 }
+func (p sqliteProps) GetConnMaxIdleTime() time.Duration { return 0 }
+func (p sqliteProps) GetCreateBatchSize() int           { return 10 }
+func (p sqliteProps) GetLogLevel() int                  { return 1 }
+func (p sqliteProps) ShouldPrepareStmt() bool           { return false }
+func (p sqliteProps) GetTablePrefix() string            { return "" }
+func (p sqliteProps) GetSingularTable() bool            { return true }
+func (p sqliteProps) GetNameReplacer() schema.Replacer  { return nil }
+func (p sqliteProps) GetNoLowerCase() bool              { return false }
+func (p sqliteProps) GetIdentifierMaxLength() int       { return 64 }
+func (p sqliteProps) Options() map[string]string        { return map[string]string{} }
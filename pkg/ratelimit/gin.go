@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gantries/knife/pkg/auth"
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware takes a permit per request for the caller, who is the user name
+// of the auth.Identity bound to the request or else the client IP. Callers are
+// limited per scope, the route of the request when scope is empty. Requests
+// over the limit are answered with 429 and a localized error.
+func Middleware(l Limiter, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		s := scope
+		if len(s) == 0 {
+			s = c.FullPath()
+		}
+		r, err := l.Allow(ctx, s+":"+subject(c), 1)
+		if err != nil {
+			logger.Error("Unable to rate limit request", "error", err, "scope", s)
+			c.Next()
+			return
+		}
+		c.Header("X-RateLimit-Limit", strconv.FormatInt(r.Limit, 10))
+		c.Header("X-RateLimit-Remaining", strconv.FormatInt(r.Remaining, 10))
+		if r.Allowed {
+			c.Next()
+			return
+		}
+		retry := int64(math.Ceil(r.RetryAfter.Seconds()))
+		c.Header("Retry-After", strconv.FormatInt(retry, 10))
+		tr := national.Tr(national.WithLanguage(ctx, c.Request, national.Language(ctx)))
+		e := errors.RateLimitExceededError.LocalE(tr, nil, "retry", (time.Duration(retry) * time.Second).String())
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": e.Error()})
+	}
+}
+
+func subject(c *gin.Context) string {
+	if i := auth.IdentityFromContext(c.Request.Context()); i != nil && len(i.UserName) > 0 {
+		return i.UserName
+	}
+	return c.ClientIP()
+}
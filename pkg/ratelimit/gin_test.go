@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/auth"
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	national.LoadMessagesFromString(string(errors.RateLimitExceededError) + ":\n  zh: 请求过于频繁，请在{{.retry}}后重试\n")
+	l, m := New(context.Background(), nil, &Properties{Algorithm: TokenBucket, Limit: 1, Window: time.Minute})
+	assert.True(t, m.Fine())
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if user := c.GetHeader("x-user"); len(user) > 0 {
+			ctx := context.WithValue(c.Request.Context(), auth.HeaderIdentity, auth.NewIdentity("", "", user, ""))
+			c.Request = c.Request.WithContext(ctx)
+		}
+	})
+	router.Use(Middleware(l, ""))
+	router.GET("/orders", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func(user, language string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("x-user", user)
+		req.Header.Set("Accept-Language", language)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("alice", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, http.StatusOK, serve("bob", "").Code, "callers are limited apart")
+	assert.Equal(t, http.StatusOK, serve("", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("", "").Code, "anonymous callers by client IP")
+
+	w = serve("alice", "zh")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	var body map[string]string
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "请求过于频繁，请在1m0s后重试", body["error"])
+}
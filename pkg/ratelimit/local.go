@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval bounds how often idle keys are removed.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// LocalLimiter keeps the limits inside the current process, every replica
+// allows the full limit on its own.
+type LocalLimiter struct {
+	mutex   sync.Mutex
+	props   Properties
+	buckets map[string]*bucket
+	windows map[string][]time.Time
+	swept   time.Time
+	now     func() time.Time
+}
+
+func NewLocal(props *Properties) *LocalLimiter {
+	return &LocalLimiter{
+		props:   *props,
+		buckets: map[string]*bucket{},
+		windows: map[string][]time.Time{},
+		swept:   time.Now(),
+		now:     time.Now,
+	}
+}
+
+func (l *LocalLimiter) Allow(ctx context.Context, key string, n int64) (Result, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	l.sweep(now)
+	if l.props.Algorithm == SlidingWindow {
+		return l.slide(key, n, now), nil
+	}
+	return l.take(key, n, now), nil
+}
+
+func (l *LocalLimiter) take(key string, n int64, now time.Time) Result {
+	capacity, rate := float64(l.props.capacity()), l.props.rate()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.at).Seconds()*rate)
+	b.at = now
+	r := Result{Limit: l.props.capacity()}
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		r.Allowed = true
+	} else {
+		r.RetryAfter = time.Duration(math.Ceil((float64(n) - b.tokens) / rate * float64(time.Second)))
+	}
+	r.Remaining = int64(b.tokens)
+	return r
+}
+
+func (l *LocalLimiter) slide(key string, n int64, now time.Time) Result {
+	limit, window := l.props.Limit, l.props.Window
+	times := l.windows[key]
+	i := 0
+	for i < len(times) && !times[i].After(now.Add(-window)) {
+		i++
+	}
+	times = times[i:]
+	count := int64(len(times))
+	r := Result{Limit: limit}
+	if count+n <= limit {
+		for j := int64(0); j < n; j++ {
+			times = append(times, now)
+		}
+		r.Allowed, r.Remaining = true, limit-count-n
+	} else {
+		r.Remaining = max(0, limit-count)
+		if idx := count + n - limit - 1; idx < count {
+			r.RetryAfter = times[idx].Add(window).Sub(now)
+		} else {
+			r.RetryAfter = window
+		}
+	}
+	l.windows[key] = times
+	return r
+}
+
+// sweep drops the keys which are back at their full limit.
+func (l *LocalLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	full := time.Duration(float64(l.props.capacity()) / l.props.rate() * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.at) >= full {
+			delete(l.buckets, k)
+		}
+	}
+	for k, times := range l.windows {
+		if len(times) == 0 || !times[len(times)-1].After(now.Add(-l.props.Window)) {
+			delete(l.windows, k)
+		}
+	}
+	l.swept = now
+}
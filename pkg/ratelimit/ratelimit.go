@@ -0,0 +1,88 @@
+// Package ratelimit limits how often a key may be used across replicas.
+//
+// Decisions are made atomically by Lua scripts on the redis behind a
+// cache.Cache. Caches without redis, and redis failures, fall back to limits
+// kept inside the current process.
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/gantries/knife/pkg/cache"
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/log"
+	"github.com/gantries/knife/pkg/national"
+)
+
+var logger = log.New("knife/ratelimit")
+
+type Algorithm string
+
+const (
+	// TokenBucket refills Limit tokens per Window evenly and allows bursts of
+	// up to Burst requests.
+	TokenBucket Algorithm = "token-bucket"
+	// SlidingWindow allows Limit requests within any Window.
+	SlidingWindow Algorithm = "sliding-window"
+)
+
+type Properties struct {
+	Algorithm Algorithm     `json:"algorithm" yaml:"algorithm" default:"token-bucket"`
+	Limit     int64         `json:"limit" yaml:"limit" default:"100"`
+	Window    time.Duration `json:"window" yaml:"window" default:"1m"`
+	Burst     int64         `json:"burst" yaml:"burst"` // token bucket capacity, Limit when zero
+	Prefix    string        `json:"prefix" yaml:"prefix" default:"knife/ratelimit/"`
+}
+
+func (p *Properties) capacity() int64 {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Limit
+}
+
+// rate returns how many tokens are refilled per second.
+func (p *Properties) rate() float64 {
+	return float64(p.Limit) / p.Window.Seconds()
+}
+
+// Result describes the decision of Limiter.Allow.
+type Result struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// RetryAfter is how long to wait until the request would be allowed.
+	RetryAfter time.Duration
+}
+
+type Limiter interface {
+	// Allow takes n permits of key, nothing is taken when the result is not
+	// allowed.
+	Allow(ctx context.Context, key string, n int64) (Result, error)
+}
+
+// New returns a limiter on the redis behind c, or an in-process limiter when
+// there is none.
+func New(ctxt context.Context, c cache.Cache, props *Properties) (Limiter, *national.Message) {
+	if props.Limit <= 0 {
+		return nil, errors.MissingValueError.Build("value", "limit")
+	}
+	if props.Window <= 0 {
+		return nil, errors.MissingValueError.Build("value", "window")
+	}
+	switch props.Algorithm {
+	case TokenBucket, SlidingWindow:
+	default:
+		return nil, errors.UnsupportedValueError.Build("type", "algorithm", "value", props.Algorithm)
+	}
+	local := NewLocal(props)
+	if c == nil {
+		return local, errors.Yes()
+	}
+	scripter := cache.ScripterOf(c)
+	if scripter == nil {
+		return local, errors.Yes()
+	}
+	return &RedisLimiter{redis: scripter, props: *props, local: local}, errors.Yes()
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	ctxt := context.Background()
+	_, m := New(ctxt, nil, &Properties{Algorithm: TokenBucket, Window: time.Second})
+	assert.False(t, m.Fine())
+	_, m = New(ctxt, nil, &Properties{Algorithm: "leaky", Limit: 1, Window: time.Second})
+	assert.False(t, m.Fine())
+
+	c, _ := cache.NewMemory(ctxt, &cache.Properties{})
+	l, m := New(ctxt, c, &Properties{Algorithm: SlidingWindow, Limit: 1, Window: time.Second})
+	assert.True(t, m.Fine())
+	assert.IsType(t, &LocalLimiter{}, l)
+}
+
+func newLocal(algorithm Algorithm, limit, burst int64) (*LocalLimiter, *time.Time) {
+	l := NewLocal(&Properties{Algorithm: algorithm, Limit: limit, Burst: burst, Window: time.Second})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLocalLimiter_TokenBucket(t *testing.T) {
+	ctxt := context.Background()
+	l, now := newLocal(TokenBucket, 10, 2)
+
+	for i := int64(1); i >= 0; i-- {
+		r, e := l.Allow(ctxt, "k1", 1)
+		assert.Nil(t, e)
+		assert.Equal(t, Result{Allowed: true, Limit: 2, Remaining: i}, r)
+	}
+	r, _ := l.Allow(ctxt, "k1", 1)
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 100*time.Millisecond, r.RetryAfter)
+	r, _ = l.Allow(ctxt, "k2", 1)
+	assert.True(t, r.Allowed, "keys are limited apart")
+
+	*now = now.Add(100 * time.Millisecond)
+	r, _ = l.Allow(ctxt, "k1", 1)
+	assert.True(t, r.Allowed)
+	*now = now.Add(time.Hour)
+	r, _ = l.Allow(ctxt, "k1", 3)
+	assert.False(t, r.Allowed, "never more than the burst")
+	assert.Equal(t, int64(2), r.Remaining)
+	assert.Len(t, l.buckets, 1, "idle keys are swept")
+}
+
+func TestLocalLimiter_SlidingWindow(t *testing.T) {
+	ctxt := context.Background()
+	l, now := newLocal(SlidingWindow, 3, 0)
+
+	r, _ := l.Allow(ctxt, "k1", 2)
+	assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 1}, r)
+	*now = now.Add(400 * time.Millisecond)
+	r, _ = l.Allow(ctxt, "k1", 1)
+	assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 0}, r)
+	r, _ = l.Allow(ctxt, "k1", 2)
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 600*time.Millisecond, r.RetryAfter, "until the first two requests leave the window")
+	r, _ = l.Allow(ctxt, "k1", 4)
+	assert.Equal(t, time.Second, r.RetryAfter)
+
+	*now = now.Add(600 * time.Millisecond)
+	r, _ = l.Allow(ctxt, "k1", 2)
+	assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 0}, r)
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gantries/knife/pkg/lang"
+	"github.com/redis/go-redis/v9"
+)
+
+// Both scripts read the clock of redis so that replicas with skewed clocks
+// agree, they return {allowed, remaining, retry after in microseconds}.
+
+var tokenBucketScript = redis.NewScript(`
+redis.replicate_commands()
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(state[1]) or capacity
+local at = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - at) * rate / 1000000)
+local allowed, wait = 0, 0
+if tokens >= requested then
+	tokens = tokens - requested
+	allowed = 1
+else
+	wait = math.ceil((requested - tokens) * 1000000 / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity / rate * 1000) + 1000)
+return {allowed, math.floor(tokens), wait}`)
+
+var slidingWindowScript = redis.NewScript(`
+redis.replicate_commands()
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count + requested <= limit then
+	for i = 1, requested do
+		redis.call('ZADD', KEYS[1], now, ARGV[4] .. ':' .. i)
+	end
+	redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+	return {1, limit - count - requested, 0}
+end
+local wait = window
+if requested <= limit then
+	local oldest = redis.call('ZRANGE', KEYS[1], count + requested - limit - 1, count + requested - limit - 1, 'WITHSCORES')
+	wait = tonumber(oldest[2]) + window - now
+end
+return {0, math.max(0, limit - count), wait}`)
+
+// RedisLimiter shares the limits of all replicas through redis, it decides
+// locally while redis fails.
+type RedisLimiter struct {
+	redis redis.Scripter
+	props Properties
+	local *LocalLimiter
+}
+
+func (r *RedisLimiter) Allow(ctx context.Context, key string, n int64) (Result, error) {
+	var script *redis.Script
+	var args []interface{}
+	switch r.props.Algorithm {
+	case SlidingWindow:
+		script = slidingWindowScript
+		args = []interface{}{r.props.Limit, r.props.Window.Microseconds(), n, lang.StringUUID()}
+	default:
+		script = tokenBucketScript
+		args = []interface{}{r.props.capacity(), strconv.FormatFloat(r.props.rate(), 'f', -1, 64), n}
+	}
+	v, err := script.Run(ctx, r.redis, []string{r.props.Prefix + key}, args...).Int64Slice()
+	if err != nil || len(v) != 3 {
+		logger.Warn("Unable to rate limit through redis, deciding locally", "error", err, "key", key)
+		return r.local.Allow(ctx, key, n)
+	}
+	limit := r.props.Limit
+	if r.props.Algorithm != SlidingWindow {
+		limit = r.props.capacity()
+	}
+	return Result{
+		Allowed:    v[0] == 1,
+		Limit:      limit,
+		Remaining:  v[1],
+		RetryAfter: time.Duration(v[2]) * time.Microsecond,
+	}, nil
+}
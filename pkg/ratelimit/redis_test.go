@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/cache"
+	"github.com/gantries/knife/pkg/lang"
+	"github.com/gantries/knife/pkg/lists"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRedis(t *testing.T, props *Properties) Limiter {
+	ctxt := context.Background()
+	c, m := cache.NewRedis(ctxt, &cache.Properties{Type: cache.Redis, Addresses: *lists.Of[string]("127.0.0.1:6379")})
+	if !m.Fine() {
+		t.Skip("Redis not available")
+	}
+	props.Prefix = "knife:ratelimit:" + lang.StringUUID() + ":"
+	l, m := New(ctxt, c, props)
+	assert.True(t, m.Fine())
+	assert.IsType(t, &RedisLimiter{}, l)
+	return l
+}
+
+func TestRedisLimiter_TokenBucket(t *testing.T) {
+	ctxt := context.Background()
+	l := newRedis(t, &Properties{Algorithm: TokenBucket, Limit: 1, Burst: 2, Window: time.Minute})
+
+	for i := int64(1); i >= 0; i-- {
+		r, e := l.Allow(ctxt, "k1", 1)
+		assert.Nil(t, e)
+		assert.True(t, r.Allowed)
+		assert.Equal(t, i, r.Remaining)
+	}
+	r, e := l.Allow(ctxt, "k1", 1)
+	assert.Nil(t, e)
+	assert.False(t, r.Allowed)
+	assert.InDelta(t, time.Minute, r.RetryAfter, float64(time.Second))
+	r, _ = l.Allow(ctxt, "k2", 2)
+	assert.True(t, r.Allowed)
+}
+
+func TestRedisLimiter_SlidingWindow(t *testing.T) {
+	ctxt := context.Background()
+	l := newRedis(t, &Properties{Algorithm: SlidingWindow, Limit: 3, Window: time.Minute})
+
+	r, e := l.Allow(ctxt, "k1", 2)
+	assert.Nil(t, e)
+	assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 1}, r)
+	r, _ = l.Allow(ctxt, "k1", 2)
+	assert.False(t, r.Allowed)
+	assert.Equal(t, int64(1), r.Remaining)
+	assert.InDelta(t, time.Minute, r.RetryAfter, float64(time.Second))
+	r, _ = l.Allow(ctxt, "k1", 1)
+	assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 0}, r)
+}
+
+func TestRedisLimiter_Fallback(t *testing.T) {
+	ctxt, cancel := context.WithCancel(context.Background())
+	l := newRedis(t, &Properties{Algorithm: TokenBucket, Limit: 1, Window: time.Minute})
+	cancel()
+	r, e := l.Allow(ctxt, "k1", 1)
+	assert.Nil(t, e)
+	assert.True(t, r.Allowed)
+	r, _ = l.Allow(ctxt, "k1", 1)
+	assert.False(t, r.Allowed, "limited locally")
+}
@@ -0,0 +1,209 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// star flags the day fields given as * or ?, which do not restrict the days
+// matched by the other one.
+const star = uint64(1) << 63
+
+// Cron is a parsed cron expression, see Parse.
+type Cron struct {
+	spec                                  string
+	second, minute, hour, dom, month, dow uint64
+	every                                 time.Duration
+}
+
+type bounds struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	months = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8,
+		"sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	days   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+	fields = []bounds{
+		{name: "second", min: 0, max: 59},
+		{name: "minute", min: 0, max: 59},
+		{name: "hour", min: 0, max: 23},
+		{name: "day of month", min: 1, max: 31},
+		{name: "month", min: 1, max: 12, names: months},
+		{name: "day of week", min: 0, max: 7, names: days},
+	}
+	descriptors = map[string]string{
+		"@yearly":   "0 0 0 1 1 *",
+		"@annually": "0 0 0 1 1 *",
+		"@monthly":  "0 0 0 1 * *",
+		"@weekly":   "0 0 0 * * 0",
+		"@daily":    "0 0 0 * * *",
+		"@midnight": "0 0 0 * * *",
+		"@hourly":   "0 0 * * * *",
+	}
+)
+
+// Parse parses the standard five fields of minute, hour, day of month, month
+// and day of week, optionally preceded by seconds. Fields are lists of
+// values, ranges and steps such as 1,15 or 1-5 or */10, months and days of
+// week may be named. A day matches either day field when both are given.
+// The descriptors @yearly, @monthly, @weekly, @daily, @hourly and
+// @every <duration> are supported as well, @every fires at the multiples of
+// its duration since the zero time, so that every replica fires alike.
+func Parse(spec string) (*Cron, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || every <= 0 {
+			return nil, fmt.Errorf("cron %q: invalid duration", spec)
+		}
+		return &Cron{spec: spec, every: every}, nil
+	}
+	expr := spec
+	if d, ok := descriptors[strings.ToLower(spec)]; ok {
+		expr = d
+	}
+	parts := strings.Fields(expr)
+	switch len(parts) {
+	case 5:
+		parts = append([]string{"0"}, parts...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron %q: expected 5 or 6 fields, got %d", spec, len(parts))
+	}
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		v, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %w", spec, err)
+		}
+		values[i] = v
+	}
+	// Sunday is 0 and 7
+	if values[5]&(1<<7) != 0 {
+		values[5] = values[5]&^(1<<7) | 1
+	}
+	return &Cron{spec: spec, second: values[0], minute: values[1], hour: values[2], dom: values[3],
+		month: values[4], dow: values[5]}, nil
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		r, s, stepped := strings.Cut(part, "/")
+		lo, hi := b.min, b.max
+		if r == "*" || r == "?" {
+			if !stepped {
+				bits |= star
+			}
+		} else {
+			first, last, ranged := strings.Cut(r, "-")
+			var err error
+			if lo, err = value(first, b); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if ranged {
+				if hi, err = value(last, b); err != nil {
+					return 0, err
+				}
+			} else if stepped {
+				hi = b.max
+			}
+		}
+		step := 1
+		if stepped {
+			var err error
+			if step, err = strconv.Atoi(s); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q of %s", s, b.name)
+			}
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range %q of %s", r, b.name)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func value(s string, b bounds) (int, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < b.min || v > b.max {
+		return 0, fmt.Errorf("invalid %s %q", b.name, s)
+	}
+	return v, nil
+}
+
+func (c *Cron) String() string {
+	return c.spec
+}
+
+// Next returns the first time after t the expression fires at, in the
+// location of t. It is zero when the expression does not fire within five
+// years, for instance on the 30th of February.
+func (c *Cron) Next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Truncate(c.every).Add(c.every)
+	}
+	loc := t.Location()
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+	limit := t.Year() + 5
+wrap:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+	for !has(c.month, int(t.Month())) {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !c.day(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	// Hours and below advance on absolute time, the local hour repeats when
+	// the clock falls back and is skipped when it springs forward
+	for day := t.Day(); !has(c.hour, t.Hour()); {
+		t = t.Add(time.Hour - time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second)
+		if t.Day() != day {
+			goto wrap
+		}
+	}
+	for !has(c.minute, t.Minute()) {
+		t = t.Add(time.Minute - time.Duration(t.Second())*time.Second)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	for !has(c.second, t.Second()) {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+	return t
+}
+
+func (c *Cron) day(t time.Time) bool {
+	dom, dow := has(c.dom, t.Day()), has(c.dow, int(t.Weekday()))
+	if c.dom&star != 0 || c.dow&star != 0 {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<v) != 0
+}
@@ -0,0 +1,142 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(s string) time.Time {
+	t, err := time.ParseInLocation(time.DateTime, s, time.UTC)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParse(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * foo *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"@every",
+		"@every 0s",
+		"@every soon",
+		"@fortnightly",
+	} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+	c, err := Parse(" 0 9 * * mon-fri ")
+	assert.NoError(t, err)
+	assert.Equal(t, "0 9 * * mon-fri", c.String())
+}
+
+func TestCron_Next(t *testing.T) {
+	for _, tc := range []struct {
+		spec, from string
+		next       []string
+	}{
+		{"*/15 * * * *", "2024-03-01 10:07:30",
+			[]string{"2024-03-01 10:15:00", "2024-03-01 10:30:00", "2024-03-01 10:45:00", "2024-03-01 11:00:00"}},
+		{"0 9 * * mon-fri", "2024-03-01 09:00:00", // Friday
+			[]string{"2024-03-04 09:00:00", "2024-03-05 09:00:00"}},
+		{"30 * * * * *", "2024-03-01 10:00:30",
+			[]string{"2024-03-01 10:01:30", "2024-03-01 10:02:30"}},
+		{"0 0 1 jan,JUL *", "2024-03-01 00:00:00",
+			[]string{"2024-07-01 00:00:00", "2025-01-01 00:00:00"}},
+		// Either day field matches when both are given
+		{"0 0 13 * fri", "2024-09-10 00:00:00",
+			[]string{"2024-09-13 00:00:00", "2024-09-20 00:00:00", "2024-09-27 00:00:00", "2024-10-04 00:00:00",
+				"2024-10-11 00:00:00", "2024-10-13 00:00:00"}},
+		{"0 0 * * 7", "2024-03-01 00:00:00",
+			[]string{"2024-03-03 00:00:00", "2024-03-10 00:00:00"}},
+		{"0 0 29 2 *", "2024-03-01 00:00:00",
+			[]string{"2028-02-29 00:00:00"}},
+		{"5-10/5,58 23 31 12 ?", "2024-12-31 23:59:00",
+			[]string{"2025-12-31 23:05:00", "2025-12-31 23:10:00", "2025-12-31 23:58:00"}},
+		{"@daily", "2024-12-31 12:00:00",
+			[]string{"2025-01-01 00:00:00", "2025-01-02 00:00:00"}},
+		{"@hourly", "2024-03-01 10:00:00",
+			[]string{"2024-03-01 11:00:00"}},
+		{"@weekly", "2024-03-01 10:00:00",
+			[]string{"2024-03-03 00:00:00"}},
+		{"@every 90m", "2024-03-01 10:00:00",
+			[]string{"2024-03-01 10:30:00", "2024-03-01 12:00:00"}},
+	} {
+		c, err := Parse(tc.spec)
+		if !assert.NoError(t, err, tc.spec) {
+			continue
+		}
+		at := date(tc.from)
+		for _, next := range tc.next {
+			at = c.Next(at)
+			assert.Equal(t, date(next), at, tc.spec)
+		}
+	}
+}
+
+func TestCron_Never(t *testing.T) {
+	c, err := Parse("0 0 30 2 *")
+	assert.NoError(t, err)
+	assert.True(t, c.Next(date("2024-01-01 00:00:00")).IsZero())
+}
+
+func TestCron_Location(t *testing.T) {
+	l, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("time zone database unavailable")
+	}
+	c, _ := Parse("0 9 * * *")
+	next := c.Next(date("2024-03-01 00:00:00"))
+	assert.Equal(t, date("2024-03-01 09:00:00"), next)
+	next = c.Next(date("2024-03-01 00:00:00").In(l))
+	assert.Equal(t, date("2024-03-01 01:00:00"), next.UTC())
+}
+
+func TestCron_DaylightSaving(t *testing.T) {
+	l, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database unavailable")
+	}
+	at := func(s string) time.Time {
+		t, _ := time.ParseInLocation(time.DateTime, s, l)
+		return t
+	}
+	for _, tc := range []struct {
+		spec, from string
+		next       []time.Time
+	}{
+		// 01:00 repeats on the 3rd of November 2024
+		{"0 0 3 * * *", "2024-11-03 00:30:00", []time.Time{date("2024-11-03 08:00:00"), date("2024-11-04 08:00:00")}},
+		{"0 0 * * * *", "2024-11-03 00:30:00",
+			[]time.Time{date("2024-11-03 05:00:00"), date("2024-11-03 06:00:00"), date("2024-11-03 07:00:00")}},
+		{"0 30 1 * * *", "2024-11-03 00:00:00",
+			[]time.Time{date("2024-11-03 05:30:00"), date("2024-11-03 06:30:00"), date("2024-11-04 06:30:00")}},
+		// 02:00 is skipped on the 10th of March 2024
+		{"0 30 2 * * *", "2024-03-10 00:00:00", []time.Time{date("2024-03-11 06:30:00")}},
+		{"0 0 3 * * *", "2024-03-10 00:00:00", []time.Time{date("2024-03-10 07:00:00"), date("2024-03-11 07:00:00")}},
+		{"0 0 * * * *", "2024-03-10 00:30:00", []time.Time{date("2024-03-10 06:00:00"), date("2024-03-10 07:00:00")}},
+	} {
+		c, err := Parse(tc.spec)
+		if !assert.NoError(t, err, tc.spec) {
+			continue
+		}
+		next := at(tc.from)
+		for _, want := range tc.next {
+			next = c.Next(next)
+			assert.Equal(t, want, next.UTC(), tc.spec)
+			assert.Equal(t, l, next.Location())
+		}
+	}
+}
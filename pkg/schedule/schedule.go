@@ -0,0 +1,424 @@
+// Package schedule runs jobs on cron expressions across replicas.
+//
+// Every replica runs the same jobs, a run locks the name of its job and its
+// fire time on a synch.Lock so that a single replica runs it. The lock is
+// held until Hold passed since the fire time, replicas starting a run that
+// late drop it. Runs are counted by knife.schedule.runs with their result and
+// their durations are recorded in milliseconds to knife.schedule.duration.
+package schedule
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/lang"
+	"github.com/gantries/knife/pkg/log"
+	"github.com/gantries/knife/pkg/maps"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/gantries/knife/pkg/synch"
+	"github.com/gantries/knife/pkg/tel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var logger = log.New("knife/schedule")
+
+// lockPrefix prefixes the lock sources of runs.
+const lockPrefix = "schedule/"
+
+type Properties struct {
+	// Location is the time zone of the expressions, the local one when empty.
+	Location string `json:"location" yaml:"location"`
+	// Grace is how late a run may start before it is missed.
+	Grace time.Duration `json:"grace" yaml:"grace" default:"1m"`
+	// Hold is how long a run stays locked after its fire time, missed runs
+	// older than that are dropped whatever the policy of their job. It is
+	// extended to Grace when shorter.
+	Hold time.Duration `json:"hold" yaml:"hold" default:"1h"`
+	// History is how many runs are kept per job.
+	History int `json:"history" yaml:"history" default:"20"`
+}
+
+func (p *Properties) grace() time.Duration {
+	if p.Grace > 0 {
+		return p.Grace
+	}
+	return time.Minute
+}
+
+func (p *Properties) hold() time.Duration {
+	if p.Hold > 0 {
+		return max(p.Hold, p.grace())
+	}
+	return max(time.Hour, p.grace())
+}
+
+func (p *Properties) history() int {
+	if p.History > 0 {
+		return p.History
+	}
+	return 20
+}
+
+// Policy decides which runs of a job missed by a replica it catches up on.
+// Runs are missed when the previous run of the job took longer than its
+// interval or the replica was suspended.
+type Policy string
+
+const (
+	// Skip drops the missed runs, the next run starts on time.
+	Skip Policy = "skip"
+	// Once runs the latest missed run only.
+	Once Policy = "once"
+	// All runs every missed run, oldest first.
+	All Policy = "all"
+)
+
+type Job struct {
+	Name string
+	// Spec is the cron expression of the job, see Parse.
+	Spec string
+	Run  func(ctx context.Context) error
+	// Missed is the policy of missed runs, Skip when empty.
+	Missed Policy
+	// Jitter delays every run by up to that long, so that jobs firing at the
+	// same time do not start at once.
+	Jitter time.Duration
+	// Timeout cancels the context of a run, no timeout when zero. Runs not
+	// returning once cancelled are waited for all the same.
+	Timeout time.Duration
+}
+
+type Status string
+
+const (
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+	TimedOut  Status = "timed-out"
+	// Missed runs were dropped by this replica.
+	Missed Status = "missed"
+)
+
+// Run is a run of a job by this replica.
+type Run struct {
+	Job    string    `json:"job"`
+	Fire   time.Time `json:"fire"`
+	Start  time.Time `json:"start,omitzero"`
+	End    time.Time `json:"end,omitzero"`
+	Status Status    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+}
+
+type job struct {
+	Job
+	cron    *Cron
+	history []Run
+	loop    *loop
+}
+
+// loop schedules a job until cancelled, done is closed once it returned.
+type loop struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Scheduler runs the jobs added to it once started.
+type Scheduler struct {
+	lock     synch.Lock
+	props    Properties
+	id       string
+	location *time.Location
+	mutex    sync.RWMutex
+	jobs     maps.Map[string, *job]
+	ctx      context.Context
+	now      func() time.Time
+	runs     tel.SimpleCounter
+	duration tel.SimpleHistogram
+}
+
+// New returns a scheduler locking runs on lock for the replica id, a random
+// id is used when empty.
+func New(lock synch.Lock, props *Properties, id string) (*Scheduler, *national.Message) {
+	if lock == nil {
+		return nil, errors.MissingValueError.Build("value", "lock")
+	}
+	location := time.Local
+	if len(props.Location) > 0 {
+		l, err := time.LoadLocation(props.Location)
+		if err != nil {
+			return nil, errors.UnrecognizedError.Build("type", "time-zone", "value", props.Location)
+		}
+		location = l
+	}
+	if len(id) == 0 {
+		id = lang.StringUUID()
+	}
+	return &Scheduler{
+		lock:     lock,
+		props:    *props,
+		id:       id,
+		location: location,
+		jobs:     maps.Map[string, *job]{},
+		now:      time.Now,
+		runs:     tel.Counter("knife.schedule.runs"),
+		duration: tel.Histogram("knife.schedule.duration"),
+	}, errors.Yes()
+}
+
+// Add schedules j, right away when the scheduler is started.
+func (s *Scheduler) Add(j Job) *national.Message {
+	if len(j.Name) == 0 {
+		return errors.MissingValueError.Build("value", "name")
+	}
+	if j.Run == nil {
+		return errors.MissingValueError.Build("value", "run")
+	}
+	switch j.Missed {
+	case "", Skip, Once, All:
+	default:
+		return errors.UnsupportedValueError.Build("type", "schedule-policy", "value", j.Missed)
+	}
+	c, err := Parse(j.Spec)
+	if err != nil {
+		return errors.No(err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.jobs[j.Name]; ok {
+		return errors.OverwriteIsForbiddenError.Msg("target", j.Name, "type", "schedule-job")
+	}
+	jb := &job{Job: j, cron: c}
+	s.jobs[j.Name] = jb
+	if s.ctx != nil {
+		s.start(jb)
+	}
+	return errors.Yes()
+}
+
+// Remove stops scheduling the job called name and waits for its run in
+// progress until ctx is done.
+func (s *Scheduler) Remove(ctx context.Context, name string) *national.Message {
+	s.mutex.Lock()
+	j, ok := s.jobs[name]
+	delete(s.jobs, name)
+	s.mutex.Unlock()
+	if !ok {
+		return errors.NotFoundError.Build("type", "schedule-job", "value", name)
+	}
+	return j.loop.stop(ctx)
+}
+
+// Start schedules the jobs until ctx is done or the scheduler is stopped.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ctx != nil {
+		return
+	}
+	s.ctx = ctx
+	for _, j := range s.jobs {
+		s.start(j)
+	}
+}
+
+func (s *Scheduler) start(j *job) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	l := &loop{cancel: cancel, done: make(chan struct{})}
+	j.loop = l
+	go func() {
+		defer close(l.done)
+		s.run(ctx, j)
+	}()
+}
+
+// Stop stops scheduling and waits for the runs in progress until ctx is done,
+// the scheduler may be started again.
+func (s *Scheduler) Stop(ctx context.Context) *national.Message {
+	s.mutex.Lock()
+	loops := make([]*loop, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		loops = append(loops, j.loop)
+	}
+	s.ctx = nil
+	s.mutex.Unlock()
+	for _, l := range loops {
+		if m := l.stop(ctx); !m.Fine() {
+			return m
+		}
+	}
+	return errors.Yes()
+}
+
+// stop cancels l, if any, and waits for it until ctx is done.
+func (l *loop) stop(ctx context.Context) *national.Message {
+	if l == nil {
+		return errors.Yes()
+	}
+	l.cancel()
+	select {
+	case <-l.done:
+		return errors.Yes()
+	case <-ctx.Done():
+		return errors.No(ctx.Err())
+	}
+}
+
+// History returns the latest runs of the job called name, oldest first.
+func (s *Scheduler) History(name string) ([]Run, *national.Message) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return nil, errors.NotFoundError.Build("type", "schedule-job", "value", name)
+	}
+	return append([]Run(nil), j.history...), errors.Yes()
+}
+
+// run runs j at its fire times until ctx is done, a run in progress is not
+// interrupted.
+func (s *Scheduler) run(ctx context.Context, j *job) {
+	last := s.now().In(s.location)
+	for {
+		next := j.cron.Next(last)
+		if next.IsZero() {
+			logger.Warn("Job never fires", "job", j.Name, "spec", j.Spec)
+			return
+		}
+		timer := time.NewTimer(next.Add(jitter(j.Jitter)).Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		now := s.now().In(s.location)
+		s.tick(context.WithoutCancel(ctx), j, last, now)
+		last = now
+	}
+}
+
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d))) // #nosec G404 - jitter does not need a secure source
+}
+
+// tick runs the fire times of j after last until now according to its
+// policy.
+func (s *Scheduler) tick(ctx context.Context, j *job, last, now time.Time) {
+	var fires []time.Time
+	for f := j.cron.Next(last); !f.IsZero() && !f.After(now); f = j.cron.Next(f) {
+		if now.Sub(f) >= s.props.hold() {
+			// Unlocked already, another replica may have run it
+			logger.Warn("Dropped a run of a job", "job", j.Name, "fire", f)
+			s.record(ctx, j, Run{Job: j.Name, Fire: f, Status: Missed})
+			continue
+		}
+		fires = append(fires, f)
+	}
+	if len(fires) == 0 {
+		return
+	}
+	latest := fires[len(fires)-1]
+	run := fires
+	switch j.Missed {
+	case Once:
+		run = fires[len(fires)-1:]
+	case All:
+	default:
+		run = nil
+		if now.Sub(latest) <= s.props.grace()+j.Jitter {
+			run = fires[len(fires)-1:]
+		}
+	}
+	for _, f := range fires[:len(fires)-len(run)] {
+		s.record(ctx, j, Run{Job: j.Name, Fire: f, Status: Missed})
+	}
+	for _, f := range run {
+		s.fire(ctx, j, f)
+	}
+}
+
+// fire runs j for its fire time f unless another replica locked it.
+func (s *Scheduler) fire(ctx context.Context, j *job, f time.Time) {
+	source := lockPrefix + j.Name + "@" + strconv.FormatInt(f.UnixMilli(), 10)
+	hold := f.Add(s.props.hold()).Sub(s.now())
+	ok, err := s.lock.Lock(ctx, source, s.id, hold)
+	if err != nil {
+		logger.Error("Unable to lock a run", "error", err, "job", j.Name, "fire", f)
+		s.record(ctx, j, Run{Job: j.Name, Fire: f, Status: Failed, Error: err.Error()})
+		return
+	}
+	if !ok {
+		logger.Debug("Run locked by another replica", "job", j.Name, "fire", f)
+		s.count(ctx, j, "locked")
+		return
+	}
+	// Released once no replica starts the run anymore
+	time.AfterFunc(hold, func() {
+		if _, err := s.lock.Unlock(context.Background(), source, s.id); err != nil {
+			logger.Warn("Unable to unlock a run", "error", err, "job", j.Name, "fire", f)
+		}
+	})
+	r := Run{Job: j.Name, Fire: f, Start: s.now()}
+	err = s.call(ctx, j)
+	r.End = s.now()
+	switch {
+	case err == nil:
+		r.Status = Succeeded
+	case stderrors.Is(err, context.DeadlineExceeded):
+		r.Status, r.Error = TimedOut, err.Error()
+	default:
+		r.Status, r.Error = Failed, err.Error()
+	}
+	s.duration.Record(ctx, float64(r.End.Sub(r.Start))/float64(time.Millisecond),
+		metric.WithAttributes(attribute.String("schedule.job", j.Name)))
+	s.record(ctx, j, r)
+}
+
+// call runs j in a span, within its timeout. Panics are returned as errors.
+func (s *Scheduler) call(ctx context.Context, j *job) (err error) {
+	if j.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
+		defer cancel()
+	}
+	span := tel.Span(&ctx, "schedule."+j.Name)
+	defer tel.Do(span, &err)
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Job panicked", "job", j.Name, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("job %s panicked: %v", j.Name, r)
+		}
+	}()
+	return j.Run(ctx)
+}
+
+func (s *Scheduler) record(ctx context.Context, j *job, r Run) {
+	switch r.Status {
+	case Succeeded:
+		logger.Info("Job run", "job", j.Name, "fire", r.Fire, "duration", r.End.Sub(r.Start))
+	case Failed, TimedOut:
+		logger.Error("Job run failed", "job", j.Name, "fire", r.Fire, "status", r.Status, "error", r.Error)
+	}
+	s.count(ctx, j, string(r.Status))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	j.history = append(j.history, r)
+	if n := len(j.history) - s.props.history(); n > 0 {
+		j.history = j.history[n:]
+	}
+}
+
+func (s *Scheduler) count(ctx context.Context, j *job, result string) {
+	s.runs.Add(ctx, 1, metric.WithAttributes(attribute.String("schedule.job", j.Name),
+		attribute.String("schedule.result", result)))
+}
@@ -0,0 +1,207 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/synch"
+	"github.com/gantries/knife/pkg/tel"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var properties = Properties{Location: "UTC", Grace: 10 * time.Second, Hold: 2 * time.Minute}
+
+// newScheduler returns a scheduler whose clock is stopped at now.
+func newScheduler(t *testing.T, lock synch.Lock, id string, now time.Time) *Scheduler {
+	s, m := New(lock, &properties, id)
+	if !m.Fine() {
+		t.Fatal(m)
+	}
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func statuses(t *testing.T, s *Scheduler, name string) []string {
+	history, m := s.History(name)
+	assert.True(t, m.Fine())
+	var got []string
+	for _, r := range history {
+		got = append(got, r.Fire.Format(time.TimeOnly)+" "+string(r.Status))
+	}
+	return got
+}
+
+func TestNew(t *testing.T) {
+	_, m := New(nil, &properties, "a")
+	assert.False(t, m.Fine())
+	_, m = New(synch.NewMemoryLock(), &Properties{Location: "Nowhere/Never"}, "a")
+	assert.False(t, m.Fine())
+	s, m := New(synch.NewMemoryLock(), &Properties{}, "")
+	assert.True(t, m.Fine())
+	assert.NotEmpty(t, s.id)
+	assert.Equal(t, time.Local, s.location)
+	assert.Equal(t, time.Minute, s.props.grace())
+	assert.Equal(t, time.Hour, s.props.hold())
+	assert.Equal(t, 20, s.props.history())
+	assert.Equal(t, 5*time.Minute, (&Properties{Grace: 5 * time.Minute, Hold: time.Minute}).hold())
+}
+
+func TestScheduler_Add(t *testing.T) {
+	s := newScheduler(t, synch.NewMemoryLock(), "a", time.Now())
+	run := func(ctx context.Context) error { return nil }
+	assert.False(t, s.Add(Job{Spec: "* * * * *", Run: run}).Fine())
+	assert.False(t, s.Add(Job{Name: "job", Spec: "* * * * *"}).Fine())
+	assert.False(t, s.Add(Job{Name: "job", Spec: "* * * *", Run: run}).Fine())
+	assert.False(t, s.Add(Job{Name: "job", Spec: "* * * * *", Run: run, Missed: "twice"}).Fine())
+	assert.True(t, s.Add(Job{Name: "job", Spec: "* * * * *", Run: run}).Fine())
+	assert.False(t, s.Add(Job{Name: "job", Spec: "@hourly", Run: run}).Fine())
+
+	_, m := s.History("other")
+	assert.False(t, m.Fine())
+	assert.False(t, s.Remove(context.Background(), "other").Fine())
+	assert.True(t, s.Remove(context.Background(), "job").Fine())
+	_, m = s.History("job")
+	assert.False(t, m.Fine())
+}
+
+func TestScheduler_Missed(t *testing.T) {
+	last, now := date("2024-03-01 10:00:00"), date("2024-03-01 10:04:30")
+	for _, tc := range []struct {
+		policy Policy
+		runs   int32
+		want   []string
+	}{
+		// 10:01 and 10:02 are older than the hold, 10:04 is later than the grace
+		{Skip, 0, []string{"10:01:00 missed", "10:02:00 missed", "10:03:00 missed", "10:04:00 missed"}},
+		{Once, 1, []string{"10:01:00 missed", "10:02:00 missed", "10:03:00 missed", "10:04:00 succeeded"}},
+		{All, 2, []string{"10:01:00 missed", "10:02:00 missed", "10:03:00 succeeded", "10:04:00 succeeded"}},
+	} {
+		s := newScheduler(t, synch.NewMemoryLock(), "a", now)
+		var runs atomic.Int32
+		s.Add(Job{Name: "job", Spec: "* * * * *", Missed: tc.policy, Run: func(ctx context.Context) error {
+			runs.Add(1)
+			return nil
+		}})
+		s.tick(context.Background(), s.jobs["job"], last, now)
+		assert.Equal(t, tc.want, statuses(t, s, "job"), tc.policy)
+		assert.Equal(t, tc.runs, runs.Load(), tc.policy)
+	}
+
+	// On time
+	s := newScheduler(t, synch.NewMemoryLock(), "a", date("2024-03-01 10:01:05"))
+	s.Add(Job{Name: "job", Spec: "* * * * *", Run: func(ctx context.Context) error { return nil }})
+	s.tick(context.Background(), s.jobs["job"], last, s.now())
+	assert.Equal(t, []string{"10:01:00 succeeded"}, statuses(t, s, "job"))
+}
+
+func TestScheduler_Single(t *testing.T) {
+	lock := synch.NewMemoryLock()
+	last, now := date("2024-03-01 10:00:00"), date("2024-03-01 10:01:00")
+	var runs atomic.Int32
+	job := Job{Name: "job", Spec: "* * * * *", Run: func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	}}
+	a, b := newScheduler(t, lock, "a", now), newScheduler(t, lock, "b", now)
+	a.Add(job)
+	b.Add(job)
+	a.tick(context.Background(), a.jobs["job"], last, now)
+	b.tick(context.Background(), b.jobs["job"], last, now)
+	assert.Equal(t, int32(1), runs.Load())
+	assert.Equal(t, []string{"10:01:00 succeeded"}, statuses(t, a, "job"))
+	assert.Empty(t, statuses(t, b, "job"))
+
+	owner, err := lock.Owner(context.Background(), "schedule/job@"+fmt.Sprint(now.UnixMilli()))
+	assert.NoError(t, err)
+	assert.Equal(t, "a", owner)
+
+	// The next fire is locked apart
+	now = now.Add(time.Minute)
+	b.now = func() time.Time { return now }
+	b.tick(context.Background(), b.jobs["job"], now.Add(-time.Minute), now)
+	assert.Equal(t, int32(2), runs.Load())
+	assert.Equal(t, []string{"10:02:00 succeeded"}, statuses(t, b, "job"))
+}
+
+func TestScheduler_Failures(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer("test")
+	tel.SetupTracer(&tracer)
+	defer tel.SetupTracer(nil)
+
+	last, now := date("2024-03-01 10:00:00"), date("2024-03-01 10:01:00")
+	s := newScheduler(t, synch.NewMemoryLock(), "a", now)
+	s.Add(Job{Name: "fail", Spec: "* * * * *", Run: func(ctx context.Context) error {
+		return fmt.Errorf("disk full")
+	}})
+	s.Add(Job{Name: "panic", Spec: "* * * * *", Run: func(ctx context.Context) error {
+		panic("out of range")
+	}})
+	s.Add(Job{Name: "slow", Spec: "* * * * *", Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	for _, name := range []string{"fail", "panic", "slow"} {
+		s.tick(context.Background(), s.jobs[name], last, now)
+	}
+
+	history, _ := s.History("fail")
+	assert.Equal(t, Failed, history[0].Status)
+	assert.Equal(t, "disk full", history[0].Error)
+	history, _ = s.History("panic")
+	assert.Equal(t, Failed, history[0].Status)
+	assert.Contains(t, history[0].Error, "out of range")
+	history, _ = s.History("slow")
+	assert.Equal(t, TimedOut, history[0].Status)
+
+	ended := spans.Ended()
+	assert.Len(t, ended, 3)
+	for _, span := range ended {
+		assert.Equal(t, codes.Error, span.Status().Code)
+	}
+	assert.Equal(t, "schedule.fail", ended[0].Name())
+}
+
+func TestScheduler_History(t *testing.T) {
+	s, _ := New(synch.NewMemoryLock(), &Properties{Location: "UTC", Hold: time.Hour, History: 2}, "a")
+	now := date("2024-03-01 10:04:00")
+	s.now = func() time.Time { return now }
+	s.Add(Job{Name: "job", Spec: "* * * * *", Missed: All, Run: func(ctx context.Context) error { return nil }})
+	s.tick(context.Background(), s.jobs["job"], date("2024-03-01 10:00:00"), now)
+	assert.Equal(t, []string{"10:03:00 succeeded", "10:04:00 succeeded"}, statuses(t, s, "job"))
+}
+
+func TestScheduler_Start(t *testing.T) {
+	s, _ := New(synch.NewMemoryLock(), &properties, "a")
+	var a, b atomic.Int32
+	s.Add(Job{Name: "a", Spec: "@every 20ms", Run: func(ctx context.Context) error {
+		a.Add(1)
+		return nil
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+	s.Add(Job{Name: "b", Spec: "@every 20ms", Run: func(ctx context.Context) error {
+		b.Add(1)
+		return nil
+	}})
+	assert.Eventually(t, func() bool { return a.Load() >= 2 && b.Load() >= 2 }, 5*time.Second, 5*time.Millisecond)
+
+	assert.True(t, s.Remove(context.Background(), "b").Fine())
+	stopped := b.Load()
+	assert.True(t, s.Stop(context.Background()).Fine())
+	stopped += a.Load()
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, stopped, a.Load()+b.Load())
+
+	// Started again
+	s.Start(ctx)
+	assert.Eventually(t, func() bool { return a.Load()+b.Load() > stopped }, 5*time.Second, 5*time.Millisecond)
+	assert.True(t, s.Stop(context.Background()).Fine())
+}
@@ -26,8 +26,7 @@ func Deserialize[T any](b []byte) (t *T, err error) {
 }
 
 func DeserializeArray[T any](b []byte) (a []T, err error) {
-	var r []T
-	if err = json.Unmarshal(b, &r); err == nil {
+	if err = json.Unmarshal(b, &a); err == nil {
 		return
 	}
 	return nil, err
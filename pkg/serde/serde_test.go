@@ -75,3 +75,13 @@ func TestIsJSONArray(t *testing.T) {
 		})
 	}
 }
+
+func TestDeserializeArray(t *testing.T) {
+	a, err := DeserializeArray[string]([]byte(`["k1","k2"]`))
+	if err != nil || len(a) != 2 || a[0] != "k1" || a[1] != "k2" {
+		t.Errorf("DeserializeArray() = %v, %v", a, err)
+	}
+	if _, err = DeserializeArray[string]([]byte(`{}`)); err == nil {
+		t.Errorf("DeserializeArray() expected an error")
+	}
+}
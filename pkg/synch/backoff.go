@@ -0,0 +1,59 @@
+package synch
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponentially growing delays between retries.
+type Backoff struct {
+	Initial    time.Duration `json:"initial" yaml:"initial" default:"50ms"`
+	Max        time.Duration `json:"max" yaml:"max" default:"1s"`
+	Multiplier float64       `json:"multiplier" yaml:"multiplier" default:"2"`
+	// Jitter is the fraction of each delay which is randomized, 0.2 spreads a
+	// delay of 1s between 800ms and 1.2s.
+	Jitter float64 `json:"jitter" yaml:"jitter" default:"0.2"`
+}
+
+// initial falls back to the default when unset, so that retries of a zero
+// Backoff do not spin.
+func (b *Backoff) initial() time.Duration {
+	if b.Initial > 0 {
+		return b.Initial
+	}
+	return 50 * time.Millisecond
+}
+
+// Delay returns the pause before retry number attempt, starting from 0.
+func (b *Backoff) Delay(attempt int) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(b.initial()) * math.Pow(multiplier, float64(attempt))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (2*rand.Float64() - 1) // #nosec G404 - jitter does not need a secure source
+	}
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
+
+// Wait sleeps for the delay of attempt, it returns early with the error of ctx
+// when ctx is done.
+func (b *Backoff) Wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(b.Delay(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
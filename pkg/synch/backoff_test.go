@@ -0,0 +1,39 @@
+package synch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	b := Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2}
+	assert.Equal(t, 10*time.Millisecond, b.Delay(0))
+	assert.Equal(t, 20*time.Millisecond, b.Delay(1))
+	assert.Equal(t, 40*time.Millisecond, b.Delay(2))
+	assert.Equal(t, 50*time.Millisecond, b.Delay(3))
+	assert.Equal(t, 50*time.Millisecond, b.Delay(30))
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.Delay(1)
+		assert.True(t, d >= 10*time.Millisecond && d <= 30*time.Millisecond, d)
+	}
+
+	constant := Backoff{Initial: time.Millisecond}
+	assert.Equal(t, time.Millisecond, constant.Delay(5))
+
+	var unset Backoff
+	assert.Equal(t, 50*time.Millisecond, unset.Delay(0))
+	assert.Equal(t, 50*time.Millisecond, unset.Delay(3))
+}
+
+func TestBackoff_Wait(t *testing.T) {
+	b := Backoff{Initial: time.Hour}
+	ctxt, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, b.Wait(ctxt, 0), context.DeadlineExceeded)
+	assert.Nil(t, (&Backoff{}).Wait(context.Background(), 0))
+}
@@ -0,0 +1,137 @@
+package synch
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gantries/knife/pkg/errors"
+	"github.com/gantries/knife/pkg/lang"
+	"github.com/gantries/knife/pkg/national"
+	"github.com/gantries/knife/pkg/orm"
+	"github.com/gantries/knife/pkg/types"
+	"gorm.io/gorm"
+)
+
+// Every held source is a row of the table with its owner, the reentrance
+// count and the unix milliseconds its lease expires at. Rows are claimed by
+// conditional updates and inserts only, so that every dialect behaves alike,
+// and rows of expired leases are taken over by the next owner.
+
+// DatabaseLock is a reentrant Lock stored in a table of a database. Holders
+// renew their lease themselves, leases expire on the clocks of the replicas
+// which should therefore be kept in sync. Statements run outside of the
+// transaction of the context so that other replicas see the lock right away.
+type DatabaseLock struct {
+	db    *orm.Database
+	table string
+	now   func() time.Time
+}
+
+// NewDatabaseLock returns a lock on db, creating its table when missing.
+func NewDatabaseLock(ctx context.Context, db *orm.Database, props *LockProperties) (*DatabaseLock, *national.Message) {
+	d := &DatabaseLock{db: db, table: props.table(), now: time.Now}
+	if err := d.migrate(ctx); err != nil {
+		return nil, errors.No(err)
+	}
+	return d, errors.Yes()
+}
+
+// q escapes the identifiers of statement which are enclosed in braces.
+func (d *DatabaseLock) q(statement string) string {
+	l, r := d.db.EscapeCharacters()
+	return strings.NewReplacer("{", l, "}", r).Replace(statement)
+}
+
+func (d *DatabaseLock) session(ctx context.Context) *gorm.DB {
+	return d.db.DB().WithContext(ctx)
+}
+
+func (d *DatabaseLock) migrate(ctx context.Context) error {
+	db := d.session(ctx)
+	if db.Migrator().HasTable(d.table) {
+		return nil
+	}
+	text := lang.Ternary(d.db.Dialect() == types.Oracle, "VARCHAR2", "VARCHAR")
+	number := lang.Ternary(d.db.Dialect() == types.Oracle, "NUMBER(19)", "BIGINT")
+	err := db.Exec(d.q("CREATE TABLE {" + d.table + "} (" +
+		"{source} " + text + "(191) NOT NULL PRIMARY KEY, " +
+		"{owner} " + text + "(191) NOT NULL, " +
+		"{count} " + number + " NOT NULL, " +
+		"{expires_at} " + number + " NOT NULL)")).Error
+	if err != nil && db.Migrator().HasTable(d.table) {
+		// Created by another replica meanwhile
+		return nil
+	}
+	return err
+}
+
+// Lock tries to acquire source once, it returns false when another owner
+// holds it. Locking again with the same owner increases the reentrance count
+// and extends the lease.
+func (d *DatabaseLock) Lock(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
+	db, now := d.session(ctx), d.now()
+	expires := now.Add(timeout).UnixMilli()
+	r := db.Exec(d.q("UPDATE {"+d.table+"} SET {count} = {count} + 1, {expires_at} = ? "+
+		"WHERE {source} = ? AND {owner} = ? AND {expires_at} > ?"), expires, source, owner, now.UnixMilli())
+	if r.Error != nil || r.RowsAffected > 0 {
+		return r.Error == nil, r.Error
+	}
+	r = db.Exec(d.q("UPDATE {"+d.table+"} SET {owner} = ?, {count} = 1, {expires_at} = ? "+
+		"WHERE {source} = ? AND {expires_at} <= ?"), owner, expires, source, now.UnixMilli())
+	if r.Error != nil || r.RowsAffected > 0 {
+		return r.Error == nil, r.Error
+	}
+	err := db.Exec(d.q("INSERT INTO {"+d.table+"} ({source}, {owner}, {count}, {expires_at}) VALUES (?, ?, 1, ?)"),
+		source, owner, expires).Error
+	if err == nil {
+		return true, nil
+	}
+	// Inserting fails when another owner holds source
+	var rows int64
+	if e := db.Raw(d.q("SELECT COUNT(*) FROM {"+d.table+"} WHERE {source} = ?"), source).Scan(&rows).Error; e != nil ||
+		rows == 0 {
+		return false, err
+	}
+	return false, nil
+}
+
+// Unlock decreases the reentrance count of owner, the row is deleted once the
+// count drops to zero. It returns false when owner doesn't hold source.
+func (d *DatabaseLock) Unlock(ctx context.Context, source, owner string) (bool, error) {
+	db := d.session(ctx)
+	r := db.Exec(d.q("UPDATE {"+d.table+"} SET {count} = {count} - 1 "+
+		"WHERE {source} = ? AND {owner} = ? AND {expires_at} > ?"), source, owner, d.now().UnixMilli())
+	if r.Error != nil || r.RowsAffected == 0 {
+		return false, r.Error
+	}
+	err := db.Exec(d.q("DELETE FROM {"+d.table+"} WHERE {source} = ? AND {owner} = ? AND {count} <= 0"),
+		source, owner).Error
+	return err == nil, err
+}
+
+// Renew extends the lease of owner to timeout, it returns false when owner
+// doesn't hold source anymore.
+func (d *DatabaseLock) Renew(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
+	now := d.now()
+	r := d.session(ctx).Exec(d.q("UPDATE {"+d.table+"} SET {expires_at} = ? "+
+		"WHERE {source} = ? AND {owner} = ? AND {expires_at} > ?"),
+		now.Add(timeout).UnixMilli(), source, owner, now.UnixMilli())
+	if r.Error != nil || r.RowsAffected > 0 {
+		return r.Error == nil, r.Error
+	}
+	// MySQL does not count rows left unchanged, renewing twice in a millisecond
+	holder, err := d.Owner(ctx, source)
+	return holder == owner, err
+}
+
+// Owner returns the current holder of source, or an empty string.
+func (d *DatabaseLock) Owner(ctx context.Context, source string) (string, error) {
+	var owners []string
+	err := d.session(ctx).Raw(d.q("SELECT {owner} FROM {"+d.table+"} WHERE {source} = ? AND {expires_at} > ?"),
+		source, d.now().UnixMilli()).Scan(&owners).Error
+	if err != nil || len(owners) == 0 {
+		return "", err
+	}
+	return owners[0], nil
+}
@@ -0,0 +1,50 @@
+package synch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/orm"
+	"github.com/gantries/knife/pkg/orm/ormtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDatabaseLock(t *testing.T) (*DatabaseLock, *orm.Database) {
+	db := ormtest.NewSQLite(t)
+	d, m := NewDatabaseLock(context.Background(), db, &LockProperties{})
+	assert.True(t, m.Fine())
+	return d, db
+}
+
+func TestDatabaseLock(t *testing.T) {
+	d, db := newDatabaseLock(t)
+	assert.True(t, db.DB().Migrator().HasTable("knife_lock"))
+	testLock(t, d, shift(&d.now))
+}
+
+func TestDatabaseLock_Replicas(t *testing.T) {
+	ctxt := context.Background()
+	a, db := newDatabaseLock(t)
+	b, m := NewDatabaseLock(ctxt, db, &LockProperties{})
+	assert.True(t, m.Fine(), "existing tables are kept")
+	expire := shift(&a.now)
+	b.now = a.now
+
+	ok, _ := a.Lock(ctxt, "replicas", "a", time.Minute)
+	assert.True(t, ok)
+	ok, _ = b.Lock(ctxt, "replicas", "b", time.Minute)
+	assert.False(t, ok)
+	owner, err := b.Owner(ctxt, "replicas")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", owner)
+
+	expire()
+	owner, _ = b.Owner(ctxt, "replicas")
+	assert.Empty(t, owner)
+	ok, _ = b.Lock(ctxt, "replicas", "b", time.Minute)
+	assert.True(t, ok)
+	_, _ = b.Unlock(ctxt, "replicas", "b")
+	owner, _ = a.Owner(ctxt, "replicas")
+	assert.Empty(t, owner)
+}
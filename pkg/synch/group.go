@@ -0,0 +1,150 @@
+package synch
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/gantries/knife/pkg/tel"
+)
+
+// ErrClosed is returned when submitting to a closed Pool.
+var ErrClosed = stderrors.New("synch: pool is closed")
+
+// Option configures how the tasks of a Group, a Pool or RunN run.
+type Option func(*options)
+
+type options struct {
+	limit int
+	span  string
+}
+
+// WithLimit runs at most n tasks of a Group at the same time, non-positive
+// values do not limit them.
+func WithLimit(n int) Option {
+	return func(o *options) {
+		o.limit = n
+	}
+}
+
+// WithSpan traces every task in a tel.Span called name.
+func WithSpan(name string) Option {
+	return func(o *options) {
+		o.span = name
+	}
+}
+
+func optionsOf(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// call runs fn with ctx, traced when span is not empty. Panics are returned as
+// errors.
+func call(ctx context.Context, span string, fn func(ctx context.Context) error) (err error) {
+	if len(span) > 0 {
+		s := tel.Span(&ctx, span)
+		defer tel.Do(s, &err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Task panicked", "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("synch: task panicked: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
+
+// Group runs tasks in goroutines and waits for them, the context of the tasks
+// is cancelled by the first failure.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+	slots  chan struct{}
+	span   string
+	once   sync.Once
+	err    error
+}
+
+// NewGroup returns a group whose tasks run with a context derived from ctx,
+// which is returned as well.
+func NewGroup(ctx context.Context, opts ...Option) (*Group, context.Context) {
+	o := optionsOf(opts)
+	g := &Group{span: o.span}
+	g.ctx, g.cancel = context.WithCancelCause(ctx)
+	if o.limit > 0 {
+		g.slots = make(chan struct{}, o.limit)
+	}
+	return g, g.ctx
+}
+
+// Go runs fn in a goroutine, it blocks while the limit of tasks are running.
+// Tasks are not started once the context of the group is done.
+func (g *Group) Go(fn func(ctx context.Context) error) {
+	if g.ctx.Err() != nil {
+		g.fail(context.Cause(g.ctx))
+		return
+	}
+	if g.slots != nil {
+		select {
+		case g.slots <- struct{}{}:
+		case <-g.ctx.Done():
+			g.fail(context.Cause(g.ctx))
+			return
+		}
+	}
+	g.start(fn)
+}
+
+// TryGo runs fn in a goroutine unless the limit of tasks are running or the
+// context of the group is done, it returns whether fn was started.
+func (g *Group) TryGo(fn func(ctx context.Context) error) bool {
+	if g.ctx.Err() != nil {
+		g.fail(context.Cause(g.ctx))
+		return false
+	}
+	if g.slots != nil {
+		select {
+		case g.slots <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	g.start(fn)
+	return true
+}
+
+func (g *Group) start(fn func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if g.slots != nil {
+			defer func() { <-g.slots }()
+		}
+		if err := call(g.ctx, g.span, fn); err != nil {
+			g.fail(err)
+		}
+	}()
+}
+
+// fail records the first failure and cancels the other tasks.
+func (g *Group) fail(err error) {
+	g.once.Do(func() {
+		g.err = err
+		g.cancel(err)
+	})
+}
+
+// Wait waits for the tasks started and returns the first failure, a task not
+// started since the context was done fails with its cause.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel(nil)
+	return g.err
+}
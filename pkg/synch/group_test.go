@@ -0,0 +1,117 @@
+package synch
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/tel"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestGroup(t *testing.T) {
+	g, ctx := NewGroup(context.Background())
+	var done atomic.Int32
+	for range 5 {
+		g.Go(func(ctx context.Context) error {
+			done.Add(1)
+			return nil
+		})
+	}
+	assert.Nil(t, g.Wait())
+	assert.Equal(t, int32(5), done.Load())
+	assert.NotNil(t, ctx.Err(), "cancelled once waited for")
+}
+
+func TestGroup_Failure(t *testing.T) {
+	g, _ := NewGroup(context.Background())
+	failure := fmt.Errorf("unavailable")
+	cancelled := make(chan error, 1)
+	g.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		cancelled <- context.Cause(ctx)
+		return ctx.Err()
+	})
+	g.Go(func(ctx context.Context) error {
+		return failure
+	})
+	assert.Equal(t, failure, g.Wait(), "the first failure")
+	assert.Equal(t, failure, <-cancelled)
+
+	started := false
+	g.Go(func(ctx context.Context) error {
+		started = true
+		return nil
+	})
+	assert.False(t, g.TryGo(func(ctx context.Context) error {
+		started = true
+		return nil
+	}))
+	assert.Equal(t, failure, g.Wait())
+	assert.False(t, started, "not started once cancelled")
+
+	g, _ = NewGroup(context.Background())
+	g.Go(func(ctx context.Context) error {
+		panic("boom")
+	})
+	assert.ErrorContains(t, g.Wait(), "boom")
+}
+
+func TestGroup_Limit(t *testing.T) {
+	g, _ := NewGroup(context.Background(), WithLimit(2))
+	var running, peak atomic.Int32
+	for range 6 {
+		g.Go(func(ctx context.Context) error {
+			n := running.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+			return nil
+		})
+	}
+	assert.Nil(t, g.Wait())
+	assert.Equal(t, int32(2), peak.Load())
+
+	release := make(chan struct{})
+	g, _ = NewGroup(context.Background(), WithLimit(1))
+	assert.True(t, g.TryGo(func(ctx context.Context) error {
+		<-release
+		return nil
+	}))
+	assert.False(t, g.TryGo(func(ctx context.Context) error { return nil }))
+	close(release)
+	assert.Nil(t, g.Wait())
+
+	parent, cancel := context.WithCancel(context.Background())
+	g, _ = NewGroup(parent, WithLimit(1))
+	g.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	go cancel()
+	g.Go(func(ctx context.Context) error { return nil })
+	assert.ErrorIs(t, g.Wait(), context.Canceled, "tasks waiting for a slot are not started")
+}
+
+func TestGroup_Span(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer("test")
+	tel.SetupTracer(&tracer)
+	defer tel.SetupTracer(nil)
+
+	g, _ := NewGroup(context.Background(), WithSpan("import"))
+	g.Go(func(ctx context.Context) error {
+		return fmt.Errorf("unavailable")
+	})
+	assert.NotNil(t, g.Wait())
+	ended := spans.Ended()
+	assert.Len(t, ended, 1)
+	assert.Equal(t, "import", ended[0].Name())
+	assert.Equal(t, codes.Error, ended[0].Status().Code)
+}
@@ -0,0 +1,155 @@
+package synch
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// mutex is a mutex whose lock may be given up once a context is done.
+type mutex chan struct{}
+
+func (m mutex) lock(ctx context.Context) error {
+	select {
+	case m <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m mutex) tryLock() bool {
+	select {
+	case m <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m mutex) unlock() {
+	select {
+	case <-m:
+	default:
+		panic("synch: unlock of unlocked mutex")
+	}
+}
+
+// entry is the mutex of a key with the number of goroutines holding or
+// waiting for it.
+type entry struct {
+	mutex mutex
+	refs  int
+}
+
+// KeyedMutex is a mutex per key, such as one writer per tenant. The mutex of
+// a key is dropped once no goroutine holds or waits for it, so keys may be
+// unbounded.
+type KeyedMutex[K comparable] struct {
+	mutex   sync.Mutex
+	entries map[K]*entry
+}
+
+func NewKeyedMutex[K comparable]() *KeyedMutex[K] {
+	return &KeyedMutex[K]{entries: map[K]*entry{}}
+}
+
+// acquire returns the mutex of key, referenced until release.
+func (k *KeyedMutex[K]) acquire(key K) mutex {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	e, ok := k.entries[key]
+	if !ok {
+		e = &entry{mutex: make(mutex, 1)}
+		k.entries[key] = e
+	}
+	e.refs++
+	return e.mutex
+}
+
+func (k *KeyedMutex[K]) release(key K) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if e := k.entries[key]; e != nil {
+		if e.refs--; e.refs <= 0 {
+			delete(k.entries, key)
+		}
+	}
+}
+
+// Lock blocks until key is locked or ctx is done, the error of ctx is
+// returned then.
+func (k *KeyedMutex[K]) Lock(ctx context.Context, key K) error {
+	if err := k.acquire(key).lock(ctx); err != nil {
+		k.release(key)
+		return err
+	}
+	return nil
+}
+
+// TryLock locks key unless it is locked already, it returns whether key was
+// locked.
+func (k *KeyedMutex[K]) TryLock(key K) bool {
+	if !k.acquire(key).tryLock() {
+		k.release(key)
+		return false
+	}
+	return true
+}
+
+// Unlock unlocks key, it panics when key is not locked.
+func (k *KeyedMutex[K]) Unlock(key K) {
+	k.mutex.Lock()
+	e := k.entries[key]
+	k.mutex.Unlock()
+	if e == nil {
+		panic("synch: unlock of unlocked key")
+	}
+	e.mutex.unlock()
+	k.release(key)
+}
+
+// Len returns how many keys are locked or waited for.
+func (k *KeyedMutex[K]) Len() int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return len(k.entries)
+}
+
+// Striped maps keys to a fixed number of mutexes, keys sharing a stripe
+// exclude each other. Unlike a KeyedMutex it does not allocate per key.
+type Striped struct {
+	stripes []mutex
+}
+
+// NewStriped returns n stripes, at least one.
+func NewStriped(n int) *Striped {
+	s := &Striped{stripes: make([]mutex, max(1, n))}
+	for i := range s.stripes {
+		s.stripes[i] = make(mutex, 1)
+	}
+	return s
+}
+
+func (s *Striped) stripe(key string) mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return s.stripes[h.Sum32()%uint32(len(s.stripes))]
+}
+
+// Lock blocks until the stripe of key is locked or ctx is done, the error of
+// ctx is returned then.
+func (s *Striped) Lock(ctx context.Context, key string) error {
+	return s.stripe(key).lock(ctx)
+}
+
+// TryLock locks the stripe of key unless it is locked already, it returns
+// whether it was locked.
+func (s *Striped) TryLock(key string) bool {
+	return s.stripe(key).tryLock()
+}
+
+// Unlock unlocks the stripe of key, it panics when it is not locked.
+func (s *Striped) Unlock(key string) {
+	s.stripe(key).unlock()
+}
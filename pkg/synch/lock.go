@@ -13,3 +13,22 @@ type Lock interface {
 	Lock(ctx context.Context, source, owner string, timeout time.Duration) (bool, error)
 	Unlock(ctx context.Context, source, owner string) (bool, error)
 }
+
+// Renewer is implemented by the locks whose holders can extend their lease
+// without locking again, it returns false when owner doesn't hold source.
+type Renewer interface {
+	Renew(ctx context.Context, source, owner string, timeout time.Duration) (bool, error)
+}
+
+// Abandoner is implemented by the locks renewing leases in the background, it
+// stops renewing the lease of owner which then expires after its timeout
+// unless it is unlocked before.
+type Abandoner interface {
+	Abandon(source, owner string)
+}
+
+// Inspector is implemented by the locks which tell who holds a source, the
+// owner is empty when nobody does.
+type Inspector interface {
+	Owner(ctx context.Context, source string) (string, error)
+}
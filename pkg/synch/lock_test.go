@@ -2,8 +2,13 @@ package synch
 
 import (
 	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 type mockLock struct {
@@ -52,3 +57,119 @@ func TestUnlock(t *testing.T) {
 		t.Error("Unlock() was not called")
 	}
 }
+
+// testLock checks that l is a reentrant Lock, expire makes the leases taken
+// so far expire and is nil for locks which cannot be expired by tests.
+func testLock(t *testing.T, l Lock, expire func()) {
+	ctxt := context.Background()
+	prefix := "conformance-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "/"
+
+	t.Run("Exclusive", func(t *testing.T) {
+		source := prefix + "exclusive"
+		ok, err := l.Lock(ctxt, source, "a", time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ok, err = l.Lock(ctxt, source, "b", time.Minute)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		ok, err = l.Unlock(ctxt, source, "b")
+		assert.Nil(t, err)
+		assert.False(t, ok, "only the owner unlocks")
+		ok, _ = l.Unlock(ctxt, source, "a")
+		assert.True(t, ok)
+		ok, _ = l.Unlock(ctxt, source, "a")
+		assert.False(t, ok, "not held anymore")
+		ok, _ = l.Lock(ctxt, source, "b", time.Minute)
+		assert.True(t, ok)
+		_, _ = l.Unlock(ctxt, source, "b")
+	})
+
+	t.Run("Reentrant", func(t *testing.T) {
+		source := prefix + "reentrant"
+		for range 2 {
+			ok, err := l.Lock(ctxt, source, "a", time.Minute)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+		}
+		ok, _ := l.Unlock(ctxt, source, "a")
+		assert.True(t, ok)
+		ok, _ = l.Lock(ctxt, source, "b", time.Minute)
+		assert.False(t, ok, "held until unlocked as often as locked")
+		ok, _ = l.Unlock(ctxt, source, "a")
+		assert.True(t, ok)
+		ok, _ = l.Lock(ctxt, source, "b", time.Minute)
+		assert.True(t, ok)
+		_, _ = l.Unlock(ctxt, source, "b")
+	})
+
+	t.Run("Renew", func(t *testing.T) {
+		r, ok := l.(Renewer)
+		if !ok {
+			t.Skip("not a Renewer")
+		}
+		source := prefix + "renew"
+		ok, err := r.Renew(ctxt, source, "a", time.Minute)
+		assert.Nil(t, err)
+		assert.False(t, ok, "not held")
+		_, _ = l.Lock(ctxt, source, "a", time.Minute)
+		for range 2 {
+			ok, err = r.Renew(ctxt, source, "a", time.Minute)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+		}
+		ok, _ = r.Renew(ctxt, source, "b", time.Minute)
+		assert.False(t, ok)
+		ok, _ = l.Unlock(ctxt, source, "a")
+		assert.True(t, ok, "renewing keeps the count")
+		ok, _ = l.Lock(ctxt, source, "b", time.Minute)
+		assert.True(t, ok)
+		_, _ = l.Unlock(ctxt, source, "b")
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		source := prefix + "concurrent"
+		var wg sync.WaitGroup
+		var locked atomic.Int32
+		for i := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ok, err := l.Lock(ctxt, source, strconv.Itoa(i), time.Minute)
+				assert.Nil(t, err)
+				if ok {
+					locked.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), locked.Load())
+		for i := range 8 {
+			_, _ = l.Unlock(ctxt, source, strconv.Itoa(i))
+		}
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		if expire == nil {
+			t.Skip("leases cannot be expired")
+		}
+		source := prefix + "expire"
+		ok, _ := l.Lock(ctxt, source, "a", time.Minute)
+		assert.True(t, ok)
+		_, _ = l.Lock(ctxt, source, "a", time.Minute)
+		expire()
+		ok, err := l.Lock(ctxt, source, "b", time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, ok, "taken over once expired")
+		ok, _ = l.Unlock(ctxt, source, "a")
+		assert.False(t, ok)
+		if r, ok := l.(Renewer); ok {
+			ok, _ = r.Renew(ctxt, source, "a", time.Minute)
+			assert.False(t, ok)
+		}
+		ok, _ = l.Unlock(ctxt, source, "b")
+		assert.True(t, ok, "the count of the previous owner is dropped")
+		ok, _ = l.Lock(ctxt, source, "a", time.Minute)
+		assert.True(t, ok)
+		_, _ = l.Unlock(ctxt, source, "a")
+	})
+}
@@ -0,0 +1,91 @@
+package synch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryLease is a source held by owner count times until it expires.
+type memoryLease struct {
+	owner   string
+	count   int
+	expires time.Time
+}
+
+// MemoryLock is a reentrant Lock of a single process, for tests and single
+// node deployments. Leases expire after their timeout unless renewed.
+type MemoryLock struct {
+	mutex  sync.Mutex
+	leases map[string]*memoryLease
+	now    func() time.Time
+}
+
+func NewMemoryLock() *MemoryLock {
+	return &MemoryLock{leases: map[string]*memoryLease{}, now: time.Now}
+}
+
+// held returns the lease of source unless it expired.
+func (m *MemoryLock) held(source string) *memoryLease {
+	if l, ok := m.leases[source]; ok && m.now().Before(l.expires) {
+		return l
+	}
+	delete(m.leases, source)
+	return nil
+}
+
+// Lock tries to acquire source once, it returns false when another owner
+// holds it. Locking again with the same owner increases the reentrance count
+// and extends the lease.
+func (m *MemoryLock) Lock(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	l := m.held(source)
+	if l == nil {
+		l = &memoryLease{owner: owner}
+		m.leases[source] = l
+	} else if l.owner != owner {
+		return false, nil
+	}
+	l.count++
+	l.expires = m.now().Add(timeout)
+	return true, nil
+}
+
+// Unlock decreases the reentrance count of owner, the lock is released once
+// the count drops to zero. It returns false when owner doesn't hold source.
+func (m *MemoryLock) Unlock(ctx context.Context, source, owner string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	l := m.held(source)
+	if l == nil || l.owner != owner {
+		return false, nil
+	}
+	if l.count--; l.count <= 0 {
+		delete(m.leases, source)
+	}
+	return true, nil
+}
+
+// Renew extends the lease of owner to timeout, it returns false when owner
+// doesn't hold source anymore.
+func (m *MemoryLock) Renew(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	l := m.held(source)
+	if l == nil || l.owner != owner {
+		return false, nil
+	}
+	l.expires = m.now().Add(timeout)
+	return true, nil
+}
+
+// Owner returns the current holder of source, or an empty string.
+func (m *MemoryLock) Owner(ctx context.Context, source string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if l := m.held(source); l != nil {
+		return l.owner, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,38 @@
+package synch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// shift moves the clock of a lock by an hour every time it is called.
+func shift(now *func() time.Time) func() {
+	var offset time.Duration
+	*now = func() time.Time {
+		return time.Now().Add(offset)
+	}
+	return func() {
+		offset += time.Hour
+	}
+}
+
+func TestMemoryLock(t *testing.T) {
+	m := NewMemoryLock()
+	testLock(t, m, shift(&m.now))
+}
+
+func TestMemoryLock_Owner(t *testing.T) {
+	ctxt := context.Background()
+	m := NewMemoryLock()
+	expire := shift(&m.now)
+	_, _ = m.Lock(ctxt, "owner", "a", time.Minute)
+	owner, err := m.Owner(ctxt, "owner")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", owner)
+	expire()
+	owner, _ = m.Owner(ctxt, "owner")
+	assert.Empty(t, owner)
+}
@@ -0,0 +1,125 @@
+package synch
+
+import (
+	"context"
+	"sync"
+)
+
+// task is a function submitted to a pool with the context it was submitted
+// with, its error is sent to result.
+type task struct {
+	ctx    context.Context
+	fn     func(ctx context.Context) error
+	result chan error
+}
+
+// Pool runs the submitted tasks on a fixed number of workers, tasks wait in a
+// bounded queue until a worker is free.
+type Pool struct {
+	ctx   context.Context
+	tasks chan task
+	span  string
+	// mutex is held for reading while sending to tasks, closing wakes up the
+	// senders blocked on a full queue so that Close may close tasks.
+	mutex   sync.RWMutex
+	closing chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// NewPool starts workers which run the tasks of a queue of size queue.
+// Cancelling ctx cancels the context of the tasks, which keep running until
+// they return.
+func NewPool(ctx context.Context, workers, queue int, opts ...Option) *Pool {
+	o := optionsOf(opts)
+	p := &Pool{ctx: ctx, tasks: make(chan task, max(0, queue)), span: o.span, closing: make(chan struct{})}
+	for range max(1, workers) {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for t := range p.tasks {
+		p.run(t)
+	}
+}
+
+// run calls t with the values of the context it was submitted with, but
+// cancelled by the context of the pool only.
+func (p *Pool) run(t task) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(t.ctx))
+	defer cancel()
+	stop := context.AfterFunc(p.ctx, cancel)
+	defer stop()
+	t.result <- call(ctx, p.span, t.fn)
+}
+
+// Submit queues fn, it blocks while the queue is full until ctx is done. The
+// returned channel receives the error of fn once it ran.
+func (p *Pool) Submit(ctx context.Context, fn func(ctx context.Context) error) (<-chan error, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.isClosing() {
+		return nil, ErrClosed
+	}
+	t := task{ctx: ctx, fn: fn, result: make(chan error, 1)}
+	select {
+	case p.tasks <- t:
+		return t.result, nil
+	case <-p.closing:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TrySubmit queues fn unless the queue is full, it returns whether fn was
+// queued.
+func (p *Pool) TrySubmit(ctx context.Context, fn func(ctx context.Context) error) (<-chan error, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.isClosing() {
+		return nil, false
+	}
+	t := task{ctx: ctx, fn: fn, result: make(chan error, 1)}
+	select {
+	case p.tasks <- t:
+		return t.result, true
+	default:
+		return nil, false
+	}
+}
+
+func (p *Pool) isClosing() bool {
+	select {
+	case <-p.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops accepting tasks and waits for the queued ones to run until ctx
+// is done.
+func (p *Pool) Close(ctx context.Context) error {
+	p.once.Do(func() {
+		close(p.closing)
+		p.mutex.Lock()
+		close(p.tasks)
+		p.mutex.Unlock()
+	})
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,118 @@
+package synch
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type key struct{}
+
+func TestPool(t *testing.T) {
+	ctxt := context.Background()
+	p := NewPool(ctxt, 2, 4)
+	var done atomic.Int32
+	var results []<-chan error
+	for i := range 6 {
+		r, err := p.Submit(ctxt, func(ctx context.Context) error {
+			done.Add(1)
+			if i == 0 {
+				return fmt.Errorf("unavailable")
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+		results = append(results, r)
+	}
+	assert.NotNil(t, <-results[0])
+	for _, r := range results[1:] {
+		assert.Nil(t, <-r)
+	}
+	assert.Nil(t, p.Close(ctxt))
+	assert.Equal(t, int32(6), done.Load())
+	_, err := p.Submit(ctxt, func(ctx context.Context) error { return nil })
+	assert.ErrorIs(t, err, ErrClosed)
+	_, ok := p.TrySubmit(ctxt, func(ctx context.Context) error { return nil })
+	assert.False(t, ok)
+	assert.Nil(t, p.Close(ctxt), "closing twice is harmless")
+}
+
+func TestPool_Bounded(t *testing.T) {
+	ctxt := context.Background()
+	p := NewPool(ctxt, 1, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	block := func(ctx context.Context) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}
+	_, err := p.Submit(ctxt, block)
+	assert.Nil(t, err)
+	<-started
+	_, ok := p.TrySubmit(ctxt, block)
+	assert.True(t, ok, "queued")
+	_, ok = p.TrySubmit(ctxt, block)
+	assert.False(t, ok, "the queue is full")
+	timeout, cancel := context.WithTimeout(ctxt, 10*time.Millisecond)
+	defer cancel()
+	_, err = p.Submit(timeout, block)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	closing, cancelClose := context.WithTimeout(ctxt, 10*time.Millisecond)
+	defer cancelClose()
+	assert.ErrorIs(t, p.Close(closing), context.DeadlineExceeded, "the queued task did not run yet")
+	close(release)
+	<-started
+	assert.Nil(t, p.Close(ctxt))
+}
+
+func TestPool_CloseBlocked(t *testing.T) {
+	ctxt := context.Background()
+	p := NewPool(ctxt, 1, 0)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	_, err := p.Submit(ctxt, func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	assert.Nil(t, err)
+	<-started
+	// Blocked on the full queue until the pool closes
+	submitted := make(chan error)
+	go func() {
+		_, err := p.Submit(ctxt, func(ctx context.Context) error { return nil })
+		submitted <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	closing, cancel := context.WithTimeout(ctxt, 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, p.Close(closing), context.DeadlineExceeded, "the running task did not return yet")
+	assert.ErrorIs(t, <-submitted, ErrClosed)
+	close(release)
+	assert.Nil(t, p.Close(ctxt))
+}
+
+func TestPool_Context(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	p := NewPool(parent, 1, 0)
+	submitted, stop := context.WithCancel(context.WithValue(context.Background(), key{}, "v"))
+	r, err := p.Submit(submitted, func(ctx context.Context) error {
+		assert.Equal(t, "v", ctx.Value(key{}))
+		stop()
+		time.Sleep(5 * time.Millisecond)
+		assert.Nil(t, ctx.Err(), "not cancelled by the submitter")
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.Nil(t, err)
+	assert.ErrorIs(t, <-r, context.Canceled, "cancelled by the pool")
+	assert.Nil(t, p.Close(context.Background()))
+}
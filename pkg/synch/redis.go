@@ -0,0 +1,241 @@
+package synch
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gantries/knife/pkg/cache"
+	"github.com/gantries/knife/pkg/log"
+	"github.com/redis/go-redis/v9"
+)
+
+var logger = log.New("knife/synch")
+
+// The lock is a hash holding the owner, the reentrance count and the fencing
+// token. The token comes from a counter which never expires, so every new
+// holder of a source gets a larger token than the previous one.
+var (
+	acquireScript = redis.NewScript(`
+local owner = redis.call('hget', KEYS[1], 'owner')
+if not owner then
+  local token = redis.call('incr', KEYS[2])
+  redis.call('hset', KEYS[1], 'owner', ARGV[1], 'count', 1, 'token', token)
+  redis.call('pexpire', KEYS[1], ARGV[2])
+  return token
+end
+if owner == ARGV[1] then
+  redis.call('hincrby', KEYS[1], 'count', 1)
+  redis.call('pexpire', KEYS[1], ARGV[2])
+  return tonumber(redis.call('hget', KEYS[1], 'token'))
+end
+return 0`)
+	releaseScript = redis.NewScript(`
+if redis.call('hget', KEYS[1], 'owner') ~= ARGV[1] then
+  return -1
+end
+local count = redis.call('hincrby', KEYS[1], 'count', -1)
+if count <= 0 then
+  redis.call('del', KEYS[1])
+  return 0
+end
+return count`)
+	renewScript = redis.NewScript(`
+if redis.call('hget', KEYS[1], 'owner') == ARGV[1] then
+  return redis.call('pexpire', KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+type LockProperties struct {
+	Prefix string `json:"prefix" yaml:"prefix" default:"knife/lock/"`
+	// Table keeps the leases of a DatabaseLock.
+	Table string  `json:"table" yaml:"table" default:"knife_lock"`
+	Retry Backoff `json:"retry" yaml:"retry"`
+}
+
+func (p *LockProperties) table() string {
+	if len(p.Table) > 0 {
+		return p.Table
+	}
+	return "knife_lock"
+}
+
+// Lease is held by an owner while it has a source locked.
+type Lease struct {
+	Source string
+	Owner  string
+	// Token increases with every new holder of Source, pass it to the storage
+	// being protected so writes of a previous holder can be rejected.
+	Token  int64
+	lost   chan struct{}
+	cancel context.CancelFunc
+}
+
+// Lost is closed when the lease could not be renewed before it expired.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// RedisLock is a reentrant Lock stored in redis. While an owner holds a source
+// a watchdog renews the lease every third of its timeout, so critical sections
+// may take longer than the timeout. The timeout only matters when the holder
+// dies.
+type RedisLock struct {
+	cache  *cache.RedisCache
+	redis  redis.Scripter
+	props  LockProperties
+	mutex  sync.Mutex
+	leases map[string]*Lease
+}
+
+func NewRedisLock(c *cache.RedisCache, props *LockProperties) *RedisLock {
+	return &RedisLock{cache: c, redis: c.Scripter(), props: *props, leases: map[string]*Lease{}}
+}
+
+func (r *RedisLock) keys(source string) []string {
+	// the hash tag keeps both keys in the same cluster slot
+	key := r.props.Prefix + "{" + source + "}"
+	return []string{key, key + "/fence"}
+}
+
+// Lock tries to acquire source once, it returns false when another owner
+// holds it. Locking again with the same owner increases the reentrance count.
+func (r *RedisLock) Lock(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
+	l, err := r.lock(ctx, source, owner, timeout)
+	return l != nil, err
+}
+
+func (r *RedisLock) lock(ctx context.Context, source, owner string, timeout time.Duration) (*Lease, error) {
+	token, err := acquireScript.Run(ctx, r.redis, r.keys(source), owner, timeout.Milliseconds()).Int64()
+	if err != nil || token == 0 {
+		return nil, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	id := source + "\x00" + owner
+	if l, ok := r.leases[id]; ok {
+		if l.Token == token {
+			return l, nil
+		}
+		// expired before its watchdog noticed
+		l.cancel()
+		close(l.lost)
+	}
+	ctxt, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	l := &Lease{Source: source, Owner: owner, Token: token, lost: make(chan struct{}), cancel: cancel}
+	r.leases[id] = l
+	go r.watch(ctxt, l, timeout)
+	return l, nil
+}
+
+// Acquire blocks until source is locked for owner or ctx is done. Attempts
+// are spaced by the retry backoff of the lock properties.
+func (r *RedisLock) Acquire(ctx context.Context, source, owner string, timeout time.Duration) (*Lease, error) {
+	for attempt := 0; ; attempt++ {
+		l, err := r.lock(ctx, source, owner, timeout)
+		if err != nil || l != nil {
+			return l, err
+		}
+		if err := r.props.Retry.Wait(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Unlock decreases the reentrance count of owner, the lock is deleted once
+// the count drops to zero. It returns false when owner doesn't hold source.
+func (r *RedisLock) Unlock(ctx context.Context, source, owner string) (bool, error) {
+	count, err := releaseScript.Run(ctx, r.redis, r.keys(source)[:1], owner).Int64()
+	if err != nil {
+		return false, err
+	}
+	if count <= 0 {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if l, ok := r.leases[source+"\x00"+owner]; ok {
+			delete(r.leases, source+"\x00"+owner)
+			l.cancel()
+		}
+	}
+	return count >= 0, nil
+}
+
+// Renew extends the lease of owner to timeout, it returns false when owner
+// doesn't hold source anymore.
+func (r *RedisLock) Renew(ctx context.Context, source, owner string, timeout time.Duration) (bool, error) {
+	n, err := renewScript.Run(ctx, r.redis, r.keys(source)[:1], owner, timeout.Milliseconds()).Int64()
+	return n == 1, err
+}
+
+// Owner returns the current holder of source, or an empty string.
+func (r *RedisLock) Owner(ctx context.Context, source string) (string, error) {
+	owner, err := r.cache.HGet(ctx, r.keys(source)[0], "owner")
+	if err == cache.Nil {
+		return "", nil
+	}
+	return owner, err
+}
+
+// Lease returns the lease owner holds on source in this process, or nil.
+func (r *RedisLock) Lease(source, owner string) *Lease {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.leases[source+"\x00"+owner]
+}
+
+// Abandon stops the watchdog of the lease owner holds on source in this
+// process, the lease expires after its timeout unless it is unlocked.
+func (r *RedisLock) Abandon(source, owner string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if l, ok := r.leases[source+"\x00"+owner]; ok {
+		delete(r.leases, source+"\x00"+owner)
+		l.cancel()
+	}
+}
+
+// lose drops l unless it has been released or replaced in the meantime.
+func (r *RedisLock) lose(l *Lease) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	id := l.Source + "\x00" + l.Owner
+	if r.leases[id] == l {
+		delete(r.leases, id)
+		l.cancel()
+		close(l.lost)
+	}
+}
+
+func (r *RedisLock) watch(ctx context.Context, l *Lease, timeout time.Duration) {
+	interval := timeout / 3
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := r.Renew(ctx, l.Source, l.Owner, timeout)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				logger.Warn("Unable to renew lock", "error", err, "source", l.Source, "owner", l.Owner)
+				if time.Since(renewed) < timeout {
+					continue
+				}
+			}
+			if !ok {
+				logger.Error("Lock lost", "source", l.Source, "owner", l.Owner, "token", l.Token)
+				r.lose(l)
+				return
+			}
+			renewed = time.Now()
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package synch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/cache"
+	"github.com/gantries/knife/pkg/lists"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRedisLock(t *testing.T) *RedisLock {
+	c, m := cache.NewRedis(context.Background(), &cache.Properties{
+		Type:      cache.Redis,
+		Addresses: *lists.Of[string]("127.0.0.1:6379"),
+	})
+	if !m.Fine() {
+		t.Skip("Redis not available")
+	}
+	return NewRedisLock(c, &LockProperties{
+		Prefix: "knife/ut/lock/",
+		Retry:  Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2},
+	})
+}
+
+func TestRedisLock(t *testing.T) {
+	testLock(t, newRedisLock(t), nil)
+}
+
+func TestRedisLock_Reentrant(t *testing.T) {
+	ctxt := context.Background()
+	l := newRedisLock(t)
+	source := "reentrant-" + time.Now().String()
+
+	ok, err := l.Lock(ctxt, source, "a", time.Second)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = l.Lock(ctxt, source, "a", time.Second)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = l.Lock(ctxt, source, "b", time.Second)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	owner, err := l.Owner(ctxt, source)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", owner)
+
+	ok, err = l.Unlock(ctxt, source, "b")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = l.Unlock(ctxt, source, "a")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.NotNil(t, l.Lease(source, "a"))
+	ok, err = l.Unlock(ctxt, source, "a")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, l.Lease(source, "a"))
+
+	ok, err = l.Lock(ctxt, source, "b", time.Second)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, _ = l.Unlock(ctxt, source, "b")
+}
+
+func TestRedisLock_Fencing(t *testing.T) {
+	ctxt := context.Background()
+	l := newRedisLock(t)
+	source := "fencing-" + time.Now().String()
+
+	first, err := l.Acquire(ctxt, source, "a", time.Second)
+	assert.Nil(t, err)
+	_, _ = l.Unlock(ctxt, source, "a")
+	second, err := l.Acquire(ctxt, source, "b", time.Second)
+	assert.Nil(t, err)
+	assert.True(t, second.Token > first.Token)
+	_, _ = l.Unlock(ctxt, source, "b")
+}
+
+func TestRedisLock_Watchdog(t *testing.T) {
+	ctxt := context.Background()
+	l := newRedisLock(t)
+	source := "watchdog-" + time.Now().String()
+
+	lease, err := l.Acquire(ctxt, source, "a", 300*time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(time.Second)
+	select {
+	case <-lease.Lost():
+		t.Fatal("lease should be renewed")
+	default:
+	}
+
+	deadline, cancel := context.WithTimeout(ctxt, 200*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(deadline, source, "b", time.Second)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	_, _ = l.Unlock(ctxt, source, "a")
+	_, err = l.Acquire(ctxt, source, "b", time.Second)
+	assert.Nil(t, err)
+	_, _ = l.Unlock(ctxt, source, "b")
+}
+
+func TestRedisLock_Abandon(t *testing.T) {
+	ctxt := context.Background()
+	l := newRedisLock(t)
+	source := "abandon-" + time.Now().String()
+
+	_, err := l.Acquire(ctxt, source, "a", 300*time.Millisecond)
+	assert.Nil(t, err)
+	l.Abandon(source, "a")
+	assert.Nil(t, l.Lease(source, "a"))
+
+	deadline, cancel := context.WithTimeout(ctxt, time.Second)
+	defer cancel()
+	_, err = l.Acquire(deadline, source, "b", time.Second)
+	assert.Nil(t, err)
+	_, _ = l.Unlock(ctxt, source, "b")
+}
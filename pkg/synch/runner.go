@@ -1,10 +1,15 @@
 package synch
 
 import (
+	"context"
+	stderrors "errors"
 	"reflect"
 	"sync"
 )
 
+// ErrExhausted is returned by the runs of a Limited beyond its count.
+var ErrExhausted = stderrors.New("synch: runs are exhausted")
+
 type Executor struct {
 	mutex sync.Mutex
 	count int
@@ -23,6 +28,9 @@ func (o *Executor) Run(argv ...reflect.Value) []reflect.Value {
 	}
 }
 
+// Runner runs fn at most repeat times.
+//
+// Deprecated: fn is called through reflection, use RunN instead.
 func Runner(repeat int, fn any) *Executor {
 	return &Executor{
 		mutex: sync.Mutex{},
@@ -30,3 +38,40 @@ func Runner(repeat int, fn any) *Executor {
 		fn:    reflect.ValueOf(fn),
 	}
 }
+
+// Limited runs a function a limited number of times.
+type Limited[T any] struct {
+	mutex sync.Mutex
+	count int
+	fn    func(ctx context.Context) (T, error)
+	span  string
+}
+
+// RunN returns a Limited running fn at most n times, the runs may overlap.
+func RunN[T any](n int, fn func(ctx context.Context) (T, error), opts ...Option) *Limited[T] {
+	return &Limited[T]{count: n, fn: fn, span: optionsOf(opts).span}
+}
+
+// Run calls fn unless it ran n times already, ErrExhausted is returned then.
+func (l *Limited[T]) Run(ctx context.Context) (T, error) {
+	var v T
+	l.mutex.Lock()
+	if l.count <= 0 {
+		l.mutex.Unlock()
+		return v, ErrExhausted
+	}
+	l.count--
+	l.mutex.Unlock()
+	err := call(ctx, l.span, func(ctx context.Context) (err error) {
+		v, err = l.fn(ctx)
+		return err
+	})
+	return v, err
+}
+
+// Remaining returns how many more times fn may run.
+func (l *Limited[T]) Remaining() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return max(0, l.count)
+}
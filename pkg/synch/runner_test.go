@@ -1,6 +1,8 @@
 package synch
 
 import (
+	"context"
+	"fmt"
 	"math"
 	"reflect"
 	"testing"
@@ -40,3 +42,32 @@ func TestRunner(t *testing.T) {
 	assert.Nil(t, e)
 	assert.True(t, string(s) == "[\"nothing serious\"]")
 }
+
+func TestRunN(t *testing.T) {
+	ctxt := context.Background()
+	calls := 0
+	r := RunN(2, func(ctx context.Context) (int, error) {
+		calls++
+		return calls, nil
+	})
+	assert.Equal(t, 2, r.Remaining())
+	for i := 1; i <= 2; i++ {
+		v, err := r.Run(ctxt)
+		assert.Nil(t, err)
+		assert.Equal(t, i, v)
+	}
+	v, err := r.Run(ctxt)
+	assert.ErrorIs(t, err, ErrExhausted)
+	assert.Equal(t, 0, v)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 0, r.Remaining())
+
+	failing := RunN(1, func(ctx context.Context) (string, error) {
+		return "partial", fmt.Errorf("unavailable")
+	})
+	s, err := failing.Run(ctxt)
+	assert.NotNil(t, err)
+	assert.Equal(t, "partial", s)
+	_, err = failing.Run(ctxt)
+	assert.ErrorIs(t, err, ErrExhausted, "failed runs count")
+}
@@ -0,0 +1,187 @@
+package synch
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gantries/knife/pkg/cache"
+	"github.com/gantries/knife/pkg/lang"
+	"github.com/redis/go-redis/v9"
+)
+
+// The semaphore is a sorted set of permits scored by the time their lease
+// expires, taken from the clock of redis so that replicas agree on it. Expired
+// permits are dropped before counting the ones held.
+var (
+	permitScript = redis.NewScript(`
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call('zremrangebyscore', KEYS[1], '-inf', now)
+if not redis.call('zscore', KEYS[1], ARGV[1]) and redis.call('zcard', KEYS[1]) >= tonumber(ARGV[2]) then
+  return 0
+end
+redis.call('zadd', KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+local last = redis.call('zrange', KEYS[1], -1, -1, 'withscores')
+redis.call('pexpireat', KEYS[1], last[2])
+return 1`)
+	renewPermitScript = redis.NewScript(`
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expires = redis.call('zscore', KEYS[1], ARGV[1])
+if not expires or tonumber(expires) <= now then
+  return 0
+end
+redis.call('zadd', KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+local last = redis.call('zrange', KEYS[1], -1, -1, 'withscores')
+redis.call('pexpireat', KEYS[1], last[2])
+return 1`)
+	releasePermitScript = redis.NewScript(`
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expires = redis.call('zscore', KEYS[1], ARGV[1])
+redis.call('zrem', KEYS[1], ARGV[1])
+if not expires or tonumber(expires) <= now then
+  return 0
+end
+return 1`)
+	heldScript = redis.NewScript(`
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+return redis.call('zcount', KEYS[1], '(' .. now, '+inf')`)
+)
+
+type SemaphoreProperties struct {
+	Prefix string `json:"prefix" yaml:"prefix" default:"knife/semaphore/"`
+	// Limit is how many permits may be held at the same time.
+	Limit int `json:"limit" yaml:"limit" default:"1"`
+	// Lease is how long a permit outlives its holder.
+	Lease time.Duration `json:"lease" yaml:"lease" default:"30s"`
+	Retry Backoff       `json:"retry" yaml:"retry"`
+}
+
+func (p *SemaphoreProperties) limit() int {
+	if p.Limit > 0 {
+		return p.Limit
+	}
+	return 1
+}
+
+func (p *SemaphoreProperties) lease() time.Duration {
+	if p.Lease > 0 {
+		return p.Lease
+	}
+	return 30 * time.Second
+}
+
+// Permit is held on a semaphore until released or lost.
+type Permit struct {
+	Name   string
+	ID     string
+	sem    *RedisSemaphore
+	once   sync.Once
+	lost   chan struct{}
+	cancel context.CancelFunc
+}
+
+// Lost is closed when the permit could not be renewed before it expired.
+func (p *Permit) Lost() <-chan struct{} {
+	return p.lost
+}
+
+// Release gives the permit back, it returns false when the permit expired
+// already.
+func (p *Permit) Release(ctx context.Context) (bool, error) {
+	p.once.Do(p.cancel)
+	n, err := releasePermitScript.Run(ctx, p.sem.redis, []string{p.sem.key(p.Name)}, p.ID).Int64()
+	return n == 1, err
+}
+
+// RedisSemaphore limits how many permits of a name are held across
+// processes. Like the leases of a RedisLock, a watchdog renews held permits
+// every third of their lease, the lease only matters when the holder dies.
+type RedisSemaphore struct {
+	redis redis.Scripter
+	props SemaphoreProperties
+}
+
+func NewRedisSemaphore(c *cache.RedisCache, props *SemaphoreProperties) *RedisSemaphore {
+	return &RedisSemaphore{redis: c.Scripter(), props: *props}
+}
+
+func (s *RedisSemaphore) key(name string) string {
+	return s.props.Prefix + "{" + name + "}"
+}
+
+// TryAcquire takes a permit of name once, it returns nil when the limit of
+// permits are held.
+func (s *RedisSemaphore) TryAcquire(ctx context.Context, name string) (*Permit, error) {
+	id := lang.StringUUID()
+	lease := s.props.lease()
+	n, err := permitScript.Run(ctx, s.redis, []string{s.key(name)}, id, s.props.limit(), lease.Milliseconds()).Int64()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	ctxt, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	p := &Permit{Name: name, ID: id, sem: s, lost: make(chan struct{}), cancel: cancel}
+	go s.watch(ctxt, p, lease)
+	return p, nil
+}
+
+// Acquire blocks until a permit of name is taken or ctx is done. Attempts are
+// spaced by the retry backoff of the semaphore properties.
+func (s *RedisSemaphore) Acquire(ctx context.Context, name string) (*Permit, error) {
+	for attempt := 0; ; attempt++ {
+		p, err := s.TryAcquire(ctx, name)
+		if err != nil || p != nil {
+			return p, err
+		}
+		if err := s.props.Retry.Wait(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Held returns how many permits of name are held.
+func (s *RedisSemaphore) Held(ctx context.Context, name string) (int, error) {
+	return heldScript.Run(ctx, s.redis, []string{s.key(name)}).Int()
+}
+
+func (s *RedisSemaphore) renew(ctx context.Context, p *Permit, lease time.Duration) (bool, error) {
+	n, err := renewPermitScript.Run(ctx, s.redis, []string{s.key(p.Name)}, p.ID, lease.Milliseconds()).Int64()
+	return n == 1, err
+}
+
+func (s *RedisSemaphore) watch(ctx context.Context, p *Permit, lease time.Duration) {
+	interval := lease / 3
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := s.renew(ctx, p, lease)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				logger.Warn("Unable to renew permit", "error", err, "name", p.Name, "permit", p.ID)
+				if time.Since(renewed) < lease {
+					continue
+				}
+			}
+			if !ok {
+				logger.Error("Permit lost", "name", p.Name, "permit", p.ID)
+				p.once.Do(p.cancel)
+				close(p.lost)
+				return
+			}
+			renewed = time.Now()
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package synch
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gantries/knife/pkg/cache"
+	"github.com/gantries/knife/pkg/lists"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// counting counts the scripts run on redis.
+type counting struct {
+	redis.Scripter
+	runs atomic.Int32
+}
+
+func (c *counting) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	c.runs.Add(1)
+	return c.Scripter.EvalSha(ctx, sha1, keys, args...)
+}
+
+func newRedisSemaphore(t *testing.T, limit int, lease time.Duration) *RedisSemaphore {
+	c, m := cache.NewRedis(context.Background(), &cache.Properties{
+		Type:      cache.Redis,
+		Addresses: *lists.Of[string]("127.0.0.1:6379"),
+	})
+	if !m.Fine() {
+		t.Skip("Redis not available")
+	}
+	return NewRedisSemaphore(c, &SemaphoreProperties{
+		Prefix: "knife/ut/semaphore/",
+		Limit:  limit,
+		Lease:  lease,
+		Retry:  Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2},
+	})
+}
+
+func TestRedisSemaphore(t *testing.T) {
+	ctxt := context.Background()
+	s := newRedisSemaphore(t, 2, time.Second)
+	name := "limit-" + time.Now().String()
+
+	a, err := s.TryAcquire(ctxt, name)
+	assert.Nil(t, err)
+	assert.NotNil(t, a)
+	b, err := s.Acquire(ctxt, name)
+	assert.Nil(t, err)
+	assert.NotNil(t, b)
+	assert.NotEqual(t, a.ID, b.ID)
+	p, err := s.TryAcquire(ctxt, name)
+	assert.Nil(t, err)
+	assert.Nil(t, p)
+	held, err := s.Held(ctxt, name)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, held)
+
+	ctx, cancel := context.WithTimeout(ctxt, 50*time.Millisecond)
+	defer cancel()
+	_, err = s.Acquire(ctx, name)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Released permits are taken by waiters
+	acquired := make(chan *Permit)
+	go func() {
+		p, _ := s.Acquire(ctxt, name)
+		acquired <- p
+	}()
+	ok, err := a.Release(ctxt)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	c := <-acquired
+	assert.NotNil(t, c)
+	ok, err = a.Release(ctxt)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, _ = b.Release(ctxt)
+	_, _ = c.Release(ctxt)
+	held, _ = s.Held(ctxt, name)
+	assert.Equal(t, 0, held)
+}
+
+func TestRedisSemaphore_Expire(t *testing.T) {
+	ctxt := context.Background()
+	s := newRedisSemaphore(t, 1, 150*time.Millisecond)
+	name := "expire-" + time.Now().String()
+
+	// Renewed by the watchdog beyond its lease
+	a, err := s.TryAcquire(ctxt, name)
+	assert.Nil(t, err)
+	time.Sleep(300 * time.Millisecond)
+	p, err := s.TryAcquire(ctxt, name)
+	assert.Nil(t, err)
+	assert.Nil(t, p)
+
+	// Its holder died
+	a.cancel()
+	b, err := s.Acquire(ctxt, name)
+	assert.Nil(t, err)
+	assert.NotNil(t, b)
+	ok, err := a.Release(ctxt)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, _ = b.Release(ctxt)
+}
+
+func TestRedisSemaphore_Lost(t *testing.T) {
+	ctxt := context.Background()
+	s := newRedisSemaphore(t, 1, 150*time.Millisecond)
+	name := "lost-" + time.Now().String()
+
+	p, err := s.TryAcquire(ctxt, name)
+	assert.Nil(t, err)
+	// Taken away behind the back of the watchdog
+	_, err = releasePermitScript.Run(ctxt, s.redis, []string{s.key(name)}, p.ID).Result()
+	assert.Nil(t, err)
+	select {
+	case <-p.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("permit not lost")
+	}
+}
+
+func TestRedisSemaphore_Concurrent(t *testing.T) {
+	ctxt := context.Background()
+	s := newRedisSemaphore(t, 3, time.Second)
+	name := "concurrent-" + time.Now().String()
+
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	for range 12 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := s.Acquire(ctxt, name)
+			if !assert.Nil(t, err) {
+				return
+			}
+			n := running.Add(1)
+			for m := peak.Load(); n > m && !peak.CompareAndSwap(m, n); m = peak.Load() {
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+			_, _ = p.Release(ctxt)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), peak.Load())
+}
+
+func TestRedisSemaphore_UnsetRetry(t *testing.T) {
+	ctxt := context.Background()
+	s := newRedisSemaphore(t, 1, time.Second)
+	s.props.Retry = Backoff{}
+	scripts := &counting{Scripter: s.redis}
+	s.redis = scripts
+	name := "unset-" + time.Now().String()
+
+	p, err := s.TryAcquire(ctxt, name)
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(ctxt, 200*time.Millisecond)
+	defer cancel()
+	_, err = s.Acquire(ctx, name)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, scripts.runs.Load(), int32(10), "attempts are spaced")
+	_, _ = p.Release(ctxt)
+}
@@ -12,7 +12,10 @@ type Metric[T any] struct {
 
 type innerCounter Metric[metric.Int64Counter]
 type innerHistogram Metric[metric.Float64Histogram]
-type innerGauge Metric[metric.Int64ObservableGauge]
+type innerGauge struct {
+	Metric[metric.Int64ObservableGauge]
+	meter metric.Meter
+}
 
 func (m innerCounter) Error() error {
 	return m.err
@@ -37,3 +40,13 @@ func (m innerHistogram) Error() error {
 func (m innerGauge) Error() error {
 	return m.err
 }
+
+func (m innerGauge) Observe(fn func(ctx context.Context) int64, options ...metric.ObserveOption) (metric.Registration, error) {
+	if m.Instance == nil {
+		return nil, m.err
+	}
+	return m.meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		o.ObserveInt64(m.Instance, fn(ctx), options...)
+		return nil
+	}, m.Instance)
+}
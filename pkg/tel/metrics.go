@@ -2,15 +2,19 @@ package tel
 
 import (
 	"context"
+	"sync"
 
 	"github.com/gantries/knife/pkg/maps"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/metric"
 )
 
-var meter metric.Meter
+// meter delegates to the global provider until SetupOTelSDK replaces it, so
+// instruments created early are still usable.
+var meter = otel.Meter("knife")
 
 var (
+	mutex      sync.Mutex
 	counts     = maps.Map[string, SimpleCounter]{}
 	histograms = maps.Map[string, SimpleHistogram]{}
 	gauges     = maps.Map[string, SimpleGauge]{}
@@ -31,10 +35,15 @@ type SimpleHistogram interface {
 }
 
 type SimpleGauge interface {
+	// Observe reports the value returned by fn every time metrics are
+	// collected, until the registration is unregistered.
+	Observe(fn func(ctx context.Context) int64, options ...metric.ObserveOption) (metric.Registration, error)
 	Error() error
 }
 
 func Counter(name string) SimpleCounter {
+	mutex.Lock()
+	defer mutex.Unlock()
 	if counts.Has(name) {
 		return *(counts.Get(name))
 	}
@@ -50,6 +59,8 @@ func Counter(name string) SimpleCounter {
 }
 
 func Histogram(name string) SimpleHistogram {
+	mutex.Lock()
+	defer mutex.Unlock()
 	if histograms.Has(name) {
 		return *(histograms.Get(name))
 	}
@@ -68,11 +79,13 @@ func Histogram(name string) SimpleHistogram {
 }
 
 func Gauge(name string) SimpleGauge {
+	mutex.Lock()
+	defer mutex.Unlock()
 	if gauges.Has(name) {
 		return *(gauges.Get(name))
 	}
 
-	i := innerGauge{}
+	i := innerGauge{meter: meter}
 
 	g, err := meter.Int64ObservableGauge(name)
 	if err != nil {
@@ -0,0 +1,43 @@
+package tel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestGauge_Observe(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	previous := meter
+	meter = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	defer func() { meter = previous }()
+
+	value := int64(1)
+	g := Gauge("knife.test.gauge")
+	assert.Nil(t, g.Error())
+	reg, err := g.Observe(func(ctx context.Context) int64 { return value })
+	assert.Nil(t, err)
+
+	collect := func() []metricdata.DataPoint[int64] {
+		var rm metricdata.ResourceMetrics
+		assert.Nil(t, reader.Collect(context.Background(), &rm))
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				if m.Name == "knife.test.gauge" {
+					return m.Data.(metricdata.Gauge[int64]).DataPoints
+				}
+			}
+		}
+		return nil
+	}
+	value = 7
+	points := collect()
+	assert.Len(t, points, 1)
+	assert.Equal(t, int64(7), points[0].Value)
+
+	assert.Nil(t, reg.Unregister())
+	assert.Empty(t, collect())
+}